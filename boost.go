@@ -0,0 +1,51 @@
+package schellydule
+
+import (
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// Boost returns s with a run from start lasting length added. The boost has no
+// cost, since its price isn't known. If deduct is true, the runtime the boost
+// adds is taken from the runs after it, latest first, so the total runtime of
+// s stays the same as far as possible.
+func Boost(s sch.Schedule, start time.Time, length time.Duration, deduct bool) sch.Schedule {
+	stop := start.Add(length)
+	rv := Compact(append(append(sch.Schedule{}, s...), sch.Entry{Start: start, Stop: stop}))
+	if !deduct {
+		return rv
+	}
+	excess := totalRuntime(rv) - totalRuntime(s)
+	for i := len(rv) - 1; i >= 0 && excess > 0; i-- {
+		e := &rv[i]
+		if e.Start.Before(stop) {
+			break
+		}
+		d := e.Stop.Sub(e.Start)
+		if d > excess {
+			// Shorten the run from its end, and its cost with it
+			e.Cost *= float64(d-excess) / float64(d)
+			e.Stop = e.Stop.Add(-excess)
+			break
+		}
+		excess -= d
+		e.Stop = e.Start
+	}
+	out := rv[:0]
+	for _, e := range rv {
+		if e.Stop.After(e.Start) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// totalRuntime returns the total time s runs, counting overlapping entries once
+func totalRuntime(s sch.Schedule) time.Duration {
+	var rv time.Duration
+	for _, e := range Compact(s) {
+		rv += e.Stop.Sub(e.Start)
+	}
+	return rv
+}
@@ -0,0 +1,68 @@
+package schellydule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+func TestBoost(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     sch.Schedule
+		start  int
+		length time.Duration
+		deduct bool
+		want   sch.Schedule
+	}{
+		{
+			name:   "added",
+			in:     sch.Schedule{entry(10, 12, 2), entry(20, 22, 2)},
+			start:  1,
+			length: 2 * time.Hour,
+			want:   sch.Schedule{entry(1, 3, 0), entry(10, 12, 2), entry(20, 22, 2)},
+		},
+		{
+			name:   "last run shortened",
+			in:     sch.Schedule{entry(10, 12, 2), entry(20, 22, 2)},
+			start:  1,
+			length: time.Hour,
+			deduct: true,
+			want:   sch.Schedule{entry(1, 2, 0), entry(10, 12, 2), entry(20, 21, 1)},
+		},
+		{
+			name:   "runs removed",
+			in:     sch.Schedule{entry(10, 12, 2), entry(20, 22, 2)},
+			start:  1,
+			length: 3 * time.Hour,
+			deduct: true,
+			want:   sch.Schedule{entry(1, 4, 0), entry(10, 11, 1)},
+		},
+		{
+			name:   "overlap with a run isn't deducted",
+			in:     sch.Schedule{entry(2, 4, 2), entry(20, 22, 2)},
+			start:  1,
+			length: 2 * time.Hour,
+			deduct: true,
+			want:   sch.Schedule{entry(1, 4, 2), entry(20, 21, 1)},
+		},
+		{
+			name:   "earlier runs are kept",
+			in:     sch.Schedule{entry(1, 3, 2)},
+			start:  10,
+			length: time.Hour,
+			deduct: true,
+			want:   sch.Schedule{entry(1, 3, 2), entry(10, 11, 0)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := day.Add(time.Duration(tt.start) * time.Hour)
+			if got := Boost(tt.in, start, tt.length, tt.deduct); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Boost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package schellydule
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Event is an event in a calendar
+type Event struct {
+	// UID identifies the event, so calendars can update it
+	UID   string
+	Start time.Time
+	Stop  time.Time
+	// AllDay events only use the dates of Start and Stop
+	AllDay      bool
+	Summary     string
+	Description string
+	Categories  []string
+	// RRule is the recurrence rule of a recurring event. RDates are extra
+	// starts, and ExDates starts left out.
+	RRule   string
+	RDates  []time.Time
+	ExDates []time.Time
+}
+
+const (
+	icsTime = "20060102T150405Z"
+	icsDate = "20060102"
+)
+
+// WriteCalendar writes events to w as an iCalendar (RFC 5545) calendar called name
+func WriteCalendar(w io.Writer, name string, events []Event) error {
+	var b strings.Builder
+	line := func(s string) {
+		b.WriteString(foldLine(s))
+		b.WriteString("\r\n")
+	}
+	now := time.Now().UTC().Format(icsTime)
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//schellydule//schellydule//EN")
+	line("CALSCALE:GREGORIAN")
+	line("X-WR-CALNAME:" + escapeText(name))
+	for _, e := range events {
+		line("BEGIN:VEVENT")
+		line("UID:" + escapeText(e.UID))
+		line("DTSTAMP:" + now)
+		if e.AllDay {
+			line("DTSTART;VALUE=DATE:" + e.Start.Format(icsDate))
+			line("DTEND;VALUE=DATE:" + e.Stop.Format(icsDate))
+		} else {
+			line("DTSTART:" + e.Start.UTC().Format(icsTime))
+			line("DTEND:" + e.Stop.UTC().Format(icsTime))
+		}
+		line("SUMMARY:" + escapeText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION:" + escapeText(e.Description))
+		}
+		if len(e.Categories) > 0 {
+			cats := make([]string, len(e.Categories))
+			for i, c := range e.Categories {
+				cats[i] = escapeText(c)
+			}
+			line("CATEGORIES:" + strings.Join(cats, ","))
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeText escapes s for use as an iCalendar text value
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// foldLine folds s into lines of at most 75 octets, continued by a space,
+// without splitting UTF-8 characters
+func foldLine(s string) string {
+	const max = 75
+	if len(s) <= max {
+		return s
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > max {
+			b.WriteString("\r\n ")
+			// The space counts towards the length of the continuation line
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
+
+// FormatDuration formats d as hours and minutes, like "2h30m"
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < 0 {
+		return "-" + FormatDuration(-d)
+	}
+	h, m := d/time.Hour, d%time.Hour/time.Minute
+	if m == 0 {
+		return fmt.Sprintf("%dh", h)
+	}
+	return fmt.Sprintf("%dh%02dm", h, m)
+}
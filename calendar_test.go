@@ -0,0 +1,78 @@
+package schellydule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteCalendar(t *testing.T) {
+	events := []Event{
+		{
+			UID:         "pool-20220701T1300",
+			Start:       day.Add(13 * time.Hour),
+			Stop:        day.Add(15 * time.Hour),
+			Summary:     "pool running",
+			Description: "Cost 2.50, average price 1.25 per kWh; estimated",
+		},
+		{
+			UID:     "pool-20220630",
+			Start:   day.AddDate(0, 0, -1),
+			Stop:    day,
+			AllDay:  true,
+			Summary: "pool ran 5h30m of 6h",
+		},
+	}
+	var b strings.Builder
+	if err := WriteCalendar(&b, "pool", events); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:pool\r\n",
+		"DTSTART:20220701T130000Z\r\n",
+		"DTEND:20220701T150000Z\r\n",
+		`DESCRIPTION:Cost 2.50\, average price 1.25 per kWh\; estimated` + "\r\n",
+		"DTSTART;VALUE=DATE:20220630\r\n",
+		"DTEND;VALUE=DATE:20220701\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WriteCalendar() is missing %q", want)
+		}
+	}
+	if n := strings.Count(got, "BEGIN:VEVENT"); n != 2 {
+		t.Errorf("WriteCalendar() wrote %d events, want 2", n)
+	}
+}
+
+func TestFoldLine(t *testing.T) {
+	long := "DESCRIPTION:" + strings.Repeat("æ", 60)
+	got := foldLine(long)
+	for _, l := range strings.Split(got, "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("foldLine() line is %d octets, want at most 75", len(l))
+		}
+	}
+	if unfolded := strings.ReplaceAll(got, "\r\n ", ""); unfolded != long {
+		t.Errorf("foldLine() unfolds to %q, want %q", unfolded, long)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{d: 2 * time.Hour, want: "2h"},
+		{d: 90 * time.Minute, want: "1h30m"},
+		{d: 5 * time.Minute, want: "0h05m"},
+		{d: -45 * time.Minute, want: "-0h45m"},
+	}
+	for _, tt := range tests {
+		if got := FormatDuration(tt.d); got != tt.want {
+			t.Errorf("FormatDuration(%s) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adamhassel/errors"
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/contx"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// ErrOverridden is returned when a device can't be boosted, because its
+// schedule is overridden
+var ErrOverridden = errors.New("schedule is overridden")
+
+// boost is the response of boostHandler
+type boost struct {
+	Device string    `json:"device"`
+	Start  time.Time `json:"start"`
+	// Until is when the switch turns off again. It's later than the end of the
+	// boost if the boost runs into a scheduled run.
+	Until time.Time `json:"until"`
+	// Deduct is true if the boost was taken from later runs
+	Deduct bool `json:"deduct"`
+	// Schedule is what's left of today's schedule
+	Schedule schedule.Schedule `json:"schedule"`
+}
+
+// startBoost turns dev at ip on now for length, or until midnight, and adds
+// the run to today's schedule on the device. With deduct, the added runtime is
+// taken from the later runs of the day.
+func startBoost(ctx context.Context, dev config.Device, ip fmt.Stringer, length time.Duration, deduct bool) (boost, error) {
+	loc := deviceZone(ctx, dev, ip)
+	now := time.Now().In(loc).Truncate(time.Second)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	if now.Add(length).After(midnight) {
+		length = midnight.Sub(now)
+	}
+	b, today, err := addRun(ctx, dev, ip, now, length, deduct)
+	if err != nil || contx.Pretend(ctx) {
+		return b, err
+	}
+	if runtimes != nil {
+		runtimes.AddBoost(dev.Name(), now, length)
+		recordPlanned(dev, now, today)
+	}
+	log.Printf("device %s: boosted for %s, until %s", dev.Name(), length, b.Until.Format(time.RFC3339))
+	return b, nil
+}
+
+// addRun turns dev at ip on from now for length, and adds the run to today's
+// schedule on the device. With deduct, the added runtime is taken from the
+// later runs of the day. It returns the whole of today's schedule with the run.
+func addRun(ctx context.Context, dev config.Device, ip fmt.Stringer, now time.Time, length time.Duration, deduct bool) (boost, schedule.Schedule, error) {
+	b, today, err := changeToday(ctx, dev, ip, now, func(s schedule.Schedule) schedule.Schedule {
+		return schellydule.Boost(s, now, length, deduct)
+	})
+	b.Deduct = deduct
+	return b, today, err
+}
+
+// changeToday replaces today's schedule on dev at ip with what change makes of
+// it, and turns the switch on if the new schedule runs at now. It returns the
+// whole of today's new schedule.
+func changeToday(ctx context.Context, dev config.Device, ip fmt.Stringer, now time.Time, change func(schedule.Schedule) schedule.Schedule) (boost, schedule.Schedule, error) {
+	b := boost{Device: dev.Name(), Start: now}
+	if o, ok := activeOverride(dev); ok {
+		return b, nil, fmt.Errorf("%w: %s until %s", ErrOverridden, o.Mode, o.Until.Format(time.RFC3339))
+	}
+
+	schedules, err := shelly.GetSchedules(ctx, ip)
+	if err != nil {
+		return b, nil, err
+	}
+	current, err := schellydule.ScheduleIn(schedules, now.Location())
+	if err != nil {
+		return b, nil, err
+	}
+	today := change(current)
+	// Runs that are over stay out, or the Shelly would run them tomorrow
+	install := schellydule.Plan{}
+	for _, e := range today {
+		if e.Stop.After(now) {
+			install.Schedule = append(install.Schedule, e)
+		}
+		if !e.Start.After(now) && e.Stop.After(now) {
+			b.Until = e.Stop
+		}
+	}
+	lastPlans.Lock()
+	install.Premium = lastPlans.m[dev.Name()].Premium
+	lastPlans.Unlock()
+	if err := installPlan(ctx, dev, ip, install); err != nil {
+		return b, nil, err
+	}
+	b.Schedule = install.Schedule
+	if b.Until.IsZero() {
+		return b, today, nil
+	}
+	// The Shelly turns the switch off by itself, also if the schedule is
+	// disabled by the input
+	if err := shelly.SetSwitchFor(ctx, ip, shelly.StateOn, b.Until.Sub(now)); err != nil {
+		return b, nil, err
+	}
+	return b, today, nil
+}
+
+// boostHandler turns the device on now for `minutes` minutes, by adding a run
+// to today's schedule. With `deduct=true`, the runtime is taken from later
+// runs, so the device runs the same time in total.
+func boostHandler(w http.ResponseWriter, req *http.Request) {
+	ctx := contx.ProcessCommon(req)
+	ip, err := getIP(req, true)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	q := req.URL.Query()
+	minutes, err := strconv.Atoi(q.Get("minutes"))
+	if err != nil || minutes < 1 {
+		setStatusMsg(w, http.StatusBadRequest, "minutes must be a positive number of minutes")
+		return
+	}
+	var deduct bool
+	if d := q.Get("deduct"); d != "" {
+		if deduct, err = strconv.ParseBool(d); err != nil {
+			setStatusMsg(w, http.StatusBadRequest, fmt.Sprintf("deduct: %s", err))
+			return
+		}
+	}
+	b, err := startBoost(ctx, dev, ip, time.Duration(minutes)*time.Minute, deduct)
+	switch {
+	case errors.Is(err, ErrOverridden):
+		setStatusMsg(w, http.StatusConflict, err)
+	case err != nil:
+		setStatusMsg(w, http.StatusBadGateway, err)
+	default:
+		writeJSON(w, http.StatusOK, b)
+	}
+}
+
+// boostCommand asks the running service to boost a device
+func boostCommand(args []string) error {
+	fs := flag.NewFlagSet("boost", flag.ExitOnError)
+	server := fs.String("server", fmt.Sprintf("http://localhost:%d", port), "address of the running service")
+	device := fs.String("device", "", "name of the device. Default is the default device")
+	minutes := fs.Int("minutes", 60, "minutes to run")
+	deduct := fs.Bool("deduct", false, "take the runtime from today's later runs")
+	pretend := fs.Bool("pretend", false, "don't change anything on the Shelly")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	query := url.Values{}
+	query.Set("minutes", strconv.Itoa(*minutes))
+	if *deduct {
+		query.Set("deduct", "true")
+	}
+	if *device != "" {
+		query.Set("device", *device)
+	}
+	if *pretend {
+		query.Set("pretend", "true")
+	}
+	return callService(*server, "/boost", query)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// calendarHandler returns the schedule of the device as an iCalendar feed:
+// today's schedule from the Shelly, tomorrow's if prices are available, and
+// the runtime of the last `days` days (default 7) as all-day events. Costs are
+// estimated for a load of `watts` (default 1000).
+func calendarHandler(w http.ResponseWriter, req *http.Request) {
+	ip, err := getIP(req, true)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	q := req.URL.Query()
+	watts := 1000.0
+	if ws := q.Get("watts"); ws != "" {
+		if watts, err = strconv.ParseFloat(ws, 64); err != nil {
+			setStatusMsg(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	days := 7
+	if d, err := strconv.Atoi(q.Get("days")); err == nil && d >= 0 {
+		days = d
+	}
+	loc := deviceZone(req.Context(), dev, ip)
+
+	var events []schellydule.Event
+	today, err := deviceSchedule(req, ip, loc)
+	if err != nil {
+		log.Printf("device %s: error getting today's schedule, recalculating: %s", dev.Name(), err)
+		plan, err := reqGenerateSchedule(q, dev, false, loc)
+		if err != nil {
+			setStatusMsg(w, http.StatusBadGateway, err)
+			return
+		}
+		today = plan.Schedule
+	}
+	events = append(events, runEvents(dev, today, "scheduled", watts)...)
+	if plan, err := reqGenerateSchedule(q, dev, true, loc); err != nil {
+		log.Printf("device %s: no schedule for tomorrow yet: %s", dev.Name(), err)
+	} else {
+		events = append(events, runEvents(dev, plan.Schedule, "planned", watts)...)
+	}
+	if runtimes != nil && days > 0 {
+		now := time.Now().In(loc)
+		history := runtimes.Days(dev.Name(), now.AddDate(0, 0, -days), now.AddDate(0, 0, -1))
+		dates := make([]string, 0, len(history))
+		for date := range history {
+			dates = append(dates, date)
+		}
+		sort.Strings(dates)
+		for _, date := range dates {
+			day, err := time.ParseInLocation(dateFormat, date, loc)
+			if err != nil {
+				continue
+			}
+			d := history[date]
+			events = append(events, historyEvent(dev, day, d.Planned, d.Delivered, d.Energy))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", dev.Name()+".ics"))
+	if err := schellydule.WriteCalendar(w, "schellydule "+dev.Name(), events); err != nil {
+		log.Printf("error writing calendar: %s", err)
+	}
+}
+
+// deviceSchedule returns the schedule installed on the Shelly at ip
+func deviceSchedule(req *http.Request, ip fmt.Stringer, loc *time.Location) (schedule.Schedule, error) {
+	schedules, err := shelly.GetSchedules(req.Context(), ip)
+	if err != nil {
+		return nil, err
+	}
+	return schellydule.ScheduleIn(schedules, loc)
+}
+
+// runEvents returns an event for each run in s. state describes the runs, like
+// "scheduled"
+func runEvents(dev config.Device, s schedule.Schedule, state string, watts float64) []schellydule.Event {
+	rv := make([]schellydule.Event, 0, len(s))
+	for _, e := range schellydule.Compact(s) {
+		length := e.Stop.Sub(e.Start)
+		cost := e.Cost * watts / 1000
+		desc := fmt.Sprintf("Run %s for %s. Estimated cost %.2f for %.0f W", state, schellydule.FormatDuration(length), cost, watts)
+		if length > 0 {
+			desc += fmt.Sprintf(", average price %.3f per kWh", e.Cost/length.Hours())
+		}
+		rv = append(rv, schellydule.Event{
+			UID:         fmt.Sprintf("%s-%s@schellydule", dev.Name(), e.Start.UTC().Format("20060102T1504")),
+			Start:       e.Start,
+			Stop:        e.Stop,
+			Summary:     dev.Name() + " running",
+			Description: desc,
+		})
+	}
+	return rv
+}
+
+// historyEvent returns an all-day event with the runtime of dev on day
+func historyEvent(dev config.Device, day time.Time, planned, delivered time.Duration, energy float64) schellydule.Event {
+	desc := fmt.Sprintf("Planned %s, ran %s", schellydule.FormatDuration(planned), schellydule.FormatDuration(delivered))
+	if energy > 0 {
+		desc += fmt.Sprintf(", used %.2f kWh", energy/1000)
+	}
+	return schellydule.Event{
+		UID:         fmt.Sprintf("%s-%s@schellydule", dev.Name(), day.Format("20060102")),
+		Start:       day,
+		Stop:        day.AddDate(0, 0, 1),
+		AllDay:      true,
+		Summary:     fmt.Sprintf("%s ran %s of %s", dev.Name(), schellydule.FormatDuration(delivered), schellydule.FormatDuration(planned)),
+		Description: desc,
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// commands are the subcommands of sched. Without a subcommand, sched runs the
+// web service.
+var commands = map[string]func(args []string) error{
+	"boost":    boostCommand,
+	"deadline": deadlineCommand,
+	"override": overrideCommand,
+	"profile":  profileCommand,
+	"simulate": simulateCommand,
+}
+
+// runCommand runs the subcommand named in args[0]
+func runCommand(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd(args[1:])
+}
+
+// callService calls endpoint on the running service with query, and prints the response
+func callService(server, endpoint string, query url.Values) error {
+	u, err := url.Parse(server)
+	if err != nil {
+		return err
+	}
+	u.Path = endpoint
+	u.RawQuery = query.Encode()
+	r, err := http.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", endpoint, r.Status, body)
+	}
+	fmt.Println(string(body))
+	return nil
+}
+
+// deadlineCommand asks the running service to install a one-off run, finished
+// by a given time of day
+func deadlineCommand(args []string) error {
+	fs := flag.NewFlagSet("deadline", flag.ExitOnError)
+	server := fs.String("server", fmt.Sprintf("http://localhost:%d", port), "address of the running service")
+	device := fs.String("device", "", "name of the device to schedule. Default is the default device")
+	hours := fs.Int("hours", 0, "number of hours to run. Default is the configured runtime")
+	minutes := fs.Int("minutes", 0, "number of minutes to run, instead of -hours")
+	by := fs.String("by", "", "time of day (HH:MM) the run must be finished by")
+	from := fs.String("from", "", "earliest time of day (HH:MM) to start. Default is now")
+	pretend := fs.Bool("pretend", false, "don't change anything on the Shelly")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *by == "" {
+		return fmt.Errorf("-by is required")
+	}
+	query := url.Values{}
+	query.Set("by", *by)
+	if *from != "" {
+		query.Set("from", *from)
+	}
+	if *hours != 0 {
+		query.Set("hours", strconv.Itoa(*hours))
+	}
+	if *minutes != 0 {
+		query.Set("minutes", strconv.Itoa(*minutes))
+	}
+	if *device != "" {
+		query.Set("device", *device)
+	}
+	if *pretend {
+		query.Set("pretend", "true")
+	}
+	return callService(*server, "/deadline", query)
+}
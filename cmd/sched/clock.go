@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/adamhassel/errors"
+	"github.com/adamhassel/power"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// verifyClock checks the clock and time zone of the Shelly at ip for dev.
+// Problems are logged and returned, and with `sync_clock` the configured time
+// zone and time server are set on the Shelly. An error is only returned if dev
+// refuses schedules on a Shelly with a wrong clock.
+func verifyClock(ctx context.Context, dev config.Device, ip fmt.Stringer) ([]string, error) {
+	if dev.ClockCheck() == config.ClockOff {
+		return nil, nil
+	}
+	refuse := dev.ClockCheck() == config.ClockRefuse
+	clock, err := shelly.GetClock(ctx, ip)
+	if err != nil {
+		log.Printf("device %s: error reading clock: %s", dev.Name(), err)
+		if refuse {
+			return nil, fmt.Errorf("reading clock: %w", err)
+		}
+		return nil, nil
+	}
+	problems := clock.Verify(deviceZone(ctx, dev, ip), dev.MaxSkew())
+	if len(problems) == 0 {
+		return nil, nil
+	}
+	msgs := make([]string, 0, len(problems)+1)
+	for _, p := range problems {
+		msgs = append(msgs, p.Error())
+		log.Printf("device %s: %s", dev.Name(), p)
+	}
+	// Only a configured time zone is set, since otherwise the time zone is
+	// the one read from the Shelly
+	if dev.SyncClock() && (dev.TimeZone() != nil || dev.SNTPServer() != "") {
+		if err := shelly.SetClock(ctx, ip, dev.TimeZone(), dev.SNTPServer()); err != nil {
+			log.Printf("device %s: error setting time zone and time server: %s", dev.Name(), err)
+		} else {
+			msgs = append(msgs, "time zone and time server set on the device")
+		}
+	}
+	if refuse {
+		return msgs, fmt.Errorf("not trusting the device's clock: %w (%s)", problems[0], strings.Join(msgs, "; "))
+	}
+	return msgs, nil
+}
+
+// retryRenewal returns true if a renewal that failed with err should be
+// retried: when the power prices aren't out yet, or the Shelly's clock may be
+// set by the time of the next attempt
+func retryRenewal(err error) bool {
+	return errors.Is(err, power.ErrEloverblik) || errors.Is(err, shelly.ErrClockNotSet) || errors.Is(err, shelly.ErrClockSkew)
+}
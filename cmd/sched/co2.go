@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// co2MaxAge is how long CO2 intensities are used before they're read again.
+// The forecast is updated during the day
+const co2MaxAge = 15 * time.Minute
+
+// co2Timeout is the time allowed for reading CO2 intensities
+const co2Timeout = 30 * time.Second
+
+// co2Cache holds the CO2 intensities last read, by period
+var co2Cache = struct {
+	sync.Mutex
+	m map[string]cachedIntensities
+}{m: make(map[string]cachedIntensities)}
+
+type cachedIntensities struct {
+	in   schellydule.Intensities
+	read time.Time
+}
+
+// co2Source returns the configured source of CO2 intensity, or nil if there's
+// none. Times without a time zone are in loc
+func co2Source(loc *time.Location) schellydule.CO2Source {
+	conf := config.GetConf()
+	switch conf.CO2Source() {
+	case "":
+		return nil
+	case config.CO2EnergiDataService:
+		return schellydule.EnergiDataService{Area: conf.CO2Area()}
+	}
+	return schellydule.CO2File{Path: conf.CO2Source(), Loc: loc}
+}
+
+// co2Intensities returns the CO2 intensity of the hours from `from` until `to`
+func co2Intensities(from, to time.Time) (schellydule.Intensities, error) {
+	src := co2Source(from.Location())
+	if src == nil {
+		return nil, fmt.Errorf("%w: no co2_source configured", schellydule.ErrInfeasible)
+	}
+	key := from.UTC().Format(time.RFC3339) + " " + to.UTC().Format(time.RFC3339)
+	co2Cache.Lock()
+	cached, ok := co2Cache.m[key]
+	co2Cache.Unlock()
+	if ok && time.Since(cached.read) <= co2MaxAge {
+		return cached.in, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), co2Timeout)
+	defer cancel()
+	in, err := src.Intensity(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CO2 intensity: %w", err)
+	}
+	co2Cache.Lock()
+	co2Cache.m[key] = cachedIntensities{in: in, read: time.Now()}
+	co2Cache.Unlock()
+	return in, nil
+}
+
+// greenPlan selects n of slots by their CO2 emission, for StrategyGreenest, or
+// their price plus the CO2 weight of p, for StrategyWeighted. Costs are from the
+// prices of the slots.
+func greenPlan(p planParams, slots schellydule.Slots, n int, c schellydule.Constraints, maxBlocks int) (schellydule.Plan, error) {
+	if len(slots) == 0 {
+		return slots.Fit(n, c, maxBlocks)
+	}
+	priceWeight, co2Weight := 0.0, 1.0
+	if p.strategy == schellydule.StrategyWeighted {
+		if p.co2Weight <= 0 {
+			return schellydule.Plan{}, fmt.Errorf("%w: weighted strategy needs a CO2 weight", schellydule.ErrInfeasible)
+		}
+		priceWeight, co2Weight = 1, p.co2Weight
+	}
+	hour := slots[0].Start.Truncate(time.Hour)
+	in, err := co2Intensities(hour, slots[len(slots)-1].End())
+	if err != nil {
+		return schellydule.Plan{}, err
+	}
+	// A slot without a known intensity would look like the greenest
+	for _, e := range slots {
+		if _, ok := in.At(e.Start); !ok {
+			return schellydule.Plan{}, fmt.Errorf("%w: no CO2 intensity for %s", schellydule.ErrInfeasible, e.Start.Format("2006-01-02 15:04"))
+		}
+	}
+	slots = slots.WithCO2(in)
+	plan, err := slots.Weighted(priceWeight, co2Weight).Fit(n, c, maxBlocks)
+	if err != nil {
+		return schellydule.Plan{}, err
+	}
+	return slots.Reprice(plan), nil
+}
+
+// scheduleCO2 returns the grams of CO2 emitted by running a load of watts
+// according to s, or nil if the CO2 intensity isn't known
+func scheduleCO2(s schedule.Schedule, watts float64) *float64 {
+	if co2Source(time.Local) == nil {
+		return nil
+	}
+	var rv float64
+	if len(s) == 0 {
+		return &rv
+	}
+	from := s[0].Start.Truncate(time.Hour)
+	in, err := co2Intensities(from, s[len(s)-1].Stop)
+	if err != nil {
+		log.Printf("error getting the CO2 intensity of the schedule: %s", err)
+		return nil
+	}
+	rv = schellydule.Emissions(s, in) * watts / 1000
+	return &rv
+}
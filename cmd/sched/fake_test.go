@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// fakeIPs numbers the fake Shellies, since calls are configured by address
+var fakeIPs uint32
+
+// fakeShelly answers the RPC calls of the service like a Shelly with a switch,
+// an input and schedules
+type fakeShelly struct {
+	ip net.IP
+
+	mu     sync.Mutex
+	output bool
+	input  bool
+	apower float64
+	jobs   shelly.Schedules
+	nextID int
+	// held holds the calls of a method until its channel is closed
+	held map[string]chan struct{}
+	// entered gets the method of each held call when it arrives
+	entered chan string
+}
+
+// newFakeShelly starts a fake Shelly, with its input on so schedules are enabled
+func newFakeShelly(t *testing.T) *fakeShelly {
+	t.Helper()
+	f := &fakeShelly{
+		ip:      net.IPv4(192, 0, 2, byte(atomic.AddUint32(&fakeIPs, 1))),
+		input:   true,
+		held:    make(map[string]chan struct{}),
+		entered: make(chan string, 10),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	shelly.Configure(f.ip, shelly.Options{HTTP: &http.Client{Transport: toServer(srv.Listener.Addr().String())}})
+	return f
+}
+
+// conf returns the config of a device using f, with conf added
+func (f *fakeShelly) conf(conf string) string {
+	return fmt.Sprintf("shelly_ip = %q\ntimezone = \"UTC\"\nclock_check = \"off\"\n%s", f.ip, conf)
+}
+
+// hold holds the calls of method until the returned function is called
+func (f *fakeShelly) hold(method string) func() {
+	ch := make(chan struct{})
+	f.mu.Lock()
+	f.held[method] = ch
+	f.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			f.mu.Lock()
+			delete(f.held, method)
+			f.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// state returns the state of the switch, and if the switch jobs are enabled
+func (f *fakeShelly) state() (output bool, enabled bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, j := range f.jobs {
+		if j.HasMethod("switch.set") && j.Enable {
+			enabled = true
+		}
+	}
+	return f.output, enabled
+}
+
+func (f *fakeShelly) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     uint64          `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	ch, ok := f.held[req.Method]
+	f.mu.Unlock()
+	if ok {
+		f.entered <- req.Method
+		<-ch
+	}
+	result, err := f.call(req.Method, req.Params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": result})
+}
+
+// call makes the call of method with params, and returns its result
+func (f *fakeShelly) call(method string, params json.RawMessage) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch method {
+	case "Shelly.GetStatus":
+		return map[string]interface{}{
+			"input:0":  shelly.InputStatus{State: f.input},
+			"switch:0": shelly.SwitchStatus{Output: f.output, APower: f.apower},
+		}, nil
+	case "Switch.GetStatus":
+		return shelly.SwitchStatus{Output: f.output, APower: f.apower}, nil
+	case "Switch.Set":
+		var p struct {
+			On bool `json:"on"`
+		}
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		wasOn := f.output
+		f.output = p.On
+		return map[string]bool{"was_on": wasOn}, nil
+	case "Schedule.List":
+		return shelly.Schedule{Jobs: append(shelly.Schedules{}, f.jobs...)}, nil
+	case "Schedule.Create":
+		var j shelly.JobSpec
+		if err := json.Unmarshal(params, &j); err != nil {
+			return nil, err
+		}
+		f.nextID++
+		j.Id = f.nextID
+		f.jobs = append(f.jobs, j)
+		return map[string]int{"id": j.Id}, nil
+	case "Schedule.Update":
+		var u shelly.ScheduleUpdate
+		if err := json.Unmarshal(params, &u); err != nil {
+			return nil, err
+		}
+		for i, j := range f.jobs {
+			if j.Id == u.ID && u.Enable != nil {
+				f.jobs[i].Enable = *u.Enable
+			}
+		}
+		return map[string]interface{}{}, nil
+	case "Schedule.DeleteAll":
+		f.jobs = nil
+		return map[string]interface{}{}, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+// toServer sends all requests to the server at its address
+type toServer string
+
+func (s toServer) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Host = string(s)
+	return http.DefaultTransport.RoundTrip(r)
+}
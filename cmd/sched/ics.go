@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// calendarMaxAge is how long a calendar is used before it's read again
+const calendarMaxAge = 15 * time.Minute
+
+// calendarTimeout is the time allowed for reading a calendar
+const calendarTimeout = 30 * time.Second
+
+// calendarCache holds the events last read from each calendar, by source and
+// time zone
+var calendarCache = struct {
+	sync.Mutex
+	m map[string]cachedCalendar
+}{m: make(map[string]cachedCalendar)}
+
+type cachedCalendar struct {
+	events []schellydule.Event
+	read   time.Time
+}
+
+// calendarEvents returns the events of the configured calendars, with times
+// without a time zone in loc. If a calendar can't be read, the events last
+// read from it are used.
+func calendarEvents(loc *time.Location) []schellydule.Event {
+	var rv []schellydule.Event
+	for _, src := range config.GetConf().Calendars() {
+		key := src + " " + loc.String()
+		calendarCache.Lock()
+		cached, ok := calendarCache.m[key]
+		calendarCache.Unlock()
+		if !ok || time.Since(cached.read) > calendarMaxAge {
+			ctx, cancel := context.WithTimeout(context.Background(), calendarTimeout)
+			events, err := schellydule.LoadCalendar(ctx, src, loc)
+			cancel()
+			if err != nil {
+				log.Printf("error reading calendar %s: %s", src, err)
+			} else {
+				cached = cachedCalendar{events: events, read: time.Now()}
+				calendarCache.Lock()
+				calendarCache.m[key] = cached
+				calendarCache.Unlock()
+			}
+		}
+		rv = append(rv, cached.events...)
+	}
+	return rv
+}
+
+// unexpanded holds the recurring events already logged as not expanded, so
+// they're only logged once
+var unexpanded = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: make(map[string]bool)}
+
+// occurrences returns the occurrences of events from `from` to `to`. Recurring
+// events that can't be expanded are logged, and only their first occurrence is
+// used.
+func occurrences(events []schellydule.Event, from, to time.Time) []schellydule.Event {
+	var rv []schellydule.Event
+	for _, e := range events {
+		o, err := e.Occurrences(from, to)
+		if err != nil {
+			key := e.UID + " " + e.RRule
+			unexpanded.Lock()
+			if !unexpanded.m[key] {
+				unexpanded.m[key] = true
+				log.Printf("calendar event %q (%s): %s, only using its first occurrence", e.Summary, e.UID, err)
+			}
+			unexpanded.Unlock()
+			rv = append(rv, e)
+			continue
+		}
+		rv = append(rv, o...)
+	}
+	return rv
+}
+
+// calendarConstraints adds the events tagged for dev in the configured
+// calendars from `from` to `to` to c, as slots that are blocked or must run.
+// Times without a time zone are in the time zone of from.
+func calendarConstraints(dev config.Device, from, to time.Time, c *schellydule.Constraints) {
+	events := occurrences(calendarEvents(from.Location()), from, to)
+	blocked, run := schellydule.Intervals(events, dev.Name())
+	if len(blocked) > 0 {
+		c.Blocked = schellydule.Overlapping(blocked)
+	}
+	if len(run) > 0 {
+		c.Forced = schellydule.Overlapping(run)
+	}
+}
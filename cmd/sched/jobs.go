@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adamhassel/errors"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/contx"
+	"github.com/adamhassel/schellydule/jobs"
+)
+
+const (
+	// renewJob is the kind of the schedule renewal jobs
+	renewJob = "renew"
+	// renewInterval and renewAttempts retry a renewal every 10 minutes for 23
+	// hours
+	renewInterval = 10 * time.Minute
+	renewAttempts = int(23 * time.Hour / renewInterval)
+)
+
+// renewals runs the schedule renewals
+var renewals *jobs.Manager
+
+// runRenewal makes an attempt at renewing the schedule, with the parameters of
+// the renewSchedules request that started the job
+func runRenewal(ctx context.Context, j jobs.Job) error {
+	dev, ok := config.GetConf().GetDevice(j.Device)
+	if !ok {
+		return fmt.Errorf("%w: %s is no longer configured", ErrUnknownDevice, j.Device)
+	}
+	target := j.Target
+	if target == "" {
+		// Jobs from before the target was kept on the job
+		target = j.Params["ip"]
+	}
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return ErrInvalidIP
+	}
+	query, err := url.ParseQuery(j.Params["query"])
+	if err != nil {
+		return err
+	}
+	pretend, _ := strconv.ParseBool(j.Params["pretend"])
+	return generateAndSetSchedule(contx.WithPretend(ctx, pretend), query, dev, ip)
+}
+
+// writeJSON writes v as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		setStatusMsg(w, http.StatusInternalServerError, err)
+		return
+	}
+	setStatusMsg(w, status, out)
+}
+
+// jobsHandler lists the jobs, newest first. If `device` is given, only the
+// jobs of that device are listed.
+func jobsHandler(w http.ResponseWriter, req *http.Request) {
+	device := req.URL.Query().Get("device")
+	list := make([]jobs.Job, 0)
+	for _, j := range renewals.List() {
+		if device == "" || j.Device == device {
+			list = append(list, j)
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// jobHandler returns the status of the job in the path, /jobs/{id}. DELETE
+// cancels the job.
+func jobHandler(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/jobs/")
+	switch req.Method {
+	case http.MethodGet:
+		j, ok := renewals.Get(id)
+		if !ok {
+			setStatusMsg(w, http.StatusNotFound, jobs.ErrNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	case http.MethodDelete:
+		j, err := renewals.Cancel(id)
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			setStatusMsg(w, http.StatusNotFound, err)
+		case errors.Is(err, jobs.ErrFinished):
+			setStatusMsg(w, http.StatusConflict, err)
+		case err != nil:
+			setStatusMsg(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusOK, j)
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		setStatusMsg(w, http.StatusMethodNotAllowed, "use GET or DELETE")
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/adamhassel/errors"
@@ -20,6 +22,8 @@ import (
 	"github.com/adamhassel/schellydule"
 	"github.com/adamhassel/schellydule/config"
 	contx "github.com/adamhassel/schellydule/contx"
+	"github.com/adamhassel/schellydule/history"
+	"github.com/adamhassel/schellydule/jobs"
 	"github.com/adamhassel/schellydule/shelly"
 )
 
@@ -27,11 +31,19 @@ const defaultPort = 8080
 
 var confFile string
 
-//var conf config.Config
+// var conf config.Config
 var port int
 
 var ErrInvalidIP = errors.New("invalid IP in query")
 
+var ErrUnknownDevice = errors.New("unknown device in query")
+
+// lastPlans holds the most recently installed plan for each device
+var lastPlans = struct {
+	sync.Mutex
+	m map[string]schellydule.Plan
+}{m: make(map[string]schellydule.Plan)}
+
 func init() {
 	flag.StringVar(&confFile, "c", "schedule.conf", "location of configuration file.")
 	flag.IntVar(&port, "p", defaultPort, "port to listen on")
@@ -47,13 +59,75 @@ func main() {
 		log.Fatal("MID or Token invalid")
 	}
 
+	for _, d := range conf.Devices() {
+		if err := checkStrategy(d, len(conf.Calendars()) > 0); err != nil {
+			log.Fatalf("device %s: %s", d.Name(), err)
+		}
+		for _, name := range d.Profiles() {
+			pd, _ := d.WithProfile(name)
+			if err := checkStrategy(pd, len(conf.Calendars()) > 0); err != nil {
+				log.Fatalf("device %s: profile %s: %s", d.Name(), name, err)
+			}
+		}
+	}
+
+	configureShellies(conf)
+
 	if p := conf.Port(); p != 0 && port != defaultPort {
 		port = p
 	}
+
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	runtimes, err = history.Open(filepath.Join(conf.DataDir(), "history.json"), sampleInterval)
+	if err != nil {
+		log.Fatalf("error reading runtime history: %s", err)
+	}
+	go trackRuntime(conf)
+
+	// The state is loaded before anything resumed uses it
+	if err := loadOverrides(filepath.Join(conf.DataDir(), "overrides.json")); err != nil {
+		log.Fatalf("error reading overrides: %s", err)
+	}
+	if err := loadProfiles(filepath.Join(conf.DataDir(), "profiles.json")); err != nil {
+		log.Fatalf("error reading profiles: %s", err)
+	}
+	if err := loadApplied(filepath.Join(conf.DataDir(), "applied.json")); err != nil {
+		log.Fatalf("error reading installed schedules: %s", err)
+	}
+	if err := loadPending(filepath.Join(conf.DataDir(), "pending.json")); err != nil {
+		log.Fatalf("error reading pending runs: %s", err)
+	}
+
+	renewals, err = jobs.Open(filepath.Join(conf.DataDir(), "jobs.json"))
+	if err != nil {
+		log.Fatalf("error reading jobs: %s", err)
+	}
+	renewals.Register(renewJob, runRenewal, retryRenewal)
+	renewals.Resume()
+
+	startReconcilers(conf)
+	startMeter(conf)
+
 	http.HandleFunc("/enableSchedules", enableScheduleHandler)
 	http.HandleFunc("/disableSchedules", disableScheduleHandler)
 	http.HandleFunc("/renewSchedules", renewSchedulesHandler)
 	http.HandleFunc("/showSchedules", showSchedulesHandler)
+	http.HandleFunc("/deadline", deadlineHandler)
+	http.HandleFunc("/runtime", runtimeHandler)
+	http.HandleFunc("/simulate", simulateHandler)
+	http.HandleFunc("/calendar.ics", calendarHandler)
+	http.HandleFunc("/jobs", jobsHandler)
+	http.HandleFunc("/jobs/", jobHandler)
+	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/override", overrideHandler)
+	http.HandleFunc("/boost", boostHandler)
+	http.HandleFunc("/profile", profileHandler)
 
 	http.HandleFunc("/getInput", getInputHandler)
 
@@ -71,6 +145,34 @@ func parseIP(remoteAddr string) (net.IP, error) {
 	return net.ParseIP(ipaddr), nil
 }
 
+// getDevice returns the device named in the `device` query parameter. If no
+// device is named, the device configured with the IP in the `ip` query
+// parameter, or else with the IP the request comes from, is returned, falling
+// back to the default device.
+func getDevice(req *http.Request) (config.Device, error) {
+	conf := config.GetConf()
+	query := req.URL.Query()
+	if name := query.Get("device"); name != "" {
+		d, ok := conf.GetDevice(name)
+		if !ok {
+			return config.Device{}, fmt.Errorf("%w: %s", ErrUnknownDevice, name)
+		}
+		return d, nil
+	}
+	if ip := net.ParseIP(query.Get("ip")); ip != nil {
+		if d, ok := conf.DeviceByIP(ip); ok {
+			return d, nil
+		}
+	}
+	// A Shelly calling us, like the refresher of an older installation
+	if ip, err := parseIP(req.RemoteAddr); err == nil && ip != nil {
+		if d, ok := conf.DeviceByIP(ip); ok {
+			return d, nil
+		}
+	}
+	return conf.Device, nil
+}
+
 // getIP returns an IP from either the query parameter (if allowed), the
 // configuration or sets it to the originating request's IP.
 func getIP(req *http.Request, allowInQuery bool) (net.IP, error) {
@@ -83,10 +185,13 @@ func getIP(req *http.Request, allowInQuery bool) (net.IP, error) {
 	}
 	if ip == nil || !allowInQuery {
 		// If we have a configured IP, use that
-		if i := config.GetConf().IP(); i != nil {
+		dev, err := getDevice(req)
+		if err != nil {
+			return nil, err
+		}
+		if i := dev.IP(); i != nil {
 			return i, nil
 		}
-		var err error
 		ip, err = parseIP(req.RemoteAddr)
 		if err != nil {
 			return nil, err
@@ -95,32 +200,57 @@ func getIP(req *http.Request, allowInQuery bool) (net.IP, error) {
 	return ip, nil
 }
 
+// ipErrStatus returns the HTTP status to use for an error returned from getIP or getDevice
+func ipErrStatus(err error) int {
+	if errors.Is(err, ErrInvalidIP) || errors.Is(err, ErrUnknownDevice) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 func enableScheduleHandler(w http.ResponseWriter, req *http.Request) {
 	// find the originating IP, where we'll be sending the callbacks
 	ctx := contx.ProcessCommon(req)
 	ip, err := getIP(req, true)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, ErrInvalidIP) {
-			status = http.StatusBadRequest
-		}
-		setStatusMsg(w, status, err.Error())
+		setStatusMsg(w, ipErrStatus(err), err.Error())
 		return
 	}
-	//	1. Get list of all schedules
-	schedules, err := shelly.GetSchedules(ctx, ip)
+	dev, err := getDevice(req)
 	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	if err := enableSchedules(ctx, dev, ip); err != nil {
+		fmt.Println(err)
 		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
 
+	io.WriteString(w, "Schedule is on\n")
+	fmt.Println("schedule on")
+}
+
+// enableSchedules sets the switch of the Shelly at ip according to its
+// schedule, and enables the schedule
+func enableSchedules(ctx context.Context, dev config.Device, ip fmt.Stringer) error {
+	//	1. Get list of all schedules
+	schedules, err := shelly.GetSchedules(ctx, ip)
+	if err != nil {
+		return err
+	}
+
 	// 2. Set switch according to schedule
-	if err := setSwitchToSchedule(ctx, ip, schedules); err != nil {
-		w.WriteHeader(http.StatusBadGateway)
-		return
+	if err := setSwitchToSchedule(ctx, ip, schedules, deviceZone(ctx, dev, ip)); err != nil {
+		return err
 	}
 
 	//  3. Enable schedules
+	return shelly.EnableSchedules(ctx, ip, switchJobIDs(schedules)...)
+}
+
+// switchJobIDs returns the IDs of the jobs setting the switch
+func switchJobIDs(schedules shelly.Schedules) []int {
 	var ids = make([]int, 0, len(schedules))
 	for _, s := range schedules {
 		if !s.HasMethod("switch.set") {
@@ -128,45 +258,40 @@ func enableScheduleHandler(w http.ResponseWriter, req *http.Request) {
 		}
 		ids = append(ids, s.Id)
 	}
-	if err := shelly.EnableSchedules(ctx, ip, ids...); err != nil {
-		w.WriteHeader(http.StatusBadGateway)
-		return
-	}
-
-	io.WriteString(w, "Schedule is on\n")
-	fmt.Println("schedule on")
+	return ids
 }
 
-// setSwitchToSchedule refreshes the on/off state according to the schedule
-func setSwitchToSchedule(ctx context.Context, ip fmt.Stringer, schedules shelly.Schedules) error {
-	paired, err := schellydule.ScheduleToPaired(schedules)
+// setSwitchToSchedule refreshes the on/off state according to the schedule of a
+// device in the time zone loc
+func setSwitchToSchedule(ctx context.Context, ip fmt.Stringer, schedules shelly.Schedules, loc *time.Location) error {
+	on, err := scheduledOn(schedules, loc, time.Now())
 	if err != nil {
 		return err
 	}
-	// Determine if the schedules currently demand on or off
-	now := time.Now()
-	var on bool
+	//  3. Set switch to what the schedules demand
+	return shelly.SetSwitch(ctx, ip, shelly.State(on))
+}
+
+// scheduledOn returns true if the schedules of a device in the time zone loc
+// demand the switch to be on at now
+func scheduledOn(schedules shelly.Schedules, loc *time.Location, now time.Time) (bool, error) {
+	paired, err := schellydule.ScheduleToPairedIn(schedules, loc)
+	if err != nil {
+		return false, err
+	}
 	for _, p := range paired {
-		fmt.Printf("On at %s, off at %s\n", p.On.Format("15:04"), p.Off.Format("15:04"))
 		if now.After(p.On) && now.Before(p.Off) {
-			fmt.Printf("%s is after %s, but still before %s\n", now.Format("15:04"), p.On.Format("15:04"), p.Off.Format("15:04"))
-			on = true
-			break
+			return true, nil
 		}
 	}
-	//  3. Set switch to what the schedules demand
-	return shelly.SetSwitch(ctx, ip, shelly.State(on))
+	return false, nil
 }
 
 func disableScheduleHandler(w http.ResponseWriter, req *http.Request) {
 	ctx := contx.ProcessCommon(req)
 	ip, err := getIP(req, true)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, ErrInvalidIP) {
-			status = http.StatusBadRequest
-		}
-		setStatusMsg(w, status, err.Error())
+		setStatusMsg(w, ipErrStatus(err), err.Error())
 		return
 	}
 	// 1. Set switch "on"
@@ -176,24 +301,8 @@ func disableScheduleHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// 2. Get list of all schedules
-	schedules, err := shelly.GetSchedules(nil, ip)
-	if err != nil {
-		fmt.Println("getschedules", err)
-		w.WriteHeader(http.StatusBadGateway)
-		return
-	}
-
-	// 3. Disable schedules
-	// get IDs
-	var ids = make([]int, 0, len(schedules))
-	for _, s := range schedules {
-		if !s.HasMethod("switch.set") {
-			continue
-		}
-		ids = append(ids, s.Id)
-	}
-	if err := shelly.DisableSchedules(ctx, ip, ids...); err != nil {
+	// 2. Disable schedules
+	if err := disableSchedules(ctx, ip); err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusBadGateway)
 		return
@@ -203,18 +312,29 @@ func disableScheduleHandler(w http.ResponseWriter, req *http.Request) {
 	fmt.Println("schedule Off")
 }
 
+// disableSchedules disables the schedule of the Shelly at ip, leaving the
+// switch as it is
+func disableSchedules(ctx context.Context, ip fmt.Stringer) error {
+	schedules, err := shelly.GetSchedules(ctx, ip)
+	if err != nil {
+		return fmt.Errorf("getschedules: %w", err)
+	}
+	return shelly.DisableSchedules(ctx, ip, switchJobIDs(schedules)...)
+}
+
 // renewSchedulesHandler will flush existing schedules and generate a new set.
-// Should only be called after between 00:00 and 01:00 local time, and will return 400 if not
+// Should only be called after between 00:00 and 01:00 in the device's time zone, and will return 400 if not
 // (unless override active)
 func renewSchedulesHandler(w http.ResponseWriter, req *http.Request) {
 	ctx := contx.ProcessCommon(req)
 	ip, err := getIP(req, true)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, ErrInvalidIP) {
-			status = http.StatusBadRequest
-		}
-		setStatusMsg(w, status, err.Error())
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
 		return
 	}
 
@@ -222,80 +342,188 @@ func renewSchedulesHandler(w http.ResponseWriter, req *http.Request) {
 
 	// override allows you to force this endpoint to work at all hours of the day.
 	override, _ := strconv.ParseBool(query.Get("override")) // if parse error, just assume false and continue
-	now := time.Now()
+	now := time.Now().In(deviceZone(ctx, dev, ip))
 	if !override && now.Hour() != 0 {
 		setStatusMsg(w, http.StatusBadRequest, "come back between 00:00 and 01:00")
 		return
 	}
-	if err := generateAndSetSchedule(ctx, query, ip); err != nil {
-		if errors.Is(err, power.ErrEloverblik) {
-			go func() {
-				var i uint
-				var ok bool
-				// retry ev. 10 minutes for 23 hours
-				max := uint(23 * time.Hour * 6)
-				for RetryWait(!ok, &i, max, 10*time.Minute, nil) {
-					err = generateAndSetSchedule(ctx, query, ip)
-					ok = err == nil
-				}
-				log.Printf("after %d attempt(s), the result was %s", i, err)
-			}()
-			log.Print("error contacting eloverblik, retrying")
-			setStatusMsg(w, http.StatusAccepted, err)
-		} else {
-			log.Print("error generating schedule")
-			setStatusMsg(w, http.StatusBadGateway, err)
-		}
+	if _, err := schellydule.ParseStrategy(query.Get("strategy")); err != nil {
+		setStatusMsg(w, http.StatusBadRequest, err)
+		return
+	}
+	// The renewal runs as a job, which is retried if eloverblik fails. A renewal
+	// already being retried for the device and IP is returned instead of
+	// starting another.
+	params := map[string]string{
+		"query":   query.Encode(),
+		"pretend": strconv.FormatBool(contx.Pretend(ctx)),
+	}
+	job, created, err := renewals.Submit(renewJob, dev.Name(), ip.String(), params, renewAttempts, renewInterval)
+	if err != nil {
+		setStatusMsg(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("X-Job-Id", job.ID)
+	switch {
+	case !created:
+		log.Printf("device %s: renewal already in progress as job %s", dev.Name(), job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	case job.State == jobs.StateWaiting:
+		log.Print("error contacting eloverblik, retrying")
+		writeJSON(w, http.StatusAccepted, job)
+	case job.State == jobs.StateFailed:
+		log.Print("error generating schedule")
+		setStatusMsg(w, http.StatusBadGateway, job.LastError)
 	}
 	return
 }
 
-// RetryWait will increment counter, sleep for `sleep` and return true if retry should be attempted (`retryif` is true and attempts remaining).
-// Returns `false` immediately (not incrementing counter) if `retryif` is false or max retries exceeded.
-// Use for limited retries with (possibly zero-length) pause. Does not sleep on initial attempt (assuming counter starts at zero).
-// If `f` is non-nil, f will execute on exhaustion of attempts
-// Use in a loop, e.g. like:
-// func f() error {
-//   var i uint
-//   var ok bool
-//   f := func() {
-//  	log.Printf("attempts failed: %s", err)
-//   }
-//   for RetryWait(!ok, &i, maxAttempts, 0, f) {
-//	     err = do_stuff()
-//       ok = err == nil
-//   }
-//   return err
-// }
-func RetryWait(retryif bool, counter *uint, max uint, sleep time.Duration, f func()) bool {
-	if !retryif {
-		return false
+// deadlineHandler installs a one-off schedule running `hours` hours, finished
+// by the time of day `by`, starting no earlier than `from` (or now). The
+// cheapest hours in that window are selected.
+func deadlineHandler(w http.ResponseWriter, req *http.Request) {
+	ctx := contx.ProcessCommon(req)
+	ip, err := getIP(req, true)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	query := req.URL.Query()
+	if query.Get("by") == "" {
+		setStatusMsg(w, http.StatusBadRequest, "missing deadline parameter `by`")
+		return
+	}
+	loc := deviceZone(ctx, dev, ip)
+	p, err := reqPlanParams(query, dev, false, loc)
+	if err != nil {
+		setStatusMsg(w, http.StatusBadRequest, err)
+		return
+	}
+	p.strategy = schellydule.StrategyDeadline
+	// Without an earliest start, the window opens now
+	if query.Get("from") == "" {
+		p.earliest = p.by
+	}
+	// Start from the next slot, since the Shelly won't run a job that should
+	// have started in the past
+	now := time.Now().In(loc)
+	p.start = nextSlot(now, dev.SlotLength())
+	plan, err := planSchedule(dev, p)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, schellydule.ErrInfeasible) {
+			status = http.StatusBadRequest
+		}
+		setStatusMsg(w, status, err)
+		return
+	}
+	// Keep what's left of today's schedule on the device
+	schedules, err := shelly.GetSchedules(ctx, ip)
+	if err != nil {
+		setStatusMsg(w, http.StatusBadGateway, err)
+		return
 	}
-	if *counter >= max {
-		if f != nil {
-			f()
+	current, err := schellydule.ScheduleIn(schedules, loc)
+	if err != nil {
+		setStatusMsg(w, http.StatusInternalServerError, err)
+		return
+	}
+	install := plan
+	install.Schedule = append(schedule.Schedule{}, plan.Schedule...)
+	for _, e := range current {
+		if e.Stop.After(now) {
+			install.Schedule = append(install.Schedule, e)
 		}
-		return false
 	}
-	if *counter != 0 {
-		time.Sleep(sleep)
+	if err := installPlan(ctx, dev, ip, install); err != nil {
+		setStatusMsg(w, http.StatusBadGateway, err)
+		return
 	}
-	*counter++
-	return true
+	if !contx.Pretend(ctx) {
+		addPending(dev, plan.Schedule)
+	}
+	out, err := json.Marshal(plan.Schedule)
+	if err != nil {
+		setStatusMsg(w, http.StatusInternalServerError, err)
+		return
+	}
+	setStatusMsg(w, http.StatusOK, out)
 }
 
-func generateAndSetSchedule(ctx context.Context, query url.Values, ip fmt.Stringer) error {
-	hps, err := reqGenerateSchedule(query, false)
+func generateAndSetSchedule(ctx context.Context, query url.Values, dev config.Device, ip fmt.Stringer) error {
+	loc := deviceZone(ctx, dev, ip)
+	plan, err := reqGenerateSchedule(query, dev, false, loc)
 	if err != nil {
 		return fmt.Errorf("generateSchedule: %w", err)
 	}
+	// Runs of a deadline window after midnight are installed by the next
+	// renewal, since the Shelly would run them today as well
+	day := planDay(query, false, loc)
+	midnight := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc)
+	var today, later schedule.Schedule
+	for _, e := range plan.Schedule {
+		if e.Start.Before(midnight) {
+			today = append(today, e)
+		} else {
+			later = append(later, e)
+		}
+	}
+	plan.Schedule = today
+	if err := installPlan(ctx, dev, ip, plan); err != nil {
+		return err
+	}
+	if !contx.Pretend(ctx) {
+		addPending(dev, later)
+		recordPlanned(dev, time.Now().In(loc), plan.Schedule)
+	}
+	return nil
+}
+
+// withPending returns s with the runs of pending starting within a day from
+// now. The rest are kept for the next renewal. If that's more runs than fit on
+// dev, the runs closest to each other are joined.
+func withPending(dev config.Device, s, pending schedule.Schedule, now time.Time) schedule.Schedule {
+	rv := append(schedule.Schedule{}, s...)
+	for _, e := range pending {
+		if e.Start.Before(now.Add(24 * time.Hour)) {
+			rv = append(rv, e)
+		}
+	}
+	rv = schellydule.Compact(rv)
+	if maxBlocks := schellydule.MaxBlocks(dev.MaxJobs()); len(rv) > maxBlocks {
+		log.Printf("device %s: schedule has %d blocks, but only %d fit. Joining the closest", dev.Name(), len(rv), maxBlocks)
+		rv = schellydule.CompactTo(rv, maxBlocks)
+	}
+	return rv
+}
+
+// installPlan replaces the schedules on the device with plan, and any one-off
+// runs still pending for the device
+func installPlan(ctx context.Context, dev config.Device, ip fmt.Stringer, plan schellydule.Plan) error {
+	defer lockDevice(dev.Name())()
+	if _, err := verifyClock(ctx, dev, ip); err != nil {
+		return err
+	}
+	now := time.Now()
+	hps := withPending(dev, plan.Schedule, takePending(dev, now), now)
 
 	enable, err := shelly.GetInputState(ctx, ip)
 	if err != nil {
 		return err
 	}
+	// An override keeps the schedule disabled and the switch as it is, until it ends
+	if o, ok := activeOverride(dev); ok {
+		log.Printf("device %s: installing the schedule disabled, override %s until %s", dev.Name(), o.Mode, o.Until.Format(time.RFC3339))
+		enable = false
+	}
 
-	s := shelly.ShellySchedule(hps, enable)
+	loc := deviceZone(ctx, dev, ip)
+	s := shelly.ShellyScheduleIn(hps, enable, loc)
 	// FXIME: this is a hacky workaround, which is a quick-and-dirty fix for cron
 	// being annoying and not care about anything outside the current day (the way
 	// it's used here).
@@ -313,6 +541,12 @@ func generateAndSetSchedule(ctx context.Context, query url.Values, ip fmt.String
 		}
 	}
 
+	// Make sure the schedule fits before deleting the old one. One job is
+	// reserved for the refresher.
+	if jobs := len(s.Jobs) + 1; jobs > dev.MaxJobs() {
+		return fmt.Errorf("schedule needs %d jobs, device %s only has room for %d", jobs, dev.Name(), dev.MaxJobs())
+	}
+
 	//Delete all schedules
 	if err := shelly.DeleteAllSchedules(ctx, ip); err != nil {
 		return err
@@ -323,24 +557,99 @@ func generateAndSetSchedule(ctx context.Context, query url.Values, ip fmt.String
 
 	// Turn shelly on or off according to schedule, if schedules are enabled. If not, don't touch.
 	if enable {
-		if err := setSwitchToSchedule(ctx, ip, s.Jobs); err != nil {
+		if err := setSwitchToSchedule(ctx, ip, s.Jobs, loc); err != nil {
 			return err
 		}
 	}
 
-	if err := shelly.CreateScheduleRefresherSchedule(ctx, ip, port); err != nil {
+	if err := shelly.CreateScheduleRefresherSchedule(ctx, ip, port, dev.Name()); err != nil {
 		return err
 	}
+	if !contx.Pretend(ctx) {
+		lastPlans.Lock()
+		lastPlans.m[dev.Name()] = plan
+		lastPlans.Unlock()
+		recordApplied(dev, s.Jobs)
+	}
 	return nil
 }
 
-// reqGenerateSchedule handle request parameters and generates a schedule. if
-// `tomorrow` is true, ignores offset and tries to generate for tomorrow.
-func reqGenerateSchedule(query url.Values, tomorrow bool) (schedule.Schedule, error) {
-	conf := config.GetConf()
-	hours, err := strconv.Atoi(query.Get("hours"))
-	if err != nil || hours == 0 {
-		hours = conf.Hours()
+// planParams are the parameters used to generate a schedule
+type planParams struct {
+	strategy schellydule.Strategy
+	// runtime is the total time to run. darkHours is used by StrategyCheapest
+	runtime   time.Duration
+	darkHours int
+	// maxPrice, minHours and maxHours are used by StrategyThreshold
+	maxPrice float64
+	minHours int
+	maxHours int
+	// earliest and by are the times of day limiting StrategyDeadline
+	earliest time.Duration
+	by       time.Duration
+	// maxGap is the maximum time between runs for StrategySpread
+	maxGap time.Duration
+	// windows are the periods of the day StrategyFixed runs in
+	windows []config.Window
+	// co2Weight is the price per kg of CO2 used by StrategyWeighted
+	co2Weight float64
+	offset    time.Duration
+	// start is the time to plan from. If zero, the plan starts at midnight of the
+	// day `offset` from now
+	start time.Time
+	// daily is true when planning a whole day, like the daily renewal. A
+	// deadline window crossing midnight is then the one starting that day.
+	daily bool
+	// loc is the time zone of the device. Days start at midnight in loc
+	loc *time.Location
+}
+
+// reqGenerateSchedule handle request parameters and generates a schedule for
+// dev in the time zone loc. if `tomorrow` is true, ignores offset and tries to
+// generate for tomorrow. The settings of the profile active on that day replace
+// those of dev, and the request parameters replace both.
+func reqGenerateSchedule(query url.Values, dev config.Device, tomorrow bool, loc *time.Location) (schellydule.Plan, error) {
+	dev = withProfile(dev, planDay(query, tomorrow, loc))
+	p, err := reqPlanParams(query, dev, tomorrow, loc)
+	if err != nil {
+		return schellydule.Plan{}, err
+	}
+	p.daily = true
+	return planSchedule(dev, p)
+}
+
+// planDay returns a time on the day the request plans for, in the time zone loc
+func planDay(query url.Values, tomorrow bool, loc *time.Location) time.Time {
+	day := time.Now().In(loc)
+	if tomorrow {
+		day = day.Add(24 * time.Hour)
+	} else if offset, err := strconv.Atoi(query.Get("offset")); err == nil {
+		day = day.Add(time.Duration(offset) * time.Hour)
+	}
+	return day
+}
+
+// reqPlanParams reads the plan parameters from the request, falling back to
+// the settings of dev, which is in the time zone loc.
+func reqPlanParams(query url.Values, dev config.Device, tomorrow bool, loc *time.Location) (planParams, error) {
+	p := planParams{loc: loc}
+	var err error
+	var carry bool
+	strategy := query.Get("strategy")
+	if strategy == "" {
+		strategy = dev.Strategy()
+	}
+	if p.strategy, err = schellydule.ParseStrategy(strategy); err != nil {
+		return planParams{}, err
+	}
+	// The runtime is given either in minutes or in hours
+	if minutes, err := strconv.Atoi(query.Get("minutes")); err == nil && minutes != 0 {
+		p.runtime = time.Duration(minutes) * time.Minute
+	} else if hours, err := strconv.Atoi(query.Get("hours")); err == nil && hours != 0 {
+		p.runtime = time.Duration(hours) * time.Hour
+	} else {
+		p.runtime = dev.Runtime()
+		carry = true
 	}
 
 	// offset is a debugging option, that can be used to adjust how far into the
@@ -357,38 +666,123 @@ func reqGenerateSchedule(query url.Values, tomorrow bool) (schedule.Schedule, er
 	if tomorrow {
 		offset = 24
 	}
-	darkHours, err := strconv.Atoi(query.Get("dark"))
+	p.offset = time.Duration(offset) * time.Hour
+	// Make up for what the device didn't run yesterday, unless the number of
+	// hours was given explicitly
+	if carry {
+		if c := carryRuntime(dev, time.Now().In(loc).Add(p.offset)); c != 0 {
+			log.Printf("device %s: carrying over %s", dev.Name(), c)
+			p.runtime += c
+		}
+		if p.runtime < 0 {
+			p.runtime = 0
+		}
+	}
+	p.darkHours, err = strconv.Atoi(query.Get("dark"))
 	if err != nil {
-		darkHours = conf.DarkHours()
+		p.darkHours = dev.DarkHours()
 	}
-	fmt.Println("PARAMS:", hours, darkHours, offset)
-	hp, err := generateSchedule(hours, darkHours, time.Duration(offset)*time.Hour)
-	log.Printf("generated schedule is %d hours", len(hp))
+	p.maxPrice, err = strconv.ParseFloat(query.Get("maxprice"), 64)
+	if err != nil {
+		p.maxPrice = dev.MaxPrice()
+	}
+	p.minHours, err = strconv.Atoi(query.Get("minhours"))
+	if err != nil {
+		p.minHours = dev.MinHours()
+	}
+	p.maxHours, err = strconv.Atoi(query.Get("maxhours"))
+	if err != nil {
+		p.maxHours = dev.MaxHours()
+	}
+	p.maxGap = dev.MaxGap()
+	p.windows = dev.Windows()
+	p.co2Weight, err = strconv.ParseFloat(query.Get("co2weight"), 64)
+	if err != nil {
+		p.co2Weight = dev.CO2Weight()
+	}
+	if gap, err := strconv.Atoi(query.Get("maxgap")); err == nil {
+		p.maxGap = time.Duration(gap) * time.Minute
+	}
+	p.earliest, p.by = dev.EarliestStart(), dev.Deadline()
+	if from := query.Get("from"); from != "" {
+		if p.earliest, err = config.ParseClock(from); err != nil {
+			return planParams{}, err
+		}
+	}
+	if by := query.Get("by"); by != "" {
+		if p.by, err = config.ParseClock(by); err != nil {
+			return planParams{}, err
+		}
+	}
+	return p, nil
+}
+
+// nextSlot returns the start of the first slot of length l after t. Slots are
+// counted from the start of the hour in t's time zone
+func nextSlot(t time.Time, l time.Duration) time.Time {
+	hour := schedule.Hour(t, t.Hour())
+	return hour.Add((t.Sub(hour)/l + 1) * l)
+}
+
+// planSchedule generates a schedule for dev using p
+func planSchedule(dev config.Device, p planParams) (schellydule.Plan, error) {
+	plan, err := generateSchedule(dev, p)
 	if err != nil {
-		return schedule.Schedule{}, fmt.Errorf("generateSchedule: %w", err)
+		return schellydule.Plan{}, fmt.Errorf("generateSchedule: %w", err)
 	}
+	log.Printf("generated schedule is %s", runtimeOf(plan.Schedule))
+	// The Shelly only knows the time of day, so runs crossing midnight are split.
+	plan.Schedule = schellydule.SplitDays(plan.Schedule)
 	// handle the special case where the last stop-hour is midnight. This creates
 	// confusion, because then we might have ambiguity, if there's also a midnight
 	// start time. So set that to 23:59 instead (and minute resolution, not seconds, because Shelly doesn't show seconds).
-	hps := hp.Schedule()
+	hps := plan.Schedule
 	for i, j := range hps {
-		if t := j.Stop; t.Hour() == 0 {
+		if t := j.Stop; t.Hour() == 0 && t.Minute() == 0 {
 			hps[i].Stop = t.Add(-1 * time.Minute)
 		}
 	}
-	log.Printf("hps: %#v", hps)
-	return hps, nil
+	return plan, nil
+}
+
+// scheduleReport is the response of showSchedulesHandler
+type scheduleReport struct {
+	Schedule interface{} `json:"schedule"`
+	// Premium is the extra cost of the schedule caused by the device's run
+	// constraints (minimum run and off time, maximum starts)
+	Premium float64 `json:"premium"`
+	// Timeline shows the day slot by slot, '#' meaning running
+	Timeline string `json:"timeline"`
+	// Gaps are the periods of the day without running
+	Gaps []gapReport `json:"gaps"`
+	// MaxGap is the longest gap in minutes
+	MaxGap int `json:"max_gap"`
+	// Costs is the cost of the schedule for `watts`, broken down into spot
+	// price, tariff, tax and VAT
+	Costs *costReport `json:"costs,omitempty"`
+	// CO2 is the grams of CO2 emitted by running `watts` according to the
+	// schedule, if a CO2 source is configured
+	CO2 *float64 `json:"co2_grams,omitempty"`
+	// Night is the sunrise and sunset used for limiting hours at night
+	Night nightInfo `json:"night"`
+}
+
+type gapReport struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Minutes int    `json:"minutes"`
 }
 
 // showSchedulesHandler is a GET controller, that returns the currently configured schedule
 func showSchedulesHandler(w http.ResponseWriter, req *http.Request) {
 	ip, err := getIP(req, true)
 	if err != nil {
-		status := http.StatusInternalServerError
-		if errors.Is(err, ErrInvalidIP) {
-			status = http.StatusBadRequest
-		}
-		setStatusMsg(w, status, err.Error())
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
 		return
 	}
 	q := req.URL.Query()
@@ -401,6 +795,7 @@ func showSchedulesHandler(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 	}
+	loc := deviceZone(req.Context(), dev, ip)
 	tomorrow, err := strconv.ParseBool(q.Get("tomorrow"))
 	if err != nil {
 		fmt.Printf("error parsing bool from '%s', assuming false", q.Get("tomorrow"))
@@ -411,13 +806,14 @@ func showSchedulesHandler(w http.ResponseWriter, req *http.Request) {
 	}
 
 	var parsed schedule.Schedule
+	var premium float64
 	if tomorrow || recalc {
-		var err error
-		parsed, err = reqGenerateSchedule(q, tomorrow)
+		plan, err := reqGenerateSchedule(q, dev, tomorrow, loc)
 		if err != nil {
 			setStatusMsg(w, http.StatusInternalServerError, err)
 			return
 		}
+		parsed, premium = plan.Schedule, plan.Premium
 	} else {
 		schedules, err := shelly.GetSchedules(nil, ip)
 		if err != nil {
@@ -425,30 +821,248 @@ func showSchedulesHandler(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		parsed, err = schellydule.Schedule(schedules)
+		parsed, err = schellydule.ScheduleIn(schedules, loc)
 		if err != nil {
 			setStatusMsg(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		lastPlans.Lock()
+		premium = lastPlans.m[dev.Name()].Premium
+		lastPlans.Unlock()
+	}
+	report := scheduleReport{
+		Schedule: parsed.Map(watts),
+		Premium:  premium * watts / 1000,
+		Gaps:     []gapReport{},
+		Costs:    scheduleCost(parsed, watts),
+		CO2:      scheduleCO2(parsed, watts),
+	}
+	day := schedule.Hour(time.Now().In(loc), 0)
+	if len(parsed) > 0 {
+		day = schedule.Hour(parsed[0].Start.In(loc), 0)
+	}
+	step := dev.SlotLength()
+	report.Timeline = schellydule.Timeline(parsed, day, step, int(24*time.Hour/step))
+	report.Night = nightReport(dev, day)
+	for _, g := range schellydule.Gaps(parsed, day, day.Add(24*time.Hour)) {
+		minutes := int(g.Length().Minutes())
+		report.Gaps = append(report.Gaps, gapReport{From: g.Start.Format("15:04"), To: g.Stop.Format("15:04"), Minutes: minutes})
+		if minutes > report.MaxGap {
+			report.MaxGap = minutes
+		}
 	}
 	var out []byte
-	if out, err = json.Marshal(parsed.Map(watts)); err != nil {
+	if out, err = json.Marshal(report); err != nil {
 		setStatusMsg(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	io.WriteString(w, string(out))
 }
 
-func generateSchedule(length, maxDark int, offset time.Duration) (schedule.HourPrices, error) {
+// generateSchedule generates a plan for dev using the strategy in p
+func generateSchedule(dev config.Device, p planParams) (schellydule.Plan, error) {
 	conf := config.GetConf()
-	tomorrow := schedule.Hour(time.Now().Add(offset), 0)
-	prices, err := power.Prices(tomorrow, tomorrow.Add(24*time.Hour), conf, true)
+	from, to := planWindow(p)
+	prices, err := power.Prices(schedule.Hour(from, 0), to, conf, true)
 	if err != nil {
-		return nil, err
+		return schellydule.Plan{}, err
+	}
+	// The cheapest hours are the ones with the lowest price after tariffs and taxes
+	return planPrices(dev, p, tariff().Apply(schedule.FPToHourPrices(prices), p.loc))
+}
+
+// planWindow returns the period p plans for
+func planWindow(p planParams) (time.Time, time.Time) {
+	from := p.start
+	if from.IsZero() {
+		from = schedule.Hour(time.Now().In(p.loc).Add(p.offset), 0)
+	}
+	to := from.AddDate(0, 0, 1)
+	if p.strategy == schellydule.StrategyDeadline {
+		// Otherwise the part of the window before midnight would never be planned
+		if p.daily && p.earliest > p.by {
+			from = from.Add(p.earliest)
+		}
+		from, to = schellydule.DeadlineWindow(from, p.earliest, p.by)
+	}
+	return from, to
+}
+
+// planPrices generates a plan for dev from list using the strategy in p
+func planPrices(dev config.Device, p planParams, list schedule.HourPrices) (schellydule.Plan, error) {
+	from, to := planWindow(p)
+	// Slots are in the device's time zone, so days and times of day match the
+	// device. Prices for shorter periods than the slots are averaged.
+	length := dev.SlotLength()
+	slots := schellydule.SlotsFromHourPrices(list).In(p.loc).Split(length).Join(length).Window(from, to)
+	// The runtime is rounded up to whole slots
+	n := schellydule.SlotCount(p.runtime, length)
+
+	c := schellydule.Constraints{
+		MinRun:    dev.MinRun(),
+		MinOff:    dev.MinOff(),
+		MaxStarts: dev.MaxStarts(),
+		MaxDark:   time.Duration(p.darkHours) * time.Hour,
+		Dark:      darkness(dev),
+	}
+	calendarConstraints(dev, from, to, &c)
+	quietConstraints(dev, &c)
+	maxBlocks := schellydule.MaxBlocks(dev.MaxJobs())
+	if p.strategy != schellydule.StrategyThreshold && p.strategy != schellydule.StrategyFixed {
+		var err error
+		if n, err = fitAllowed(dev, slots, n, c); err != nil {
+			return schellydule.Plan{}, err
+		}
+	}
+	switch p.strategy {
+	case schellydule.StrategyThreshold:
+		// The threshold decides the number of hours. If they can't be installed
+		// as they are, the same number of hours are laid out to fit
+		sel := slots.Allowed(c).Threshold(p.maxPrice, schellydule.SlotCount(time.Duration(p.minHours)*time.Hour, length), schellydule.SlotCount(time.Duration(p.maxHours)*time.Hour, length))
+		if !c.Active() && len(sel.Schedule()) <= maxBlocks {
+			return sel.Plan(), nil
+		}
+		return slots.Fit(len(sel), c, maxBlocks)
+	case schellydule.StrategySpread:
+		// The maximum gap replaces the night limit
+		if p.maxGap <= 0 {
+			return schellydule.Plan{}, fmt.Errorf("%w: spread strategy needs a maximum gap", schellydule.ErrInfeasible)
+		}
+		c.MaxGap, c.MaxDark = p.maxGap, 0
+		return slots.Fit(n, c, maxBlocks)
+	case schellydule.StrategyFixed:
+		if len(p.windows) == 0 {
+			return schellydule.Plan{}, fmt.Errorf("%w: fixed strategy needs windows", schellydule.ErrInfeasible)
+		}
+		plan := fixedSlots(slots, p.windows, c).Plan()
+		if len(plan.Schedule) > maxBlocks {
+			return schellydule.Plan{}, fmt.Errorf("%w: windows need %d blocks, device only has room for %d", schellydule.ErrInfeasible, len(plan.Schedule), maxBlocks)
+		}
+		return plan, nil
+	case schellydule.StrategyDeadline:
+		if len(slots) < n {
+			return schellydule.Plan{}, fmt.Errorf("%w: only %s between %s and %s, %s needed", schellydule.ErrInfeasible, time.Duration(len(slots))*length, from.Format("15:04"), to.Format("15:04"), time.Duration(n)*length)
+		}
+		return slots.Fit(n, c, maxBlocks)
+	case schellydule.StrategyGreenest, schellydule.StrategyWeighted:
+		return greenPlan(p, slots, n, c, maxBlocks)
 	}
-	list := schedule.FPToHourPrices(prices)
 
-	return list.NCheapest(length, maxDark)
+	// Without a configured location or dark window, NCheapest finds the
+	// sunrise and sunset by GeoIP. It only works in whole hours
+	if !c.Active() && c.Dark == nil && length == time.Hour && n > 0 {
+		hp, err := list.NCheapest(n, p.darkHours)
+		if err != nil {
+			return schellydule.Plan{}, err
+		}
+		s := schellydule.Compact(schellydule.In(hp.Schedule(), p.loc))
+		if len(s) <= maxBlocks {
+			var cost float64
+			for _, e := range s {
+				cost += e.Cost
+			}
+			return schellydule.Plan{Schedule: s, Cost: cost}, nil
+		}
+		log.Printf("device %s: schedule has %d blocks, but only %d fit. Re-optimising", dev.Name(), len(s), maxBlocks)
+	}
+	// Refusing here would fail the renewal of a default config whenever the
+	// cheapest hours are too scattered, so the plan is made without the limit
+	if c.Dark == nil && time.Duration(p.darkHours)*time.Hour < p.runtime {
+		log.Printf("device %s: darkhours isn't enforced in this schedule, since no location or dark window is configured", dev.Name())
+	}
+	return slots.Fit(n, c, maxBlocks)
+}
+
+// fixedSlots returns the slots in s within any of windows, or forced by c,
+// unless they're blocked by c
+func fixedSlots(s schellydule.Slots, windows []config.Window, c schellydule.Constraints) schellydule.Slots {
+	// Overlapping windows select the same slots
+	selected := make(map[int64]bool, len(s))
+	for _, w := range windows {
+		for _, e := range s.Between(w.From, w.To) {
+			selected[e.Start.Unix()] = true
+		}
+	}
+	var rv schellydule.Slots
+	for _, e := range s {
+		if c.Blocked != nil && c.Blocked(e) {
+			continue
+		}
+		if selected[e.Start.Unix()] || c.Forced != nil && c.Forced(e) {
+			rv = append(rv, e)
+		}
+	}
+	return rv
+}
+
+// checkStrategy checks the strategy of dev. Without a location or dark window,
+// darkhours is only enforced by the cheapest strategy when it can pick whole
+// hours freely, so run constraints, quiet hours, calendars and shorter slots
+// are refused. calendars is true if any calendars are configured.
+func checkStrategy(dev config.Device, calendars bool) error {
+	strategy, err := schellydule.ParseStrategy(dev.Strategy())
+	if err != nil {
+		return err
+	}
+	if strategy != schellydule.StrategyCheapest || darkness(dev) != nil || time.Duration(dev.DarkHours())*time.Hour >= dev.Runtime() {
+		return nil
+	}
+	var what string
+	switch {
+	case dev.MinRun() > 0 || dev.MinOff() > 0 || dev.MaxStarts() > 0:
+		what = "min_run, min_off or max_starts"
+	case dev.SlotLength() < time.Hour:
+		what = "slot_length below 60"
+	case len(dev.QuietHours(time.Monday)) > 0 || len(dev.QuietHours(time.Sunday)) > 0:
+		what = "quiet_hours"
+	case calendars:
+		what = "calendars"
+	default:
+		return nil
+	}
+	return fmt.Errorf("darkhours can't be enforced with %s unless latitude and longitude, or dark_start and dark_end, are set", what)
+}
+
+// darkness returns a function telling if it's dark at the location of dev, or
+// nil if neither location nor dark window is configured
+func darkness(dev config.Device) func(time.Time) bool {
+	if start, end, ok := dev.DarkWindow(); ok {
+		return schellydule.DarkByClock(start, end)
+	}
+	if lat, lon, ok := dev.Location(); ok {
+		return schellydule.DarkByLocation(lat, lon)
+	}
+	return nil
+}
+
+// nightInfo describes what's considered night, and where that came from
+type nightInfo struct {
+	Source  string `json:"source"`
+	Sunrise string `json:"sunrise,omitempty"`
+	Sunset  string `json:"sunset,omitempty"`
+}
+
+// nightReport describes what's considered night for dev on day
+func nightReport(dev config.Device, day time.Time) nightInfo {
+	var rv nightInfo
+	if start, end, ok := dev.DarkWindow(); ok {
+		rv.Source = "dark window"
+		rv.Sunset = day.Add(start).Format("15:04")
+		rv.Sunrise = day.Add(end).Format("15:04")
+		return rv
+	}
+	if lat, lon, ok := dev.Location(); ok {
+		rv.Source = fmt.Sprintf("location %.4f,%.4f", lat, lon)
+		sunrise, sunset, err := schellydule.Sun(day, lat, lon)
+		if err != nil {
+			rv.Source += ": " + err.Error()
+			return rv
+		}
+		rv.Sunrise, rv.Sunset = sunrise.Format("15:04"), sunset.Format("15:04")
+		return rv
+	}
+	rv.Source = "geoip"
+	return rv
 }
 
 func setStatusMsg(w http.ResponseWriter, status int, msg interface{}) {
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+func TestPlanPrices_tooManyBlocks(t *testing.T) {
+	// The default config has neither a location nor a dark window
+	dev := loadTestConfig(t, "max_jobs = 5").Device
+	day := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	// Every other hour is cheap, so the cheapest hours are all apart
+	var prices schedule.HourPrices
+	for h := 0; h < 24; h++ {
+		price := 1.0
+		if h%2 == 0 {
+			price = 0.1
+		}
+		prices = append(prices, schedule.HourPrice{Hour: day.Add(time.Duration(h) * time.Hour), Price: price})
+	}
+	p, err := reqPlanParams(url.Values{"hours": {"4"}, "offset": {"0"}}, dev, false, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.start = day
+	plan, err := planPrices(dev, p, prices)
+	if err != nil {
+		t.Fatalf("planPrices() error = %v", err)
+	}
+	var runtime time.Duration
+	for _, e := range plan.Schedule {
+		runtime += e.Stop.Sub(e.Start)
+	}
+	if len(plan.Schedule) > 2 || runtime != 4*time.Hour {
+		t.Errorf("planPrices() = %d runs of %s in total, want at most 2 runs of 4h", len(plan.Schedule), runtime)
+	}
+}
+
+func TestWithPending(t *testing.T) {
+	// Room for 3 runs
+	dev := loadTestConfig(t, "max_jobs = 7").Device
+	now := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	run := func(start, stop int) schedule.Entry {
+		return schedule.Entry{Start: now.Add(time.Duration(start) * time.Hour), Stop: now.Add(time.Duration(stop) * time.Hour)}
+	}
+	plan := schedule.Schedule{run(1, 2), run(8, 9), run(20, 21)}
+	pending := schedule.Schedule{run(4, 5), run(30, 31)}
+	got := withPending(dev, plan, pending, now)
+	if jobs := len(shelly.ShellyScheduleIn(got, true, time.UTC).Jobs) + 1; jobs > dev.MaxJobs() {
+		t.Errorf("withPending() = %v, needs %d jobs, want at most %d", got, jobs, dev.MaxJobs())
+	}
+	want := schedule.Schedule{run(1, 5), run(8, 9), run(20, 21)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withPending() = %v, want %v", got, want)
+	}
+}
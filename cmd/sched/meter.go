@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// meter returns the configured meter of the grid power, or nil if there's none
+func meter(conf config.Config) schellydule.Meter {
+	u, ip := conf.Meter()
+	switch {
+	case ip != nil:
+		return schellydule.ShellyMeter{Addr: ip}
+	case u != "":
+		return schellydule.HTTPMeter{URL: u, Field: conf.MeterField(), ExportPositive: conf.MeterExportPositive()}
+	}
+	return nil
+}
+
+// startMeter reads the meter every meter interval in the background. Devices
+// set to use solar run on the power exported, and devices with a priority are
+// switched off to keep the household below the power limit.
+func startMeter(conf config.Config) {
+	m := meter(conf)
+	if m == nil {
+		return
+	}
+	var solar, shed []config.Device
+	for _, dev := range conf.Devices() {
+		if dev.IP() == nil {
+			continue
+		}
+		if dev.Solar() {
+			solar = append(solar, dev)
+		}
+		if dev.Priority() > 0 && conf.PowerLimit() > 0 {
+			shed = append(shed, dev)
+		}
+	}
+	if len(solar) == 0 && len(shed) == 0 {
+		return
+	}
+	interval := conf.MeterInterval()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			grid, err := m.GridPower(ctx)
+			if err != nil {
+				cancel()
+				log.Printf("error reading the grid power: %s", err)
+				continue
+			}
+			if len(shed) > 0 {
+				manageLoad(ctx, grid, shed, conf.PowerLimit())
+			}
+			useSurplus(ctx, grid, solar, interval)
+			cancel()
+		}
+	}()
+}
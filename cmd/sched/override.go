@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/errors"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/contx"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// Override modes
+const (
+	// overrideOn keeps the switch on, with the schedule disabled
+	overrideOn = "on"
+	// overrideOff keeps the switch off, with the schedule disabled
+	overrideOff = "off"
+	// overrideDisable disables the schedule and turns the switch on, like
+	// /disableSchedules
+	overrideDisable = "disable"
+)
+
+// overrideRetry is the wait before trying to end an override again, if the
+// Shelly couldn't be reached
+const overrideRetry = time.Minute
+
+// ErrNoOverride is returned when there's no override to end
+var ErrNoOverride = errors.New("no override active")
+
+// override is a manual override of the schedule of a device, until a given time
+type override struct {
+	Device  string    `json:"device"`
+	IP      string    `json:"ip"`
+	Mode    string    `json:"mode"`
+	Until   time.Time `json:"until"`
+	Created time.Time `json:"created"`
+}
+
+// overrides holds the active override of each device, and the timers ending them
+var overrides = struct {
+	sync.Mutex
+	persisted
+	m      map[string]override
+	timers map[string]*time.Timer
+}{persisted: persisted{what: "overrides"}, m: make(map[string]override), timers: make(map[string]*time.Timer)}
+
+// loadOverrides reads the active overrides from filename, which is also where
+// they're saved, and ends them when they expire. Overrides that expired while
+// we were down are ended right away.
+func loadOverrides(filename string) error {
+	overrides.Lock()
+	defer overrides.Unlock()
+	if err := overrides.load(filename, &overrides.m); err != nil {
+		return err
+	}
+	for name, o := range overrides.m {
+		o := o
+		overrides.timers[name] = time.AfterFunc(time.Until(o.Until), func() { expireOverride(o) })
+	}
+	return nil
+}
+
+// activeOverride returns the override of dev, if it has one
+func activeOverride(dev config.Device) (override, bool) {
+	overrides.Lock()
+	defer overrides.Unlock()
+	o, ok := overrides.m[dev.Name()]
+	return o, ok
+}
+
+// startOverride overrides the schedule of dev at ip with mode until the time
+// until, replacing any override it already has. An "on" override is set with
+// the Shelly's toggle_after, so the switch goes off even if we're down at until.
+// An "off" override is only ended by us, since toggle_after would switch the
+// relay on at until, whatever the schedule says.
+func startOverride(ctx context.Context, dev config.Device, ip net.IP, mode string, until time.Time) (override, error) {
+	o := override{Device: dev.Name(), IP: ip.String(), Mode: mode, Until: until, Created: time.Now()}
+	d := time.Until(until)
+	if d <= 0 {
+		return o, fmt.Errorf("override must end in the future, not %s", until.Format(time.RFC3339))
+	}
+	defer lockDevice(dev.Name())()
+	if err := disableSchedules(ctx, ip); err != nil {
+		return o, err
+	}
+	var err error
+	switch mode {
+	case overrideOn:
+		err = shelly.SetSwitchFor(ctx, ip, shelly.StateOn, d)
+	case overrideOff:
+		err = shelly.SetSwitch(ctx, ip, shelly.StateOff)
+	case overrideDisable:
+		err = shelly.TurnOn(ctx, ip)
+	default:
+		err = fmt.Errorf("unknown override mode %q, must be %q, %q or %q", mode, overrideOn, overrideOff, overrideDisable)
+	}
+	if err != nil {
+		return o, err
+	}
+	if contx.Pretend(ctx) {
+		return o, nil
+	}
+	overrides.Lock()
+	defer overrides.Unlock()
+	if t, ok := overrides.timers[dev.Name()]; ok {
+		t.Stop()
+	}
+	overrides.m[dev.Name()] = o
+	overrides.timers[dev.Name()] = time.AfterFunc(d, func() { expireOverride(o) })
+	overrides.save(overrides.m)
+	log.Printf("device %s: override %s until %s", dev.Name(), mode, until.Format(time.RFC3339))
+	return o, nil
+}
+
+// endOverride ends the override of dev at ip, and hands the switch back to the
+// schedule. If created isn't zero, only the override created then is ended, so
+// one replacing it is left alone. If the schedules are disabled by the input,
+// the switch is left on, like /disableSchedules does.
+func endOverride(ctx context.Context, dev config.Device, ip fmt.Stringer, created time.Time) (override, error) {
+	defer lockDevice(dev.Name())()
+	// Overrides are started with the device locked as well, so this is the one
+	// still active when the Shelly is called
+	o, ok := activeOverride(dev)
+	if !ok || !created.IsZero() && !o.Created.Equal(created) {
+		return o, ErrNoOverride
+	}
+	enable, err := shelly.GetInputState(ctx, ip)
+	if err == nil {
+		if enable {
+			err = enableSchedules(ctx, dev, ip)
+		} else {
+			err = shelly.TurnOn(ctx, ip)
+		}
+	}
+	if err != nil {
+		return o, err
+	}
+	if contx.Pretend(ctx) {
+		return o, nil
+	}
+	overrides.Lock()
+	if t, ok := overrides.timers[dev.Name()]; ok {
+		t.Stop()
+	}
+	delete(overrides.m, dev.Name())
+	delete(overrides.timers, dev.Name())
+	overrides.save(overrides.m)
+	overrides.Unlock()
+	log.Printf("device %s: override ended", dev.Name())
+	return o, nil
+}
+
+// expireOverride ends o when it expires. If that fails, it's tried again later.
+func expireOverride(o override) {
+	if cur, ok := func() (override, bool) {
+		overrides.Lock()
+		defer overrides.Unlock()
+		cur, ok := overrides.m[o.Device]
+		return cur, ok
+	}(); !ok || !cur.Created.Equal(o.Created) {
+		// Ended or replaced in the meantime
+		return
+	}
+	dev, ok := config.GetConf().GetDevice(o.Device)
+	if !ok {
+		log.Printf("device %s is no longer configured, dropping its override", o.Device)
+		overrides.Lock()
+		delete(overrides.m, o.Device)
+		delete(overrides.timers, o.Device)
+		overrides.save(overrides.m)
+		overrides.Unlock()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), overrideRetry)
+	defer cancel()
+	if _, err := endOverride(ctx, dev, net.ParseIP(o.IP), o.Created); err != nil && !errors.Is(err, ErrNoOverride) {
+		log.Printf("device %s: error ending override, retrying in %s: %s", o.Device, overrideRetry, err)
+		overrides.Lock()
+		if cur, ok := overrides.m[o.Device]; ok && cur.Created.Equal(o.Created) {
+			overrides.timers[o.Device] = time.AfterFunc(overrideRetry, func() { expireOverride(o) })
+		}
+		overrides.Unlock()
+	}
+}
+
+// overrideUntil returns the end of an override given by `for`, a duration
+// like "2h", or `until`, a time of day like "18:00" or "tomorrow" for the next
+// midnight, in loc
+func overrideUntil(q url.Values, now time.Time, loc *time.Location) (time.Time, error) {
+	now = now.In(loc)
+	if f := q.Get("for"); f != "" {
+		d, err := time.ParseDuration(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("for: %w", err)
+		}
+		return now.Add(d), nil
+	}
+	until := q.Get("until")
+	if until == "" {
+		return time.Time{}, errors.New("either for or until is required")
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if until == "tomorrow" {
+		return midnight.AddDate(0, 0, 1), nil
+	}
+	clock, err := config.ParseClock(until)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("until: %w", err)
+	}
+	t := midnight.Add(clock)
+	if !t.After(now) {
+		t = midnight.AddDate(0, 0, 1).Add(clock)
+	}
+	return t, nil
+}
+
+// overrideHandler overrides the schedule of a device: `mode=on` or `mode=off`
+// keeps the switch on or off, and `mode=disable` disables the schedule like
+// /disableSchedules, `for` a duration like "2h", or `until` a time of day like
+// "18:00" or "tomorrow". `mode=cancel` ends the override. Without a mode, the
+// active override is returned.
+func overrideHandler(w http.ResponseWriter, req *http.Request) {
+	ctx := contx.ProcessCommon(req)
+	ip, err := getIP(req, true)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	q := req.URL.Query()
+	mode := q.Get("mode")
+	if req.Method == http.MethodDelete {
+		mode = "cancel"
+	}
+	switch mode {
+	case "":
+		o, ok := activeOverride(dev)
+		if !ok {
+			setStatusMsg(w, http.StatusNotFound, ErrNoOverride)
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	case "cancel":
+		o, err := endOverride(ctx, dev, ip, time.Time{})
+		switch {
+		case errors.Is(err, ErrNoOverride):
+			setStatusMsg(w, http.StatusNotFound, err)
+		case err != nil:
+			setStatusMsg(w, http.StatusBadGateway, err)
+		default:
+			writeJSON(w, http.StatusOK, o)
+		}
+	case overrideOn, overrideOff, overrideDisable:
+		until, err := overrideUntil(q, time.Now(), deviceZone(ctx, dev, ip))
+		if err != nil {
+			setStatusMsg(w, http.StatusBadRequest, err)
+			return
+		}
+		o, err := startOverride(ctx, dev, ip, mode, until)
+		if err != nil {
+			setStatusMsg(w, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	default:
+		setStatusMsg(w, http.StatusBadRequest, fmt.Sprintf("unknown mode %q, must be %q, %q, %q or cancel", mode, overrideOn, overrideOff, overrideDisable))
+	}
+}
+
+// overrideCommand asks the running service to override the schedule of a device
+func overrideCommand(args []string) error {
+	fs := flag.NewFlagSet("override", flag.ExitOnError)
+	server := fs.String("server", fmt.Sprintf("http://localhost:%d", port), "address of the running service")
+	device := fs.String("device", "", "name of the device. Default is the default device")
+	mode := fs.String("mode", "", "on or off to force the switch, disable to disable the schedule. Without a mode, the active override is shown")
+	forDuration := fs.String("for", "", "how long to override, like 2h")
+	until := fs.String("until", "", "time of day (HH:MM) or \"tomorrow\" to override until, instead of -for")
+	cancel := fs.Bool("cancel", false, "end the override, and go back to the schedule")
+	pretend := fs.Bool("pretend", false, "don't change anything on the Shelly")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *cancel {
+		*mode = "cancel"
+	}
+	if *mode != "" {
+		query.Set("mode", *mode)
+	}
+	if *forDuration != "" {
+		query.Set("for", *forDuration)
+	}
+	if *until != "" {
+		query.Set("until", *until)
+	}
+	if *device != "" {
+		query.Set("device", *device)
+	}
+	if *pretend {
+		query.Set("pretend", "true")
+	}
+	return callService(*server, "/override", query)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+)
+
+// resetOverrides forgets the overrides of a test when it ends
+func resetOverrides(t *testing.T) {
+	t.Cleanup(func() {
+		overrides.Lock()
+		defer overrides.Unlock()
+		for _, timer := range overrides.timers {
+			timer.Stop()
+		}
+		overrides.m = make(map[string]override)
+		overrides.timers = make(map[string]*time.Timer)
+	})
+}
+
+// waitFor waits up to a second for cond to be true
+func waitFor(t *testing.T, cond func() bool) bool {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+// allDay returns a plan running all of today
+func allDay() schellydule.Plan {
+	midnight := schedule.Hour(time.Now().UTC(), 0)
+	return schellydule.Plan{Schedule: schedule.Schedule{{Start: midnight, Stop: midnight.Add(24*time.Hour - time.Minute)}}}
+}
+
+func TestOverrideUntil(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	// 16:30 in loc
+	now := time.Date(2022, 7, 1, 14, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		q       url.Values
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "for", q: url.Values{"for": {"2h"}}, want: time.Date(2022, 7, 1, 18, 30, 0, 0, loc)},
+		{name: "until later today", q: url.Values{"until": {"18:00"}}, want: time.Date(2022, 7, 1, 18, 0, 0, 0, loc)},
+		{name: "until tomorrow morning", q: url.Values{"until": {"09:00"}}, want: time.Date(2022, 7, 2, 9, 0, 0, 0, loc)},
+		{name: "until now", q: url.Values{"until": {"16:30"}}, want: time.Date(2022, 7, 2, 16, 30, 0, 0, loc)},
+		{name: "until midnight", q: url.Values{"until": {"24:00"}}, want: time.Date(2022, 7, 2, 0, 0, 0, 0, loc)},
+		{name: "tomorrow", q: url.Values{"until": {"tomorrow"}}, want: time.Date(2022, 7, 2, 0, 0, 0, 0, loc)},
+		{name: "for wins", q: url.Values{"for": {"30m"}, "until": {"18:00"}}, want: time.Date(2022, 7, 1, 17, 0, 0, 0, loc)},
+		{name: "neither", q: url.Values{}, wantErr: true},
+		{name: "invalid for", q: url.Values{"for": {"2 hours"}}, wantErr: true},
+		{name: "invalid until", q: url.Values{"until": {"6pm"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := overrideUntil(tt.q, now, loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("overrideUntil() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("overrideUntil() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverride_expiresDuringRenewal(t *testing.T) {
+	f := newFakeShelly(t)
+	dev := loadTestConfig(t, f.conf("")).Device
+	resetOverrides(t)
+	ctx := context.Background()
+	if _, err := startOverride(ctx, dev, f.ip, overrideOff, time.Now().Add(100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	release := f.hold("Schedule.DeleteAll")
+	defer release()
+	done := make(chan error, 1)
+	go func() { done <- installPlan(ctx, dev, f.ip, allDay()) }()
+	<-f.entered
+	// The override expires while the schedule is installed, disabled
+	time.Sleep(200 * time.Millisecond)
+	release()
+	if err := <-done; err != nil {
+		t.Fatalf("installPlan() error = %v", err)
+	}
+	if !waitFor(t, func() bool { _, ok := activeOverride(dev); return !ok }) {
+		t.Fatal("override didn't end")
+	}
+	if output, enabled := f.state(); !output || !enabled {
+		t.Errorf("switch on = %t, schedule enabled = %t, want the schedule enabled and running", output, enabled)
+	}
+}
+
+func TestOverride_replacedDuringRenewal(t *testing.T) {
+	f := newFakeShelly(t)
+	dev := loadTestConfig(t, f.conf("")).Device
+	resetOverrides(t)
+	ctx := context.Background()
+	if _, err := startOverride(ctx, dev, f.ip, overrideOff, time.Now().Add(100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	release := f.hold("Schedule.DeleteAll")
+	defer release()
+	done := make(chan error, 1)
+	go func() { done <- installPlan(ctx, dev, f.ip, allDay()) }()
+	<-f.entered
+	replaced := make(chan error, 1)
+	go func() {
+		_, err := startOverride(ctx, dev, f.ip, overrideOn, time.Now().Add(time.Hour))
+		replaced <- err
+	}()
+	// The first override expires while the renewal and the new override wait
+	time.Sleep(200 * time.Millisecond)
+	release()
+	if err := <-done; err != nil {
+		t.Fatalf("installPlan() error = %v", err)
+	}
+	if err := <-replaced; err != nil {
+		t.Fatalf("startOverride() error = %v", err)
+	}
+	// Give the expiry of the first override time to finish
+	time.Sleep(100 * time.Millisecond)
+	if o, ok := activeOverride(dev); !ok || o.Mode != overrideOn {
+		t.Fatalf("override = %+v, %t, want the on override", o, ok)
+	}
+	if output, enabled := f.state(); !output || enabled {
+		t.Errorf("switch on = %t, schedule enabled = %t, want the switch on and the schedule disabled", output, enabled)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// pendingPlans holds one-off runs for each device. Since the Shelly only knows
+// the time of day, runs after midnight must be installed again when the
+// schedules are renewed.
+var pendingPlans = struct {
+	sync.Mutex
+	persisted
+	m map[string]schedule.Schedule
+}{persisted: persisted{what: "pending runs"}, m: make(map[string]schedule.Schedule)}
+
+// loadPending reads the pending runs from filename, which is also where
+// they're saved
+func loadPending(filename string) error {
+	pendingPlans.Lock()
+	defer pendingPlans.Unlock()
+	return pendingPlans.load(filename, &pendingPlans.m)
+}
+
+// addPending keeps runs of dev to be installed by the coming renewals
+func addPending(dev config.Device, runs schedule.Schedule) {
+	if len(runs) == 0 {
+		return
+	}
+	pendingPlans.Lock()
+	defer pendingPlans.Unlock()
+	pendingPlans.m[dev.Name()] = append(pendingPlans.m[dev.Name()], runs...)
+	pendingPlans.save(pendingPlans.m)
+}
+
+// takePending returns the runs of dev that haven't ended by now, and forgets
+// the rest
+func takePending(dev config.Device, now time.Time) schedule.Schedule {
+	pendingPlans.Lock()
+	defer pendingPlans.Unlock()
+	var pending schedule.Schedule
+	for _, e := range pendingPlans.m[dev.Name()] {
+		if e.Stop.After(now) {
+			pending = append(pending, e)
+		}
+	}
+	if len(pending) != len(pendingPlans.m[dev.Name()]) {
+		if len(pending) == 0 {
+			delete(pendingPlans.m, dev.Name())
+		} else {
+			pendingPlans.m[dev.Name()] = pending
+		}
+		pendingPlans.save(pendingPlans.m)
+	}
+	return pending
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// persisted keeps a map of state in a JSON file across restarts. It's embedded
+// next to the map and the mutex guarding both.
+type persisted struct {
+	// what is kept, for the log
+	what     string
+	filename string
+}
+
+// load reads m from filename, which is also where it's saved. A missing file
+// leaves m as it is.
+func (p *persisted) load(filename string, m interface{}) error {
+	p.filename = filename
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, m)
+}
+
+// save writes m to the file it was loaded from, if any. Errors are logged.
+func (p *persisted) save(m interface{}) {
+	if p.filename == "" {
+		return
+	}
+	if err := writeFileAtomic(p.filename, m); err != nil {
+		log.Printf("error saving %s: %s", p.what, err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to filename, through a temporary file so a
+// crash doesn't leave a broken file
+func writeFileAtomic(filename string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
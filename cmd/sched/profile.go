@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/contx"
+)
+
+// profileAuto hands the choice of profile back to the rules in the config
+const profileAuto = "auto"
+
+// manualProfiles holds the profile chosen by hand for each device, replacing
+// the rules in the config
+var manualProfiles = struct {
+	sync.Mutex
+	persisted
+	m map[string]string
+}{persisted: persisted{what: "profiles"}, m: make(map[string]string)}
+
+// profileReport is the response of profileHandler
+type profileReport struct {
+	Device string `json:"device"`
+	// Manual is the profile chosen by hand, if any
+	Manual string `json:"manual,omitempty"`
+	// Today and Tomorrow are the profiles used for the schedules of the days.
+	// Empty means the settings of the device
+	Today    string   `json:"today"`
+	Tomorrow string   `json:"tomorrow"`
+	Profiles []string `json:"profiles"`
+}
+
+// loadProfiles reads the profiles chosen by hand from filename, which is also
+// where they're saved
+func loadProfiles(filename string) error {
+	manualProfiles.Lock()
+	defer manualProfiles.Unlock()
+	return manualProfiles.load(filename, &manualProfiles.m)
+}
+
+// setManualProfile chooses the profile called name for dev. profileAuto goes
+// back to the rules in the config.
+func setManualProfile(dev config.Device, name string) error {
+	if name != profileAuto {
+		if _, ok := dev.WithProfile(name); !ok {
+			return fmt.Errorf("device %s has no profile %q", dev.Name(), name)
+		}
+	}
+	manualProfiles.Lock()
+	defer manualProfiles.Unlock()
+	if name == profileAuto {
+		delete(manualProfiles.m, dev.Name())
+	} else {
+		manualProfiles.m[dev.Name()] = name
+	}
+	log.Printf("device %s: profile set to %s", dev.Name(), name)
+	manualProfiles.save(manualProfiles.m)
+	return nil
+}
+
+// manualProfile returns the profile chosen by hand for dev, if any
+func manualProfile(dev config.Device) (string, bool) {
+	manualProfiles.Lock()
+	defer manualProfiles.Unlock()
+	name, ok := manualProfiles.m[dev.Name()]
+	return name, ok
+}
+
+// activeProfile returns the name of the profile of dev on the date of day: the
+// one chosen by hand, or else the one the rules select. Empty means none.
+func activeProfile(dev config.Device, day time.Time) string {
+	if name, ok := manualProfile(dev); ok {
+		if _, ok := dev.WithProfile(name); ok {
+			return name
+		}
+		log.Printf("device %s: profile %s is no longer configured, using the rules", dev.Name(), name)
+	}
+	return dev.ActiveProfile(day)
+}
+
+// withProfile returns dev with the settings of its profile on the date of day
+func withProfile(dev config.Device, day time.Time) config.Device {
+	name := activeProfile(dev, day)
+	if name == "" {
+		return dev
+	}
+	log.Printf("device %s: using profile %s for %s", dev.Name(), name, day.Format(dateFormat))
+	dev, _ = dev.WithProfile(name)
+	return dev
+}
+
+// profileHandler chooses the profile of a device by hand with `name`, until
+// `name=auto` (or DELETE) hands the choice back to the rules in the config.
+// The profile used today and tomorrow is returned. Schedules are planned a day
+// ahead, so a new profile applies from the next renewal.
+func profileHandler(w http.ResponseWriter, req *http.Request) {
+	ctx := contx.ProcessCommon(req)
+	ip, err := getIP(req, true)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	name := req.URL.Query().Get("name")
+	if req.Method == http.MethodDelete {
+		name = profileAuto
+	}
+	if name != "" && !contx.Pretend(ctx) {
+		if err := setManualProfile(dev, name); err != nil {
+			setStatusMsg(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	now := time.Now().In(deviceZone(ctx, dev, ip))
+	report := profileReport{
+		Device:   dev.Name(),
+		Today:    activeProfile(dev, now),
+		Tomorrow: activeProfile(dev, now.AddDate(0, 0, 1)),
+		Profiles: dev.Profiles(),
+	}
+	report.Manual, _ = manualProfile(dev)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// profileCommand asks the running service to choose the profile of a device
+func profileCommand(args []string) error {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	server := fs.String("server", fmt.Sprintf("http://localhost:%d", port), "address of the running service")
+	device := fs.String("device", "", "name of the device. Default is the default device")
+	name := fs.String("name", "", "profile to use, or \"auto\" to use the rules in the config. Without a name, the active profile is shown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *name != "" {
+		query.Set("name", *name)
+	}
+	if *device != "" {
+		query.Set("device", *device)
+	}
+	return callService(*server, "/profile", query)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// quietConstraints adds the quiet hours of dev to the slots blocked by c
+func quietConstraints(dev config.Device, c *schellydule.Constraints) {
+	if len(dev.QuietHours(time.Monday)) == 0 && len(dev.QuietHours(time.Sunday)) == 0 {
+		return
+	}
+	blocked := c.Blocked
+	c.Blocked = func(s schellydule.Slot) bool {
+		if blocked != nil && blocked(s) {
+			return true
+		}
+		return dev.Quiet(s.Start, s.Length)
+	}
+}
+
+// fitAllowed returns the number of slots to select from slots, when n are
+// wanted: n, if that many aren't blocked by c. Otherwise it's an error, or
+// with the QuietReduce policy of dev, as many as aren't blocked.
+func fitAllowed(dev config.Device, slots schellydule.Slots, n int, c schellydule.Constraints) (int, error) {
+	allowed := len(slots.Allowed(c))
+	// Without blocked slots, it's up to the strategy to tell if there's room
+	if n <= allowed || allowed == len(slots) {
+		return n, nil
+	}
+	length := dev.SlotLength()
+	if dev.QuietPolicy() != config.QuietReduce {
+		return 0, fmt.Errorf("%w: %s needed, but only %s is outside the quiet hours and blocked periods", schellydule.ErrInfeasible, time.Duration(n)*length, time.Duration(allowed)*length)
+	}
+	log.Printf("device %s: only %s is outside the quiet hours and blocked periods, running that instead of %s", dev.Name(), time.Duration(allowed)*length, time.Duration(n)*length)
+	return allowed, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// switchMargin is the time around a scheduled switch where the state of the
+// switch isn't checked, since the Shelly may not have run the job yet
+const switchMargin = time.Minute
+
+// applied holds the jobs last installed on each device, so drift can be found,
+// also after a restart
+var applied = struct {
+	sync.Mutex
+	persisted
+	m map[string]shelly.Schedules
+}{persisted: persisted{what: "installed schedules"}, m: make(map[string]shelly.Schedules)}
+
+// reconcileReports holds the result of the last check of each device
+var reconcileReports = struct {
+	sync.Mutex
+	m map[string]reconcileReport
+}{m: make(map[string]reconcileReport)}
+
+// deviceLocks keeps the reconciler from checking a device while a schedule is
+// being installed on it
+var deviceLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+// reconcileReport is the result of checking a device for drift
+type reconcileReport struct {
+	Checked time.Time `json:"checked"`
+	// Drift describes how the device differs from the installed schedule
+	Drift    []string `json:"drift,omitempty"`
+	Repaired bool     `json:"repaired,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// lockDevice locks the device called name, and returns the function unlocking it
+func lockDevice(name string) func() {
+	deviceLocks.Lock()
+	mu, ok := deviceLocks.m[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		deviceLocks.m[name] = mu
+	}
+	deviceLocks.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// loadApplied reads the installed jobs from filename, which is also where they're saved
+func loadApplied(filename string) error {
+	applied.Lock()
+	defer applied.Unlock()
+	return applied.load(filename, &applied.m)
+}
+
+// recordApplied records that jobs were installed on dev
+func recordApplied(dev config.Device, jobs shelly.Schedules) {
+	applied.Lock()
+	defer applied.Unlock()
+	applied.m[dev.Name()] = jobs
+	applied.save(applied.m)
+}
+
+// appliedJobs returns the jobs last installed on dev
+func appliedJobs(dev config.Device) (shelly.Schedules, bool) {
+	applied.Lock()
+	defer applied.Unlock()
+	jobs, ok := applied.m[dev.Name()]
+	return jobs, ok
+}
+
+// startReconcilers checks the configured devices for drift in the background,
+// according to their reconcile policy
+func startReconcilers(conf config.Config) {
+	for _, d := range conf.Devices() {
+		if d.IP() == nil || d.Reconcile() == config.ReconcileOff {
+			continue
+		}
+		go func(dev config.Device) {
+			ticker := time.NewTicker(dev.ReconcileInterval())
+			defer ticker.Stop()
+			for range ticker.C {
+				ctx, cancel := context.WithTimeout(context.Background(), dev.ReconcileInterval())
+				report := reconcile(ctx, dev)
+				cancel()
+				reconcileReports.Lock()
+				reconcileReports.m[dev.Name()] = report
+				reconcileReports.Unlock()
+			}
+		}(d)
+	}
+}
+
+// lastReconcile returns the result of the last check of dev
+func lastReconcile(dev config.Device) (reconcileReport, bool) {
+	reconcileReports.Lock()
+	defer reconcileReports.Unlock()
+	r, ok := reconcileReports.m[dev.Name()]
+	return r, ok
+}
+
+// reconcile compares the jobs on dev with the ones last installed, and the
+// switch with what the jobs demand. Drift is logged, and repaired if that's
+// the policy of dev.
+func reconcile(ctx context.Context, dev config.Device) reconcileReport {
+	report := reconcileReport{Checked: time.Now()}
+	want, ok := appliedJobs(dev)
+	if !ok {
+		// Nothing installed yet, so nothing to compare with
+		return report
+	}
+	if _, ok := activeOverride(dev); ok {
+		// The schedule is disabled and the switch overridden on purpose
+		return report
+	}
+	if _, ok := shedSince(dev); ok {
+		// The switch is off on purpose, to stay below the power limit
+		return report
+	}
+	defer lockDevice(dev.Name())()
+	ip := dev.IP()
+	repair := dev.Reconcile() == config.ReconcileRepair
+	// Clock problems are logged by verifyClock
+	clock, _ := verifyClock(ctx, dev, ip)
+	fail := func(err error) reconcileReport {
+		log.Printf("device %s: error checking for drift: %s", dev.Name(), err)
+		report.Error = err.Error()
+		report.Drift = append(clock, report.Drift...)
+		return report
+	}
+
+	loc := deviceZone(ctx, dev, ip)
+	got, err := shelly.GetSchedules(ctx, ip)
+	if err != nil {
+		return fail(err)
+	}
+	drift, err := schellydule.FindDrift(want, got, loc)
+	if err != nil {
+		return fail(err)
+	}
+	report.Drift = append(report.Drift, drift.Describe()...)
+	if !drift.Empty() && repair {
+		if got, err = reinstall(ctx, dev, ip, want); err != nil {
+			return fail(fmt.Errorf("repairing schedule: %w", err))
+		}
+		report.Repaired = true
+	}
+
+	// The switch is only controlled by the schedule while the schedule is enabled
+	now := time.Now()
+	if enabled(got) && !nearSwitch(got, loc, now) {
+		on, err := scheduledOn(got, loc, now)
+		if err != nil {
+			return fail(err)
+		}
+		status, err := shelly.GetSwitchStatus(ctx, ip)
+		if err != nil {
+			return fail(err)
+		}
+		if status.Output != on {
+			report.Drift = append(report.Drift, fmt.Sprintf("switch is %s, but the schedule says %s", onOff(status.Output), onOff(on)))
+			if repair {
+				if err := shelly.SetSwitch(ctx, ip, shelly.State(on)); err != nil {
+					return fail(fmt.Errorf("repairing switch: %w", err))
+				}
+				report.Repaired = true
+			}
+		}
+	}
+
+	for _, d := range report.Drift {
+		log.Printf("device %s: drift: %s", dev.Name(), d)
+	}
+	if report.Repaired {
+		log.Printf("device %s: drift repaired", dev.Name())
+	}
+	report.Drift = append(clock, report.Drift...)
+	return report
+}
+
+// reinstall replaces the jobs on dev at ip with jobs, enabled according to the
+// input, and returns the jobs installed
+func reinstall(ctx context.Context, dev config.Device, ip fmt.Stringer, jobs shelly.Schedules) (shelly.Schedules, error) {
+	enable, err := shelly.GetInputState(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	s := shelly.Schedule{Jobs: make(shelly.Schedules, 0, len(jobs))}
+	for _, j := range jobs {
+		j.Id, j.Enable = 0, enable
+		s.Jobs = append(s.Jobs, j)
+	}
+	if err := shelly.DeleteAllSchedules(ctx, ip); err != nil {
+		return nil, err
+	}
+	if err := shelly.CreateSchedule(ctx, ip, s); err != nil {
+		return nil, err
+	}
+	if err := shelly.CreateScheduleRefresherSchedule(ctx, ip, port, dev.Name()); err != nil {
+		return nil, err
+	}
+	return s.Jobs, nil
+}
+
+// enabled returns true if any of the switch jobs is enabled
+func enabled(jobs shelly.Schedules) bool {
+	for _, j := range jobs {
+		if j.Enable && j.HasMethod("switch.set") {
+			return true
+		}
+	}
+	return false
+}
+
+// nearSwitch returns true if now is within switchMargin of a switch job
+func nearSwitch(jobs shelly.Schedules, loc *time.Location, now time.Time) bool {
+	for _, j := range jobs {
+		if !j.HasMethod("switch.set") {
+			continue
+		}
+		t, err := j.TimeIn(loc)
+		if err != nil {
+			continue
+		}
+		if d := now.Sub(t); d > -switchMargin && d < switchMargin {
+			return true
+		}
+	}
+	return false
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/history"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// sampleInterval is how often the relay state of each device is sampled
+const sampleInterval = time.Minute
+
+// runtimes holds the delivered runtime of each device
+var runtimes *history.Store
+
+// trackRuntime samples the relay state of all devices with a configured IP
+// every sampleInterval, recording how long they actually run.
+func trackRuntime(conf config.Config) {
+	for range time.Tick(sampleInterval) {
+		for _, dev := range conf.Devices() {
+			if dev.IP() == nil {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), sampleInterval/2)
+			status, err := shelly.GetSwitchStatus(ctx, dev.IP())
+			if err != nil {
+				cancel()
+				log.Printf("device %s: error getting switch status: %s", dev.Name(), err)
+				continue
+			}
+			// Days are counted in the device's time zone
+			now := time.Now().In(deviceZone(ctx, dev, dev.IP()))
+			cancel()
+			runtimes.Record(dev.Name(), now, status.Output, status.AEnergy.Total)
+		}
+		if err := runtimes.Save(); err != nil {
+			log.Printf("error saving runtime history: %s", err)
+		}
+	}
+}
+
+// carryRuntime returns the runtime to add to (or subtract from) the plan of
+// dev on the date of day, to make up for what it didn't run (or ran extra) the
+// day before. It's rounded to whole slots.
+func carryRuntime(dev config.Device, day time.Time) time.Duration {
+	if runtimes == nil {
+		return 0
+	}
+	carry := runtimes.Carry(dev.Name(), day, dev.CarryCap(), dev.CarryDecay())
+	return carry.Round(dev.SlotLength())
+}
+
+// runtimeOf returns the total runtime of s
+func runtimeOf(s schedule.Schedule) time.Duration {
+	var rv time.Duration
+	for _, e := range s {
+		rv += e.Stop.Sub(e.Start)
+	}
+	return rv
+}
+
+// recordPlanned records the runtime s plans for dev on the date of day
+func recordPlanned(dev config.Device, day time.Time, s schedule.Schedule) {
+	var planned time.Duration
+	y, m, d := day.Date()
+	for _, e := range s {
+		if ey, em, ed := e.Start.Date(); ey == y && em == m && ed == d {
+			planned += e.Stop.Sub(e.Start)
+		}
+	}
+	runtimes.SetPlanned(dev.Name(), day, planned)
+	if err := runtimes.Save(); err != nil {
+		log.Printf("error saving runtime history: %s", err)
+	}
+}
+
+// runtimeReport is the response of runtimeHandler
+type runtimeReport struct {
+	Days map[string]runtimeDay `json:"days"`
+	// Carry is the number of minutes added to tomorrow's plan
+	Carry int `json:"carry_minutes"`
+}
+
+type runtimeDay struct {
+	Planned   int     `json:"planned_minutes"`
+	Delivered int     `json:"delivered_minutes"`
+	Energy    float64 `json:"energy_wh,omitempty"`
+	Boosted   int     `json:"boosted_minutes,omitempty"`
+	Solar     int     `json:"solar_minutes,omitempty"`
+}
+
+// runtimeHandler returns the planned and delivered runtime of the device for
+// the last `days` days (default 7)
+func runtimeHandler(w http.ResponseWriter, req *http.Request) {
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	ip, err := getIP(req, true)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	days := 7
+	if d, err := strconv.Atoi(req.URL.Query().Get("days")); err == nil && d > 0 {
+		days = d
+	}
+	now := time.Now().In(deviceZone(req.Context(), dev, ip))
+	report := runtimeReport{
+		Days:  make(map[string]runtimeDay),
+		Carry: int(carryRuntime(dev, now.AddDate(0, 0, 1)).Minutes()),
+	}
+	for k, d := range runtimes.Days(dev.Name(), now.AddDate(0, 0, -days+1), now) {
+		report.Days[k] = runtimeDay{
+			Planned:   int(d.Planned.Minutes()),
+			Delivered: int(d.Delivered.Minutes()),
+			Energy:    d.Energy,
+			Boosted:   int(d.Boosted.Minutes()),
+			Solar:     int(d.Solar.Minutes()),
+		}
+	}
+	out, err := json.Marshal(report)
+	if err != nil {
+		setStatusMsg(w, http.StatusInternalServerError, err)
+		return
+	}
+	setStatusMsg(w, http.StatusOK, out)
+}
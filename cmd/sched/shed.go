@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// shedHold is the least time a device is kept off after being switched off for
+// the power limit, so it isn't switched on and off with every reading
+const shedHold = 5 * time.Minute
+
+// shedDevices holds when each device switched off for the power limit was
+// switched off
+var shedDevices = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// shedSince returns when dev was switched off for the power limit, if it's off
+// for that
+func shedSince(dev config.Device) (time.Time, bool) {
+	shedDevices.Lock()
+	defer shedDevices.Unlock()
+	t, ok := shedDevices.m[dev.Name()]
+	return t, ok
+}
+
+// manageLoad switches devices off while the power imported from the grid is
+// over limit, lowest priority first, and back on, one at a time, when there's
+// room for them again
+func manageLoad(ctx context.Context, grid float64, devices []config.Device, limit float64) {
+	byName := make(map[string]config.Device, len(devices))
+	for _, dev := range devices {
+		byName[dev.Name()] = dev
+	}
+	if grid > limit {
+		var loads []schellydule.Load
+		for _, dev := range devices {
+			if _, ok := activeOverride(dev); ok {
+				continue
+			}
+			// A device switched off before may have been switched on again by
+			// its schedule, so they're all checked
+			status, err := shelly.GetSwitchStatus(ctx, dev.IP())
+			if err != nil {
+				log.Printf("device %s: error getting switch status: %s", dev.Name(), err)
+				continue
+			}
+			if !status.Output {
+				continue
+			}
+			watts := status.APower
+			if watts <= 0 {
+				watts = dev.RatedWatts()
+			}
+			loads = append(loads, schellydule.Load{Name: dev.Name(), Watts: watts, Priority: dev.Priority()})
+		}
+		for _, l := range schellydule.Shed(grid, limit, loads) {
+			dev := byName[l.Name]
+			if err := shelly.SetSwitch(ctx, dev.IP(), shelly.StateOff); err != nil {
+				log.Printf("device %s: error switching off for the power limit: %s", dev.Name(), err)
+				continue
+			}
+			shedDevices.Lock()
+			if _, ok := shedDevices.m[dev.Name()]; !ok {
+				shedDevices.m[dev.Name()] = time.Now()
+			}
+			shedDevices.Unlock()
+			log.Printf("device %s: switched off, the household is using %.0f W, over the limit of %.0f W", dev.Name(), grid, limit)
+		}
+		return
+	}
+
+	// Only one device is restored per reading, so the next reading includes it
+	var shed []schellydule.Load
+	for _, dev := range devices {
+		since, ok := shedSince(dev)
+		if !ok {
+			continue
+		}
+		// An override takes control of the switch
+		if _, ok := activeOverride(dev); ok {
+			shedDevices.Lock()
+			delete(shedDevices.m, dev.Name())
+			shedDevices.Unlock()
+			continue
+		}
+		if time.Since(since) >= shedHold {
+			shed = append(shed, schellydule.Load{Name: dev.Name(), Watts: dev.RatedWatts(), Priority: dev.Priority()})
+		}
+	}
+	l, ok := schellydule.Restorable(grid, limit, shed)
+	if !ok {
+		return
+	}
+	dev := byName[l.Name]
+	since, _ := shedSince(dev)
+	if err := restoreDevice(ctx, dev, since); err != nil {
+		log.Printf("device %s: error restoring after the power limit: %s", dev.Name(), err)
+	}
+}
+
+// restoreDevice lets dev run again after being switched off for the power
+// limit since `since`. The runtime it lost is added to today's schedule from
+// now, outside quiet hours and blocked periods, so the device is switched on if
+// it lost any, or if it's scheduled to run. If the runtime doesn't fit, the
+// quiet hours policy of dev decides if what fits is added, or nothing.
+func restoreDevice(ctx context.Context, dev config.Device, since time.Time) error {
+	ip := dev.IP()
+	loc := deviceZone(ctx, dev, ip)
+	now := time.Now().In(loc).Truncate(time.Second)
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	var c schellydule.Constraints
+	calendarConstraints(dev, now, midnight, &c)
+	quietConstraints(dev, &c)
+	var lost, left time.Duration
+	_, today, err := changeToday(ctx, dev, ip, now, func(s schedule.Schedule) schedule.Schedule {
+		lost = schellydule.RuntimeBetween(s, since, now)
+		var extended schedule.Schedule
+		extended, left = schellydule.Extend(s, now, lost, midnight, c, dev.SlotLength())
+		if left > 0 && dev.QuietPolicy() != config.QuietReduce {
+			return s
+		}
+		return extended
+	})
+	if err != nil {
+		return err
+	}
+	shedDevices.Lock()
+	delete(shedDevices.m, dev.Name())
+	shedDevices.Unlock()
+	if left > 0 && dev.QuietPolicy() != config.QuietReduce {
+		return fmt.Errorf("%w: restored without the %s it lost, only %s is left outside the quiet hours and blocked periods today", schellydule.ErrInfeasible, lost, lost-left)
+	}
+	if runtimes != nil && lost > 0 {
+		recordPlanned(dev, now, today)
+	}
+	if left > 0 {
+		log.Printf("device %s: restored after the power limit, adding %s of the %s it lost, the rest is in quiet hours or blocked periods", dev.Name(), lost-left, lost)
+		return nil
+	}
+	log.Printf("device %s: restored after the power limit, adding the %s it lost", dev.Name(), lost)
+	return nil
+}
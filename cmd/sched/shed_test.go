@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// resetShed forgets the devices switched off in a test when it ends
+func resetShed(t *testing.T) {
+	t.Cleanup(func() {
+		shedDevices.Lock()
+		defer shedDevices.Unlock()
+		shedDevices.m = make(map[string]time.Time)
+	})
+}
+
+// hourNow returns the start of the current hour in UTC, skipping the test if
+// the runs around it don't fit in today, since the Shelly schedules are times of
+// day
+func hourNow(t *testing.T) time.Time {
+	t.Helper()
+	now := time.Now().UTC()
+	if now.Hour() < 1 || now.Hour() > 21 {
+		t.Skip("the runs of the test don't fit in today at this hour")
+	}
+	return now.Truncate(time.Hour)
+}
+
+// running installs a plan on dev that runs from an hour before h to an hour
+// after, and sets the load of its switch
+func running(t *testing.T, dev config.Device, f *fakeShelly, h time.Time, watts float64) {
+	t.Helper()
+	plan := schellydule.Plan{Schedule: schedule.Schedule{{Start: h.Add(-time.Hour), Stop: h.Add(time.Hour)}}}
+	if err := installPlan(context.Background(), dev, f.ip, plan); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	f.apower = watts
+	f.mu.Unlock()
+	if output, _ := f.state(); !output {
+		t.Fatal("switch is off, want it on while the plan runs")
+	}
+}
+
+// fakeMeter reads the household's grid power as base, with the load of the
+// fake Shellies that are on
+type fakeMeter struct {
+	base  float64
+	fakes []*fakeShelly
+}
+
+var _ schellydule.Meter = (*fakeMeter)(nil)
+
+func (m *fakeMeter) GridPower(ctx context.Context) (float64, error) {
+	grid := m.base
+	for _, f := range m.fakes {
+		f.mu.Lock()
+		if f.output {
+			grid += f.apower
+		}
+		f.mu.Unlock()
+	}
+	return grid, nil
+}
+
+// manageLoadAt reads m with base, and manages the load of the devices of c
+func manageLoadAt(t *testing.T, c config.Config, m *fakeMeter, base float64) {
+	t.Helper()
+	ctx := context.Background()
+	m.base = base
+	grid, err := m.GridPower(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manageLoad(ctx, grid, c.Devices(), c.PowerLimit())
+}
+
+// twoDevices configures the default device on low and a device called high on
+// high, with a higher priority
+func twoDevices(t *testing.T, low, high *fakeShelly, conf string) (config.Config, config.Device, config.Device) {
+	t.Helper()
+	c := loadTestConfig(t, low.conf(fmt.Sprintf("power_limit = 5000\nrated_watts = 2000\npriority = 1\n%s\n[device.high]\nshelly_ip = %q\nrated_watts = 2000\npriority = 2", conf, high.ip)))
+	dev, ok := c.GetDevice("high")
+	if !ok {
+		t.Fatal("device high isn't configured")
+	}
+	return c, c.Device, dev
+}
+
+func TestManageLoad(t *testing.T) {
+	h := hourNow(t)
+	low, high := newFakeShelly(t), newFakeShelly(t)
+	c, lowDev, highDev := twoDevices(t, low, high, "")
+	resetShed(t)
+	resetOverrides(t)
+	running(t, lowDev, low, h, 2000)
+	running(t, highDev, high, h, 2000)
+	m := &fakeMeter{fakes: []*fakeShelly{low, high}}
+
+	// 6000 W, over the limit by one device, so the low priority one is off
+	manageLoadAt(t, c, m, 2000)
+	if on, _ := low.state(); on {
+		t.Error("low priority device is on, want it off")
+	}
+	if on, _ := high.state(); !on {
+		t.Error("high priority device is off, want it on")
+	}
+	if _, ok := shedSince(lowDev); !ok {
+		t.Fatal("low priority device isn't recorded as switched off")
+	}
+	if _, ok := shedSince(highDev); ok {
+		t.Error("high priority device is recorded as switched off")
+	}
+
+	// There's room again, but the device is kept off for shedHold
+	manageLoadAt(t, c, m, 0)
+	if on, _ := low.state(); on {
+		t.Error("device is on before shedHold, want it off")
+	}
+	if _, ok := shedSince(lowDev); !ok {
+		t.Fatal("device is no longer recorded as switched off before shedHold")
+	}
+
+	shedDevices.Lock()
+	shedDevices.m[lowDev.Name()] = time.Now().Add(-shedHold - time.Minute)
+	shedDevices.Unlock()
+	manageLoadAt(t, c, m, 0)
+	if on, _ := low.state(); !on {
+		t.Error("device is off after shedHold, want it restored")
+	}
+	if _, ok := shedSince(lowDev); ok {
+		t.Error("restored device is still recorded as switched off")
+	}
+}
+
+func TestManageLoad_override(t *testing.T) {
+	h := hourNow(t)
+	low, high := newFakeShelly(t), newFakeShelly(t)
+	c, lowDev, highDev := twoDevices(t, low, high, "")
+	resetShed(t)
+	resetOverrides(t)
+	ctx := context.Background()
+	running(t, lowDev, low, h, 2000)
+	running(t, highDev, high, h, 2000)
+	m := &fakeMeter{fakes: []*fakeShelly{low, high}}
+	if _, err := startOverride(ctx, lowDev, low.ip, overrideOn, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The device with an override is left on, so the other one is switched off
+	manageLoadAt(t, c, m, 2000)
+	if on, _ := low.state(); !on {
+		t.Error("device with an override is off, want it on")
+	}
+	if _, ok := shedSince(lowDev); ok {
+		t.Error("device with an override is recorded as switched off")
+	}
+	if on, _ := high.state(); on {
+		t.Error("device without an override is on, want it off")
+	}
+
+	// A device switched off before its override started is left to the override
+	shedDevices.Lock()
+	shedDevices.m[lowDev.Name()] = time.Now()
+	shedDevices.Unlock()
+	manageLoadAt(t, c, m, 0)
+	if _, ok := shedSince(lowDev); ok {
+		t.Error("device with an override is still recorded as switched off")
+	}
+	if _, ok := shedSince(highDev); !ok {
+		t.Error("device without an override isn't recorded as switched off before shedHold")
+	}
+}
+
+func TestRestoreDevice_quietHours(t *testing.T) {
+	h := hourNow(t)
+	// Only the first 15 minutes after the run are outside the quiet hours
+	quiet := fmt.Sprintf("slot_length = 15\nquiet_hours = [\"%s-24:00\"]", h.Add(time.Hour+15*time.Minute).Format("15:04"))
+	tests := []struct {
+		name   string
+		policy string
+		// stop is when the run stops after restoring, counted from h
+		stop    time.Duration
+		wantErr bool
+	}{
+		{name: "error", policy: config.QuietError, stop: time.Hour, wantErr: true},
+		{name: "reduce", policy: config.QuietReduce, stop: time.Hour + 15*time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low, high := newFakeShelly(t), newFakeShelly(t)
+			_, dev, _ := twoDevices(t, low, high, fmt.Sprintf("%s\nquiet_hours_policy = %q", quiet, tt.policy))
+			resetShed(t)
+			resetOverrides(t)
+			ctx := context.Background()
+			running(t, dev, low, h, 2000)
+			since := time.Now().Truncate(time.Second).Add(-20 * time.Minute)
+			shedDevices.Lock()
+			shedDevices.m[dev.Name()] = since
+			shedDevices.Unlock()
+
+			err := restoreDevice(ctx, dev, since)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restoreDevice() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, schellydule.ErrInfeasible) {
+				t.Errorf("restoreDevice() error = %v, want %v", err, schellydule.ErrInfeasible)
+			}
+			if on, _ := low.state(); !on {
+				t.Error("device is off, want it restored")
+			}
+			if _, ok := shedSince(dev); ok {
+				t.Error("restored device is still recorded as switched off")
+			}
+			lastPlans.Lock()
+			s := lastPlans.m[dev.Name()].Schedule
+			lastPlans.Unlock()
+			if want := (schedule.Schedule{{Start: h.Add(-time.Hour), Stop: h.Add(tt.stop)}}); !sameRuns(s, want) {
+				t.Errorf("schedule = %v, want %v", s, want)
+			}
+		})
+	}
+}
+
+// sameRuns tells if a and b run at the same times
+func sameRuns(a, b schedule.Schedule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Start.Equal(b[i].Start) || !a[i].Stop.Equal(b[i].Stop) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adamhassel/power"
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+const dateFormat = "2006-01-02"
+
+// simulationReport is the result of a backtest of one strategy
+type simulationReport struct {
+	Strategy         schellydule.Strategy `json:"strategy"`
+	Days             int                  `json:"days"`
+	Failed           int                  `json:"failed"`
+	RuntimeMinutes   int                  `json:"runtime_minutes"`
+	Cost             float64              `json:"cost"`
+	AvgPrice         float64              `json:"avg_price"`
+	BaselineCost     float64              `json:"baseline_cost"`
+	BaselineAvgPrice float64              `json:"baseline_avg_price"`
+	// Savings is the difference to paying the baseline's average price for the
+	// same runtime
+	Savings float64  `json:"savings"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// simulate replays each of strategies for dev on prices, from the date `from`
+// to the date `to`. query holds plan parameters overriding the settings of dev,
+// as for the other endpoints. Costs are for a load of `watts`.
+func simulate(dev config.Device, query url.Values, prices schedule.HourPrices, from, to time.Time, strategies []schellydule.Strategy, watts float64) ([]simulationReport, error) {
+	rv := make([]simulationReport, 0, len(strategies))
+	prices = tariff().Apply(prices, from.Location())
+	for _, strategy := range strategies {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("strategy", string(strategy))
+		q.Set("offset", "0")
+		// Carry-over depends on what actually ran, so it's left out
+		if q.Get("hours") == "" && q.Get("minutes") == "" {
+			q.Set("minutes", strconv.Itoa(int(dev.Runtime().Minutes())))
+		}
+		p, err := reqPlanParams(q, dev, false, from.Location())
+		if err != nil {
+			return nil, err
+		}
+		p.daily = true
+		bt := schellydule.Simulate(prices, from, to, dayPlanner(dev, p, prices))
+		r := simulationReport{
+			Strategy:         strategy,
+			Days:             len(bt.Days),
+			Failed:           bt.Failed,
+			RuntimeMinutes:   int(bt.Runtime.Minutes()),
+			Cost:             bt.Cost * watts / 1000,
+			AvgPrice:         bt.AvgPrice(),
+			BaselineCost:     bt.BaselineCost * watts / 1000,
+			BaselineAvgPrice: bt.BaselineAvgPrice(),
+			Savings:          bt.Savings() * watts / 1000,
+		}
+		for _, d := range bt.Days {
+			if d.Err != nil {
+				r.Errors = append(r.Errors, fmt.Sprintf("%s: %s", d.Day.Format(dateFormat), d.Err))
+			}
+		}
+		rv = append(rv, r)
+	}
+	return rv, nil
+}
+
+// dayPlanner returns the planner of each simulated day of dev with p. A day is
+// planned on its own prices, or on those of prices in its deadline window if it
+// crosses midnight.
+func dayPlanner(dev config.Device, p planParams, prices schedule.HourPrices) schellydule.Planner {
+	return func(day time.Time, hp schedule.HourPrices) (schellydule.Plan, error) {
+		p.start = day
+		if start, stop := planWindow(p); stop.After(day.AddDate(0, 0, 1)) {
+			hp = pricesBetween(prices, start, stop)
+		}
+		return planPrices(dev, p, hp)
+	}
+}
+
+// pricesBetween returns the prices of prices starting from `from` and before to
+func pricesBetween(prices schedule.HourPrices, from, to time.Time) schedule.HourPrices {
+	var rv schedule.HourPrices
+	for _, hp := range prices {
+		if !hp.Hour.Before(from) && hp.Hour.Before(to) {
+			rv = append(rv, hp)
+		}
+	}
+	return rv
+}
+
+// historicalPrices gets the prices from the date `from` to the date `to` from
+// the price source
+func historicalPrices(from, to time.Time) (schedule.HourPrices, error) {
+	prices, err := power.Prices(from, to.AddDate(0, 0, 1), config.GetConf(), true)
+	if err != nil {
+		return nil, err
+	}
+	return schedule.FPToHourPrices(prices), nil
+}
+
+// parseStrategies parses a comma separated list of strategies. An empty list is
+// the strategy of dev
+func parseStrategies(s string, dev config.Device) ([]schellydule.Strategy, error) {
+	if s == "" {
+		s = dev.Strategy()
+	}
+	var rv []schellydule.Strategy
+	for _, name := range strings.Split(s, ",") {
+		strategy, err := schellydule.ParseStrategy(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, strategy)
+	}
+	return rv, nil
+}
+
+// parseDateRange parses the dates from and to in loc. If to is empty, it's
+// yesterday.
+func parseDateRange(from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation(dateFormat, from, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, should be YYYY-MM-DD", from)
+	}
+	end := schedule.Hour(time.Now().In(loc), 0).AddDate(0, 0, -1)
+	if to != "" {
+		if end, err = time.ParseInLocation(dateFormat, to, loc); err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, should be YYYY-MM-DD", to)
+		}
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s is before %s", to, from)
+	}
+	return start, end, nil
+}
+
+// simulationZone returns the time zone to simulate dev in. The Shelly is only
+// asked if its IP is configured.
+func simulationZone(ctx context.Context, dev config.Device) *time.Location {
+	if ip := dev.IP(); ip != nil {
+		return deviceZone(ctx, dev, ip)
+	}
+	if loc := dev.TimeZone(); loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
+// simulateHandler backtests strategies on historical prices. GET uses the
+// price source, POST the prices in the CSV request body.
+func simulateHandler(w http.ResponseWriter, req *http.Request) {
+	dev, err := getDevice(req)
+	if err != nil {
+		setStatusMsg(w, ipErrStatus(err), err.Error())
+		return
+	}
+	q := req.URL.Query()
+	loc := simulationZone(req.Context(), dev)
+	from, to, err := parseDateRange(q.Get("from"), q.Get("to"), loc)
+	if err != nil {
+		setStatusMsg(w, http.StatusBadRequest, err)
+		return
+	}
+	strategies, err := parseStrategies(q.Get("strategies"), dev)
+	if err != nil {
+		setStatusMsg(w, http.StatusBadRequest, err)
+		return
+	}
+	watts := 1000.0
+	if ws := q.Get("watts"); ws != "" {
+		if watts, err = strconv.ParseFloat(ws, 64); err != nil {
+			setStatusMsg(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	// from and to are dates here, not times of day as for the deadline strategy
+	q.Del("from")
+	q.Del("to")
+	var prices schedule.HourPrices
+	if req.Method == http.MethodPost {
+		prices, err = schellydule.ReadPricesCSV(req.Body, loc)
+		if err != nil {
+			setStatusMsg(w, http.StatusBadRequest, err)
+			return
+		}
+	} else if prices, err = historicalPrices(from, to); err != nil {
+		setStatusMsg(w, http.StatusBadGateway, err)
+		return
+	}
+	reports, err := simulate(dev, q, prices, from, to, strategies, watts)
+	if err != nil {
+		setStatusMsg(w, http.StatusBadRequest, err)
+		return
+	}
+	out, err := json.Marshal(reports)
+	if err != nil {
+		setStatusMsg(w, http.StatusInternalServerError, err)
+		return
+	}
+	setStatusMsg(w, http.StatusOK, out)
+}
+
+// simulateCommand backtests strategies on historical prices, and prints them
+// side by side. It doesn't need the service to run.
+func simulateCommand(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	device := fs.String("device", "", "name of the device to simulate. Default is the default device")
+	from := fs.String("from", "", "first date (YYYY-MM-DD) to simulate")
+	to := fs.String("to", "", "last date (YYYY-MM-DD) to simulate. Default is yesterday")
+	strategies := fs.String("strategy", "", "comma separated strategies to compare. Default is the configured strategy")
+	csvFile := fs.String("csv", "", "read prices from this CSV file instead of the price source")
+	watts := fs.Float64("watts", 1000, "power of the appliance")
+	hours := fs.Int("hours", 0, "number of hours to run each day. Default is the configured runtime")
+	dark := fs.Int("dark", -1, "maximum hours to run at night. Default is the configured darkhours")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf("-from is required")
+	}
+	conf := config.GetConf()
+	dev := conf.Device
+	if *device != "" {
+		var ok bool
+		if dev, ok = conf.GetDevice(*device); !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownDevice, *device)
+		}
+	}
+	loc := simulationZone(context.Background(), dev)
+	start, end, err := parseDateRange(*from, *to, loc)
+	if err != nil {
+		return err
+	}
+	list, err := parseStrategies(*strategies, dev)
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *hours != 0 {
+		query.Set("hours", strconv.Itoa(*hours))
+	}
+	if *dark >= 0 {
+		query.Set("dark", strconv.Itoa(*dark))
+	}
+	var prices schedule.HourPrices
+	if *csvFile != "" {
+		f, err := os.Open(*csvFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if prices, err = schellydule.ReadPricesCSV(f, loc); err != nil {
+			return fmt.Errorf("%s: %w", *csvFile, err)
+		}
+	} else if prices, err = historicalPrices(start, end); err != nil {
+		return err
+	}
+	reports, err := simulate(dev, query, prices, start, end, list, *watts)
+	if err != nil {
+		return err
+	}
+	printSimulation(reports)
+	return nil
+}
+
+// printSimulation prints reports as a table with a column per strategy
+func printSimulation(reports []simulationReport) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	row := func(name string, f func(r simulationReport) string) {
+		fmt.Fprint(tw, name, "\t")
+		for _, r := range reports {
+			fmt.Fprint(tw, f(r), "\t")
+		}
+		fmt.Fprintln(tw)
+	}
+	row("", func(r simulationReport) string { return string(r.Strategy) })
+	row("days", func(r simulationReport) string { return strconv.Itoa(r.Days) })
+	row("failed days", func(r simulationReport) string { return strconv.Itoa(r.Failed) })
+	row("hours delivered", func(r simulationReport) string { return fmt.Sprintf("%.2f", float64(r.RuntimeMinutes)/60) })
+	row("total cost", func(r simulationReport) string { return fmt.Sprintf("%.2f", r.Cost) })
+	row("average price", func(r simulationReport) string { return fmt.Sprintf("%.3f", r.AvgPrice) })
+	row("baseline cost", func(r simulationReport) string { return fmt.Sprintf("%.2f", r.BaselineCost) })
+	row("baseline average", func(r simulationReport) string { return fmt.Sprintf("%.3f", r.BaselineAvgPrice) })
+	row("savings", func(r simulationReport) string { return fmt.Sprintf("%.2f", r.Savings) })
+	tw.Flush()
+	for _, r := range reports {
+		for _, e := range r.Errors {
+			fmt.Printf("%s: %s\n", r.Strategy, e)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// loadTestConfig loads a config with conf and the required settings, and
+// returns it. It's also the config returned by config.GetConf.
+func loadTestConfig(t *testing.T, conf string) config.Config {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "schedule.conf")
+	data := "mid = \"123456789012345678\"\ntoken = \"token\"\n" + conf + "\n"
+	if err := ioutil.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSimulate(t *testing.T) {
+	first := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	// Three days of prices, the last one much cheaper, and cheapest from 02:00
+	// to 04:00 each day
+	var prices schedule.HourPrices
+	for h := 0; h < 72; h++ {
+		price := 1.0
+		if h >= 48 {
+			price = 0.1
+		}
+		if h%24 == 2 || h%24 == 3 {
+			price /= 2
+		}
+		prices = append(prices, schedule.HourPrice{Hour: first.Add(time.Duration(h) * time.Hour), Price: price})
+	}
+	tests := []struct {
+		name     string
+		conf     string
+		strategy schellydule.Strategy
+		// from and to are the times of day each day's runs must be within,
+		// counted from the start of the day
+		from, to time.Duration
+	}{
+		{name: "cheapest", strategy: schellydule.StrategyCheapest, to: 24 * time.Hour},
+		{name: "deadline across midnight", conf: "earliest_start = \"22:00\"\ndeadline = \"06:00\"", strategy: schellydule.StrategyDeadline, from: 22 * time.Hour, to: 30 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := loadTestConfig(t, tt.conf).Device
+			query := url.Values{"hours": {"2"}}
+			reports, err := simulate(dev, query, prices, first, first.AddDate(0, 0, 1), []schellydule.Strategy{tt.strategy}, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := reports[0]
+			if r.Days != 2 || r.Failed != 0 {
+				t.Fatalf("simulated %d days, %d failed, want 2 days: %v", r.Days, r.Failed, r.Errors)
+			}
+			p, err := reqPlanParams(url.Values{"hours": {"2"}, "strategy": {string(tt.strategy)}, "offset": {"0"}}, dev, false, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.daily = true
+			bt := schellydule.Simulate(prices, first, first.AddDate(0, 0, 1), dayPlanner(dev, p, prices))
+			for _, d := range bt.Days {
+				for _, e := range d.Schedule {
+					if e.Start.Before(d.Day.Add(tt.from)) || e.Stop.After(d.Day.Add(tt.to)) {
+						t.Errorf("%s: run %s to %s is outside the day's window", d.Day.Format(dateFormat), e.Start, e.Stop)
+					}
+				}
+			}
+		})
+	}
+}
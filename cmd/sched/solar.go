@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// solarRuns holds when the run on solar surplus of each device ends
+var solarRuns = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// useSurplus starts or extends runs of devices on the power exported, with
+// grid the power imported from the grid. A device is started when at least its rated
+// load is exported, and its run extended as long as nothing is imported while
+// it runs. Runs are extended when they end within interval.
+func useSurplus(ctx context.Context, grid float64, devices []config.Device, interval time.Duration) {
+	export := -grid
+	for _, dev := range devices {
+		if _, ok := activeOverride(dev); ok {
+			continue
+		}
+		if _, ok := shedSince(dev); ok {
+			continue
+		}
+		solarRuns.Lock()
+		until := solarRuns.m[dev.Name()]
+		solarRuns.Unlock()
+		now := time.Now()
+		if now.Before(until) {
+			// The load of a running device is already counted in the grid power
+			if grid > 0 || until.Sub(now) > interval {
+				continue
+			}
+			if err := runSolar(ctx, dev, until, dev.SlotLength()); err != nil && !errors.Is(err, errNoSolarRuntime) && !errors.Is(err, errSolarBlocked) {
+				log.Printf("device %s: error extending the run on solar surplus: %s", dev.Name(), err)
+			}
+			continue
+		}
+		if export < dev.RatedWatts() {
+			continue
+		}
+		length := dev.SlotLength()
+		if dev.MinRun() > length {
+			length = dev.MinRun()
+		}
+		switch err := runSolar(ctx, dev, now, length); {
+		case errors.Is(err, errNoSolarRuntime), errors.Is(err, errSolarBlocked):
+			// Nothing planned to move, it's already running, or it may not run now
+		case err != nil:
+			log.Printf("device %s: error starting a run on solar surplus: %s", dev.Name(), err)
+		default:
+			export -= dev.RatedWatts()
+		}
+	}
+}
+
+var (
+	// errNoSolarRuntime is returned by runSolar when there's no runtime left to move
+	errNoSolarRuntime = errors.New("no runtime left to move")
+	// errSolarBlocked is returned by runSolar when the device may not run now
+	errSolarBlocked = errors.New("blocked by quiet hours or the calendar")
+)
+
+// runSolar runs dev on solar surplus from `from`, which is now or when its
+// current run on solar surplus ends, for up to length. The runtime is taken
+// from the later runs of today's schedule, so the device runs no more than
+// planned in total. The run stops short of quiet hours and blocked periods.
+func runSolar(ctx context.Context, dev config.Device, from time.Time, length time.Duration) error {
+	ip := dev.IP()
+	loc := deviceZone(ctx, dev, ip)
+	now := time.Now().In(loc).Truncate(time.Second)
+	if from.Before(now) {
+		from = now
+	}
+	var c schellydule.Constraints
+	calendarConstraints(dev, from.In(loc), from.Add(length), &c)
+	quietConstraints(dev, &c)
+	if length = c.Unblocked(from.In(loc), length, dev.SlotLength()); length <= 0 {
+		return errSolarBlocked
+	}
+	schedules, err := shelly.GetSchedules(ctx, ip)
+	if err != nil {
+		return err
+	}
+	current, err := schellydule.ScheduleIn(schedules, loc)
+	if err != nil {
+		return err
+	}
+	// A device running its schedule is left alone
+	if length = schellydule.SolarLength(current, from.In(loc), length); length <= 0 {
+		return errNoSolarRuntime
+	}
+	b, today, err := addRun(ctx, dev, ip, now, from.Sub(now)+length, true)
+	if err != nil {
+		return err
+	}
+	solarRuns.Lock()
+	solarRuns.m[dev.Name()] = b.Until
+	solarRuns.Unlock()
+	if runtimes != nil {
+		runtimes.AddSolar(dev.Name(), now, length)
+		recordPlanned(dev, now, today)
+	}
+	log.Printf("device %s: running on solar surplus until %s", dev.Name(), b.Until.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// deviceStatus is the state of the connection to a Shelly
+type deviceStatus struct {
+	// Device is the name of the configured device, empty for Shellies only
+	// called with an IP in the request
+	Device string `json:"device,omitempty"`
+	shelly.BreakerStatus
+	// Reconcile is the result of the last check for drift
+	Reconcile *reconcileReport `json:"reconcile,omitempty"`
+	// Override is the active override of the schedule
+	Override *override `json:"override,omitempty"`
+	// ShedSince is when the device was switched off to stay below the power
+	// limit, if it's still off for that
+	ShedSince *time.Time `json:"shed_since,omitempty"`
+}
+
+// configureShellies sets the timeouts, retries and circuit breakers of the
+// calls to the configured devices
+func configureShellies(conf config.Config) {
+	for _, d := range conf.Devices() {
+		if d.IP() == nil {
+			continue
+		}
+		shelly.Configure(d.IP(), shelly.Options{
+			Timeout:          d.RPCTimeout(),
+			Retries:          d.RPCRetries(),
+			Backoff:          shelly.DefaultOptions.Backoff,
+			BreakerThreshold: d.BreakerThreshold(),
+			BreakerCooldown:  d.BreakerCooldown(),
+		})
+	}
+}
+
+// statusHandler returns the state of the circuit breaker of each Shelly, and
+// the last check for drift of the configured devices
+func statusHandler(w http.ResponseWriter, req *http.Request) {
+	conf := config.GetConf()
+	list := make([]deviceStatus, 0)
+	for _, b := range shelly.Breakers() {
+		s := deviceStatus{BreakerStatus: b}
+		if dev, ok := conf.DeviceByIP(net.ParseIP(b.Addr)); ok {
+			s.Device = dev.Name()
+			if r, ok := lastReconcile(dev); ok {
+				s.Reconcile = &r
+			}
+			if o, ok := activeOverride(dev); ok {
+				s.Override = &o
+			}
+			if since, ok := shedSince(dev); ok {
+				s.ShedSince = &since
+			}
+		}
+		list = append(list, s)
+	}
+	writeJSON(w, http.StatusOK, list)
+}
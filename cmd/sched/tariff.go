@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+
+	"github.com/adamhassel/power"
+	"github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule"
+	"github.com/adamhassel/schellydule/config"
+)
+
+// costReport is the cost of a schedule, broken down
+type costReport struct {
+	schellydule.PriceParts
+	Total float64 `json:"total"`
+}
+
+// tariff returns the tariffs, tax and VAT added to the spot price
+func tariff() schellydule.Tariff {
+	conf := config.GetConf()
+	t := schellydule.Tariff{Tax: conf.Tax(), VAT: conf.VAT()}
+	for _, p := range conf.Tariffs() {
+		t.Periods = append(t.Periods, schellydule.TariffPeriod{Months: p.Months, From: p.From, To: p.To, Price: p.Price})
+	}
+	return t
+}
+
+// scheduleCost returns the cost of running a load of watts according to s,
+// broken down, or nil if the spot prices can't be found
+func scheduleCost(s schedule.Schedule, watts float64) *costReport {
+	if len(s) == 0 {
+		return &costReport{}
+	}
+	from, to := s[0].Start, s[len(s)-1].Stop
+	prices, err := power.Prices(schedule.Hour(from, 0), to, config.GetConf(), true)
+	if err != nil {
+		log.Printf("error getting prices for the cost of the schedule: %s", err)
+		return nil
+	}
+	parts := tariff().Breakdown(s, schedule.FPToHourPrices(prices))
+	kw := watts / 1000
+	rv := &costReport{PriceParts: schellydule.PriceParts{
+		Spot:   parts.Spot * kw,
+		Tariff: parts.Tariff * kw,
+		Tax:    parts.Tax * kw,
+		VAT:    parts.VAT * kw,
+	}}
+	rv.Total = rv.PriceParts.Total()
+	return rv
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	// Time zones work on hosts without a zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/adamhassel/schellydule/config"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// deviceZones caches the time zones read from the devices
+var deviceZones = struct {
+	sync.Mutex
+	m map[string]*time.Location
+}{m: make(map[string]*time.Location)}
+
+// deviceZone returns the time zone of dev: the configured time zone, or the
+// one configured on the Shelly at ip. If neither is known, the time zone of
+// the server is used.
+func deviceZone(ctx context.Context, dev config.Device, ip fmt.Stringer) *time.Location {
+	if loc := dev.TimeZone(); loc != nil {
+		return loc
+	}
+	deviceZones.Lock()
+	loc, ok := deviceZones.m[dev.Name()]
+	deviceZones.Unlock()
+	if ok {
+		return loc
+	}
+	// The lock isn't held while calling the Shelly, so a Shelly that doesn't
+	// answer doesn't hold up the other devices. Concurrent calls may both ask.
+	loc, err := shelly.GetTimeZone(ctx, ip)
+	if err != nil {
+		log.Printf("device %s: error getting time zone, using %s: %s", dev.Name(), time.Local, err)
+		return time.Local
+	}
+	deviceZones.Lock()
+	deviceZones.m[dev.Name()] = loc
+	deviceZones.Unlock()
+	return loc
+}
@@ -0,0 +1,223 @@
+package schellydule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// EnergiDataServiceURL is the Energi Data Service dataset with the forecast of
+// the CO2 emission of the electricity consumed in Denmark
+const EnergiDataServiceURL = "https://api.energidataservice.dk/dataset/CO2EmisProg"
+
+// Intensity is the CO2 emission of the electricity used in an hour
+type Intensity struct {
+	Hour time.Time
+	// CO2 is in grams per kWh
+	CO2 float64
+}
+
+// Intensities is a list of CO2 intensities, sorted by hour. Each covers the
+// shortest time between two of them, and at most an hour, so data every 15
+// minutes covers 15 minutes each.
+type Intensities []Intensity
+
+// interval returns the time each intensity of in covers
+func (in Intensities) interval() time.Duration {
+	rv := time.Hour
+	for i := 1; i < len(in); i++ {
+		if d := in[i].Hour.Sub(in[i-1].Hour); d > 0 && d < rv {
+			rv = d
+		}
+	}
+	return rv
+}
+
+// At returns the CO2 intensity of the interval including t
+func (in Intensities) At(t time.Time) (float64, bool) {
+	i := sort.Search(len(in), func(i int) bool { return in[i].Hour.After(t) })
+	if i == 0 || !in[i-1].Hour.Add(in.interval()).After(t) {
+		return 0, false
+	}
+	return in[i-1].CO2, true
+}
+
+// CO2Source is where the hourly CO2 intensity comes from
+type CO2Source interface {
+	// Intensity returns the CO2 intensity of the hours from `from` until `to`
+	Intensity(ctx context.Context, from, to time.Time) (Intensities, error)
+}
+
+// EnergiDataService reads the CO2 intensity from Energi Data Service. The
+// dataset has 5 minute intervals, which are averaged by the hour.
+type EnergiDataService struct {
+	// Area is the price area, DK1 or DK2
+	Area string
+	// URL is the dataset. Empty means EnergiDataServiceURL
+	URL string
+}
+
+// edsResponse is the part of an Energi Data Service response used
+type edsResponse struct {
+	Records []struct {
+		Minutes5UTC string  `json:"Minutes5UTC"`
+		CO2Emission float64 `json:"CO2Emission"`
+	} `json:"records"`
+}
+
+// Intensity implements CO2Source
+func (e EnergiDataService) Intensity(ctx context.Context, from, to time.Time) (Intensities, error) {
+	src := e.URL
+	if src == "" {
+		src = EnergiDataServiceURL
+	}
+	const format = "2006-01-02T15:04"
+	filter, err := json.Marshal(map[string][]string{"PriceArea": {e.Area}})
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	q.Set("start", from.UTC().Format(format))
+	q.Set("end", to.UTC().Format(format))
+	q.Set("timezone", "UTC")
+	q.Set("filter", string(filter))
+	q.Set("limit", "0")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", src, resp.Status)
+	}
+	var r edsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	sums := make(map[int64]float64)
+	counts := make(map[int64]int)
+	for _, rec := range r.Records {
+		t, err := time.Parse("2006-01-02T15:04:05", rec.Minutes5UTC)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid time %q", src, rec.Minutes5UTC)
+		}
+		hour := t.Truncate(time.Hour).Unix()
+		sums[hour] += rec.CO2Emission
+		counts[hour]++
+	}
+	rv := make(Intensities, 0, len(sums))
+	for hour, sum := range sums {
+		rv = append(rv, Intensity{Hour: time.Unix(hour, 0).In(from.Location()), CO2: sum / float64(counts[hour])})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Hour.Before(rv[j].Hour) })
+	return rv, nil
+}
+
+// CO2File reads the CO2 intensity from a CSV file, in the format of
+// ReadPricesCSV with grams per kWh instead of prices
+type CO2File struct {
+	Path string
+	// Loc is the time zone of times without one
+	Loc *time.Location
+}
+
+// Intensity implements CO2Source
+func (f CO2File) Intensity(ctx context.Context, from, to time.Time) (Intensities, error) {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	loc := f.Loc
+	if loc == nil {
+		loc = time.Local
+	}
+	hp, err := ReadPricesCSV(r, loc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	var rv Intensities
+	for _, h := range hp {
+		if !h.Hour.Before(from) && h.Hour.Before(to) {
+			rv = append(rv, Intensity{Hour: h.Hour, CO2: h.Price})
+		}
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Hour.Before(rv[j].Hour) })
+	return rv, nil
+}
+
+// WithCO2 returns a copy of s with the CO2 intensity of each slot from in.
+// Slots without a known intensity keep theirs.
+func (s Slots) WithCO2(in Intensities) Slots {
+	rv := make(Slots, len(s))
+	for i, e := range s {
+		if co2, ok := in.At(e.Start); ok {
+			e.CO2 = co2
+		}
+		rv[i] = e
+	}
+	return rv
+}
+
+// Weighted returns a copy of s priced by priceWeight times the price, plus
+// co2Weight per kg of CO2 emitted. Weighted(0, 1) prices the slots by their
+// emissions alone.
+func (s Slots) Weighted(priceWeight, co2Weight float64) Slots {
+	rv := make(Slots, len(s))
+	for i, e := range s {
+		e.Price = priceWeight*e.Price + co2Weight*e.CO2/1000
+		rv[i] = e
+	}
+	return rv
+}
+
+// Reprice returns p with the costs from the prices of s, for a plan made from
+// slots priced by something else, like Weighted. Premium isn't known, and is
+// zero.
+func (s Slots) Reprice(p Plan) Plan {
+	rv := Plan{Schedule: make(sch.Schedule, len(p.Schedule))}
+	for i, e := range p.Schedule {
+		e.Cost = 0
+		for _, slot := range s {
+			if !slot.Start.Before(e.Start) && !slot.End().After(e.Stop) {
+				e.Cost += slot.Cost()
+			}
+		}
+		rv.Schedule[i] = e
+		rv.Cost += e.Cost
+	}
+	return rv
+}
+
+// Emissions returns the grams of CO2 emitted by running a 1 kW load according
+// to s. Time without a known intensity isn't counted.
+func Emissions(s sch.Schedule, in Intensities) float64 {
+	var rv float64
+	l := in.interval()
+	for _, e := range s {
+		for _, h := range in {
+			start, stop := h.Hour, h.Hour.Add(l)
+			if e.Start.After(start) {
+				start = e.Start
+			}
+			if e.Stop.Before(stop) {
+				stop = e.Stop
+			}
+			if stop.After(start) {
+				rv += h.CO2 * stop.Sub(start).Hours()
+			}
+		}
+	}
+	return rv
+}
@@ -0,0 +1,164 @@
+package schellydule
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+func TestEnergiDataService_Intensity(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if q.Get("filter") != `{"PriceArea":["DK1"]}` || q.Get("start") != "2022-07-01T00:00" || q.Get("end") != "2022-07-01T03:00" {
+			http.Error(w, "unexpected query "+req.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		http.ServeFile(w, req, "testdata/co2emisprog.json")
+	}))
+	defer srv.Close()
+
+	got, err := EnergiDataService{Area: "DK1", URL: srv.URL}.Intensity(context.Background(), day, day.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Intensities{{Hour: day, CO2: 160}, {Hour: day.Add(time.Hour), CO2: 280}, {Hour: day.Add(2 * time.Hour), CO2: 100}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Intensity() = %v, want %v", got, want)
+	}
+
+	if _, err := (EnergiDataService{Area: "DK1", URL: srv.URL + "/missing"}).Intensity(context.Background(), day, day.Add(time.Hour)); err == nil {
+		t.Error("Intensity() with a bad query didn't fail")
+	}
+}
+
+func TestCO2File_Intensity(t *testing.T) {
+	got, err := CO2File{Path: "testdata/co2.csv", Loc: time.UTC}.Intensity(context.Background(), day.Add(time.Hour), day.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Intensities{{Hour: day.Add(time.Hour), CO2: 280}, {Hour: day.Add(2 * time.Hour), CO2: 100}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Intensity() = %v, want %v", got, want)
+	}
+	if _, err := (CO2File{Path: "testdata/missing.csv"}).Intensity(context.Background(), day, day.Add(time.Hour)); err == nil {
+		t.Error("Intensity() of a missing file didn't fail")
+	}
+}
+
+func TestIntensities_At(t *testing.T) {
+	in := Intensities{{Hour: day, CO2: 160}, {Hour: day.Add(time.Hour), CO2: 280}, {Hour: day.Add(3 * time.Hour), CO2: 100}}
+	tests := []struct {
+		name   string
+		at     time.Time
+		want   float64
+		wantOK bool
+	}{
+		{name: "start of hour", at: day.Add(time.Hour), want: 280, wantOK: true},
+		{name: "within hour", at: day.Add(90 * time.Minute), want: 280, wantOK: true},
+		{name: "gap", at: day.Add(2 * time.Hour), wantOK: false},
+		{name: "before", at: day.Add(-time.Minute), wantOK: false},
+		{name: "after", at: day.Add(4 * time.Hour), wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := in.At(tt.at)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("At() = %g, %t, want %g, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestIntensities_At_quarterHours(t *testing.T) {
+	in := Intensities{{Hour: day, CO2: 160}, {Hour: day.Add(15 * time.Minute), CO2: 280}, {Hour: day.Add(time.Hour), CO2: 100}}
+	tests := []struct {
+		name   string
+		at     time.Time
+		want   float64
+		wantOK bool
+	}{
+		{name: "first", at: day.Add(10 * time.Minute), want: 160, wantOK: true},
+		{name: "second", at: day.Add(20 * time.Minute), want: 280, wantOK: true},
+		{name: "gap", at: day.Add(30 * time.Minute), wantOK: false},
+		{name: "last", at: day.Add(70 * time.Minute), want: 100, wantOK: true},
+		{name: "after", at: day.Add(75 * time.Minute), wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := in.At(tt.at)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("At() = %g, %t, want %g, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSlots_Weighted(t *testing.T) {
+	in := Intensities{
+		{Hour: day, CO2: 300},
+		{Hour: day.Add(time.Hour), CO2: 100},
+		{Hour: day.Add(2 * time.Hour), CO2: 200},
+		{Hour: day.Add(3 * time.Hour), CO2: 50},
+	}
+	slots := hourSlots(1, 2, 3, 4).WithCO2(in)
+	tests := []struct {
+		name                   string
+		priceWeight, co2Weight float64
+		wantHours              []int
+		wantCost               float64
+	}{
+		{name: "greenest", priceWeight: 0, co2Weight: 1, wantHours: []int{1, 3}, wantCost: 6},
+		{name: "cheapest", priceWeight: 1, co2Weight: 0, wantHours: []int{0, 1}, wantCost: 3},
+		// 4, 3, 5 and 4.5
+		{name: "weighted", priceWeight: 1, co2Weight: 10, wantHours: []int{0, 1}, wantCost: 3},
+		// 7, 3, 7 and 4.5
+		{name: "heavily weighted", priceWeight: 1, co2Weight: 20, wantHours: []int{1, 3}, wantCost: 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := slots.Weighted(tt.priceWeight, tt.co2Weight).Cheapest(2, Constraints{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			plan = slots.Reprice(plan)
+			var hours []int
+			for _, e := range plan.Schedule {
+				for h := e.Start; h.Before(e.Stop); h = h.Add(time.Hour) {
+					hours = append(hours, int(h.Sub(day).Hours()))
+				}
+			}
+			if !reflect.DeepEqual(hours, tt.wantHours) {
+				t.Errorf("hours = %v, want %v", hours, tt.wantHours)
+			}
+			if math.Abs(plan.Cost-tt.wantCost) > 1e-9 {
+				t.Errorf("Cost = %g, want %g", plan.Cost, tt.wantCost)
+			}
+		})
+	}
+}
+
+func TestEmissions(t *testing.T) {
+	in := Intensities{{Hour: day, CO2: 160}, {Hour: day.Add(time.Hour), CO2: 280}, {Hour: day.Add(2 * time.Hour), CO2: 100}}
+	s := sch.Schedule{entry(0, 1, 0), {Start: day.Add(90 * time.Minute), Stop: day.Add(4 * time.Hour)}}
+	// The last hour has no intensity
+	want := 160 + 280*0.5 + 100.0
+	if got := Emissions(s, in); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Emissions() = %g, want %g", got, want)
+	}
+}
+
+func TestEmissions_quarterHours(t *testing.T) {
+	var in Intensities
+	for i := 0; i < 8; i++ {
+		in = append(in, Intensity{Hour: day.Add(time.Duration(i) * 15 * time.Minute), CO2: 100})
+	}
+	if got := Emissions(sch.Schedule{entry(0, 1, 0)}, in); math.Abs(got-100) > 1e-9 {
+		t.Errorf("Emissions() = %g, want 100", got)
+	}
+}
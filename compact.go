@@ -0,0 +1,117 @@
+package schellydule
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// MaxBlocks returns the number of schedule entries that fit on a device with
+// room for maxJobs schedule jobs. Each entry takes two jobs (on and off), and
+// one job is reserved for the schedule refresher.
+func MaxBlocks(maxJobs int) int {
+	if maxJobs < 1 {
+		return 0
+	}
+	return (maxJobs - 1) / 2
+}
+
+// Compact merges overlapping and adjacent entries in s, returning a new
+// schedule sorted by start time.
+func Compact(s sch.Schedule) sch.Schedule {
+	sorted := make(sch.Schedule, len(s))
+	copy(sorted, s)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	rv := make(sch.Schedule, 0, len(sorted))
+	for _, e := range sorted {
+		if l := len(rv) - 1; l >= 0 && !e.Start.After(rv[l].Stop) {
+			if e.Stop.After(rv[l].Stop) {
+				rv[l].Stop = e.Stop
+			}
+			rv[l].Cost += e.Cost
+			continue
+		}
+		rv = append(rv, e)
+	}
+	return rv
+}
+
+// CompactTo compacts s, and joins the entries with the shortest gaps between
+// them until there are at most maxBlocks. The device then also runs in those
+// gaps, so none of the runtime of s is lost. Their cost isn't known, so it
+// isn't added.
+func CompactTo(s sch.Schedule, maxBlocks int) sch.Schedule {
+	rv := Compact(s)
+	for maxBlocks > 0 && len(rv) > maxBlocks {
+		shortest := 1
+		for i := 2; i < len(rv); i++ {
+			if rv[i].Start.Sub(rv[i-1].Stop) < rv[shortest].Start.Sub(rv[shortest-1].Stop) {
+				shortest = i
+			}
+		}
+		rv[shortest-1].Stop = rv[shortest].Stop
+		rv[shortest-1].Cost += rv[shortest].Cost
+		rv = append(rv[:shortest], rv[shortest+1:]...)
+	}
+	return rv
+}
+
+// Fit returns a plan of the n cheapest slots in s satisfying c, with at most
+// maxBlocks entries. If the cheapest plan has too many entries, the slots are
+// re-selected with a limit on the number of runs, which adds the smallest
+// possible extra cost. If c forces more than n slots, they're all selected.
+func (s Slots) Fit(n int, c Constraints, maxBlocks int) (Plan, error) {
+	if maxBlocks < 1 {
+		return Plan{}, fmt.Errorf("%w: device has no room for schedules", ErrInfeasible)
+	}
+	if f := s.Forced(c); f > n {
+		n = f
+	}
+	plan, err := s.Cheapest(n, c)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan.Schedule = Compact(plan.Schedule)
+	if len(plan.Schedule) <= maxBlocks {
+		return plan, nil
+	}
+	c.MaxStarts = maxBlocks
+	plan, err = s.Cheapest(n, c)
+	if err != nil {
+		return Plan{}, fmt.Errorf("fitting schedule in %d blocks: %w", maxBlocks, err)
+	}
+	plan.Schedule = Compact(plan.Schedule)
+	return plan, nil
+}
+
+// SplitDays splits entries that cross midnight into an entry for each day
+func SplitDays(s sch.Schedule) sch.Schedule {
+	rv := make(sch.Schedule, 0, len(s))
+	for _, e := range s {
+		for {
+			y, m, d := e.Start.Date()
+			midnight := time.Date(y, m, d+1, 0, 0, 0, 0, e.Start.Location())
+			if !e.Stop.After(midnight) {
+				break
+			}
+			// Split the cost in proportion to the time on each side of midnight
+			share := float64(midnight.Sub(e.Start)) / float64(e.Stop.Sub(e.Start))
+			rv = append(rv, sch.Entry{Start: e.Start, Stop: midnight, Cost: e.Cost * share})
+			e.Start, e.Cost = midnight, e.Cost*(1-share)
+		}
+		rv = append(rv, e)
+	}
+	return rv
+}
+
+// In returns a copy of s with the times in loc
+func In(s sch.Schedule, loc *time.Location) sch.Schedule {
+	rv := make(sch.Schedule, len(s))
+	for i, e := range s {
+		e.Start, e.Stop = e.Start.In(loc), e.Stop.In(loc)
+		rv[i] = e
+	}
+	return rv
+}
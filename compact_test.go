@@ -0,0 +1,174 @@
+package schellydule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+func entry(start, stop int, cost float64) sch.Entry {
+	return sch.Entry{
+		Start: day.Add(time.Duration(start) * time.Hour),
+		Stop:  day.Add(time.Duration(stop) * time.Hour),
+		Cost:  cost,
+	}
+}
+
+func TestMaxBlocks(t *testing.T) {
+	tests := []struct {
+		jobs int
+		want int
+	}{
+		{jobs: 20, want: 9},
+		{jobs: 21, want: 10},
+		{jobs: 3, want: 1},
+		{jobs: 2, want: 0},
+		{jobs: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := MaxBlocks(tt.jobs); got != tt.want {
+			t.Errorf("MaxBlocks(%d) = %d, want %d", tt.jobs, got, tt.want)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		name string
+		in   sch.Schedule
+		want sch.Schedule
+	}{
+		{
+			name: "empty",
+			in:   sch.Schedule{},
+			want: sch.Schedule{},
+		},
+		{
+			name: "adjacent entries are merged",
+			in:   sch.Schedule{entry(1, 2, 1), entry(2, 3, 2), entry(5, 6, 3)},
+			want: sch.Schedule{entry(1, 3, 3), entry(5, 6, 3)},
+		},
+		{
+			name: "unsorted and overlapping",
+			in:   sch.Schedule{entry(5, 6, 3), entry(2, 4, 2), entry(1, 3, 1)},
+			want: sch.Schedule{entry(1, 4, 3), entry(5, 6, 3)},
+		},
+		{
+			name: "contained entry",
+			in:   sch.Schedule{entry(1, 5, 4), entry(2, 3, 1)},
+			want: sch.Schedule{entry(1, 5, 5)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Compact(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Compact() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        sch.Schedule
+		maxBlocks int
+		want      sch.Schedule
+	}{
+		{
+			name:      "fits",
+			in:        sch.Schedule{entry(1, 2, 1), entry(2, 3, 2), entry(5, 6, 3)},
+			maxBlocks: 2,
+			want:      sch.Schedule{entry(1, 3, 3), entry(5, 6, 3)},
+		},
+		{
+			name:      "shortest gap is joined",
+			in:        sch.Schedule{entry(10, 11, 4), entry(1, 2, 1), entry(3, 4, 2), entry(7, 8, 3)},
+			maxBlocks: 3,
+			want:      sch.Schedule{entry(1, 4, 3), entry(7, 8, 3), entry(10, 11, 4)},
+		},
+		{
+			name:      "one block",
+			in:        sch.Schedule{entry(1, 2, 1), entry(3, 4, 2), entry(7, 8, 3)},
+			maxBlocks: 1,
+			want:      sch.Schedule{entry(1, 8, 6)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompactTo(tt.in, tt.maxBlocks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CompactTo() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlots_Fit(t *testing.T) {
+	tests := []struct {
+		name        string
+		s           Slots
+		n           int
+		maxBlocks   int
+		wantBlocks  int
+		wantCost    float64
+		wantPremium float64
+		wantErr     bool
+	}{
+		{
+			name:       "fits without changes",
+			s:          hourSlots(1, 9, 1, 9, 1, 9),
+			n:          3,
+			maxBlocks:  3,
+			wantBlocks: 3,
+			wantCost:   3,
+		},
+		{
+			name:        "re-optimised to fewer blocks",
+			s:           hourSlots(1, 9, 1, 9, 1, 2),
+			n:           3,
+			maxBlocks:   2,
+			wantBlocks:  2,
+			wantCost:    4,
+			wantPremium: 1,
+		},
+		{
+			name:        "one block",
+			s:           hourSlots(1, 9, 1, 9, 1, 2),
+			n:           3,
+			maxBlocks:   1,
+			wantBlocks:  1,
+			wantCost:    11,
+			wantPremium: 8,
+		},
+		{
+			name:      "no room",
+			s:         hourSlots(1, 9, 1),
+			n:         1,
+			maxBlocks: 0,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.s.Fit(tt.n, Constraints{}, tt.maxBlocks)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Fit() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got.Schedule) != tt.wantBlocks {
+				t.Errorf("Fit() got %d blocks, want %d", len(got.Schedule), tt.wantBlocks)
+			}
+			if got.Cost != tt.wantCost {
+				t.Errorf("Fit() cost = %f, want %f", got.Cost, tt.wantCost)
+			}
+			if got.Premium != tt.wantPremium {
+				t.Errorf("Fit() premium = %f, want %f", got.Premium, tt.wantPremium)
+			}
+		})
+	}
+}
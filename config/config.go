@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -12,22 +15,206 @@ import (
 // An MID MUST be 18 digits
 const midLength = 18
 
+// Reconcile policies, for when the schedule on a device has drifted from the
+// one installed
+const (
+	// ReconcileOff doesn't check the device
+	ReconcileOff = "off"
+	// ReconcileReport logs the drift, and shows it in the status
+	ReconcileReport = "report"
+	// ReconcileRepair reinstalls the schedule and sets the switch
+	ReconcileRepair = "repair"
+)
+
+// Clock check policies, for when the clock or time zone of a device is wrong
+const (
+	// ClockOff doesn't check the clock
+	ClockOff = "off"
+	// ClockWarn logs the problem, and goes ahead
+	ClockWarn = "warn"
+	// ClockRefuse doesn't install schedules on the device
+	ClockRefuse = "refuse"
+)
+
+// Quiet hours policies, for when the runtime doesn't fit outside the quiet hours
+const (
+	// QuietError fails to plan the schedule
+	QuietError = "error"
+	// QuietReduce runs as much as fits outside the quiet hours
+	QuietReduce = "reduce"
+)
+
+// CO2EnergiDataService is the co2_source reading the CO2 intensity from Energi
+// Data Service. Any other source is a CSV file
+const CO2EnergiDataService = "energidataservice"
+
+// DefaultDevice is the name of the device configured by the top-level settings
+const DefaultDevice = "default"
+
+type deviceconf struct {
+	DarkHours int     `toml:"darkhours"`
+	Hours     int     `toml:"hours"`
+	ShellyIP  string  `toml:"shelly_ip"`
+	MinRun    int     `toml:"min_run"`
+	MinOff    int     `toml:"min_off"`
+	MaxStarts int     `toml:"max_starts"`
+	MaxJobs   int     `toml:"max_jobs"`
+	Strategy  string  `toml:"strategy"`
+	MaxPrice  float64 `toml:"max_price"`
+	MinHours  int     `toml:"min_hours"`
+	MaxHours  int     `toml:"max_hours"`
+	Earliest  string  `toml:"earliest_start"`
+	Deadline  string  `toml:"deadline"`
+	MaxGap    int     `toml:"max_gap"`
+	CarryCap  int     `toml:"carry_cap"`
+	Decay     float64 `toml:"carry_decay"`
+	Latitude  float64 `toml:"latitude"`
+	Longitude float64 `toml:"longitude"`
+	DarkStart string  `toml:"dark_start"`
+	DarkEnd   string  `toml:"dark_end"`
+	TimeZone  string  `toml:"timezone"`
+	SlotLen   int     `toml:"slot_length"`
+	Runtime   int     `toml:"runtime"`
+	Timeout   int     `toml:"rpc_timeout"`
+	Retries   int     `toml:"rpc_retries"`
+	Threshold int     `toml:"breaker_threshold"`
+	Cooldown  int     `toml:"breaker_cooldown"`
+	Reconcile string  `toml:"reconcile"`
+	Interval  int     `toml:"reconcile_interval"`
+	Clock     string  `toml:"clock_check"`
+	MaxSkew   int     `toml:"max_skew"`
+	SyncClock *bool   `toml:"sync_clock"`
+	SNTP      string  `toml:"sntp_server"`
+
+	// Windows are the periods of the day the "fixed" strategy runs in, and
+	// Profiles the [profile.<name>] sections
+	Windows  []string               `toml:"windows"`
+	Profiles map[string]profileconf `toml:"profile"`
+
+	// QuietHours are the periods of the day the device must never run in, on
+	// weekdays and, unless QuietWeekend is set, weekends
+	QuietHours   []string `toml:"quiet_hours"`
+	QuietWeekend []string `toml:"quiet_hours_weekend"`
+	QuietPolicy  string   `toml:"quiet_hours_policy"`
+
+	// CO2Weight is the price per kg of CO2 used by the "weighted" strategy
+	CO2Weight float64 `toml:"co2_weight"`
+
+	// RatedWatts is the load of the appliance. With Solar, it runs when that
+	// much is exported. Priority is how important it is to keep it on when the
+	// household is over the power limit
+	RatedWatts int  `toml:"rated_watts"`
+	Solar      bool `toml:"solar"`
+	Priority   int  `toml:"priority"`
+}
+
+// tariffconf is a [[tariff]] section
+type tariffconf struct {
+	Months []int   `toml:"months"`
+	From   string  `toml:"from"`
+	To     string  `toml:"to"`
+	Price  float64 `toml:"price"`
+}
+
+// TariffPeriod is a grid tariff per kWh, in a period of the day in some months
+type TariffPeriod struct {
+	// Months are the months the tariff applies in. Empty means all year
+	Months []time.Month
+	Window
+	Price float64
+}
+
 type confdata struct {
-	Token     string `toml:"token"`
-	MID       string `toml:"mid"`
-	DarkHours int    `toml:"darkhours"`
-	Hours     int    `toml:"hours"`
-	Port      int    `toml:"port"`
-	ShellyIP  string `toml:"shelly_ip"`
+	Token     string   `toml:"token"`
+	MID       string   `toml:"mid"`
+	Port      int      `toml:"port"`
+	DataDir   string   `toml:"data_dir"`
+	Calendars []string `toml:"calendars"`
+	// Tariffs, Tax and VAT are added to the spot price
+	Tariffs []tariffconf `toml:"tariff"`
+	Tax     float64      `toml:"tax"`
+	VAT     float64      `toml:"vat"`
+	// CO2Source and CO2Area are where the CO2 intensity comes from
+	CO2Source string `toml:"co2_source"`
+	CO2Area   string `toml:"co2_area"`
+	// Meter is the Shelly energy meter or JSON endpoint reading the grid power
+	Meter         string `toml:"meter"`
+	MeterField    string `toml:"meter_field"`
+	MeterExport   bool   `toml:"meter_export_positive"`
+	MeterInterval int    `toml:"meter_interval"`
+	// PowerLimit is the most power the household may import in W
+	PowerLimit int `toml:"power_limit"`
+	deviceconf
+	Devices map[string]deviceconf `toml:"device"`
 }
 
 type Config struct {
 	token     string
 	mid       string
+	port      int
+	dataDir   string
+	calendars []string
+	tariffs   []TariffPeriod
+	tax       float64
+	vat       float64
+	co2Source string
+	co2Area   string
+	meter     string
+	meterIP   net.IP
+	meterFld  string
+	meterExp  bool
+	meterInt  time.Duration
+	limit     float64
+	// Device is the default device, configured by the top-level settings
+	Device
+	devices map[string]Device
+}
+
+// Device is the configuration of a single Shelly and the appliance connected to it
+type Device struct {
+	name      string
 	darkHours int
 	hours     int
-	port      int
 	shellyIP  net.IP
+	minRun    time.Duration
+	minOff    time.Duration
+	maxStarts int
+	maxJobs   int
+	strategy  string
+	maxPrice  float64
+	minHours  int
+	maxHours  int
+	earliest  time.Duration
+	deadline  time.Duration
+	maxGap    time.Duration
+	carryCap  time.Duration
+	decay     float64
+	lat, lon  float64
+	darkStart time.Duration
+	darkEnd   time.Duration
+	darkSet   bool
+	loc       *time.Location
+	slotLen   time.Duration
+	runtime   time.Duration
+	timeout   time.Duration
+	retries   int
+	threshold int
+	cooldown  time.Duration
+	reconcile string
+	interval  time.Duration
+	clock     string
+	maxSkew   time.Duration
+	syncClock bool
+	sntp      string
+	windows   []Window
+	profiles  map[string]profile
+	quiet     []Window
+	quietWE   []Window
+	quietPol  string
+	co2Weight float64
+	watts     float64
+	solar     bool
+	priority  int
 }
 
 var conf Config
@@ -53,20 +240,353 @@ func (c Config) MID() string {
 	return c.mid
 }
 
-func (c Config) IP() net.IP {
-	return c.shellyIP
-}
-
 func (c Config) Port() int {
 	return c.port
 }
 
-func (c Config) Hours() int {
-	return c.hours
+// DataDir returns the directory where state is kept between restarts
+func (c Config) DataDir() string {
+	return c.dataDir
+}
+
+// Calendars returns the calendar files and URLs with events blocking or
+// forcing the devices to run
+func (c Config) Calendars() []string {
+	return c.calendars
+}
+
+// Tariffs returns the grid tariffs per kWh. The first one including an hour
+// applies
+func (c Config) Tariffs() []TariffPeriod {
+	return c.tariffs
+}
+
+// Tax returns the taxes and fees per kWh
+func (c Config) Tax() float64 {
+	return c.tax
+}
+
+// VAT returns the rate of VAT on the spot price, tariffs and tax, like 0.25
+func (c Config) VAT() float64 {
+	return c.vat
+}
+
+// CO2Source returns where the CO2 intensity comes from: CO2EnergiDataService,
+// a CSV file, or nothing if empty
+func (c Config) CO2Source() string {
+	return c.co2Source
+}
+
+// CO2Area returns the price area, like DK1, of the CO2 intensity from Energi
+// Data Service
+func (c Config) CO2Area() string {
+	return c.co2Area
+}
+
+// Meter returns the URL of the JSON endpoint with the grid power, or the IP of
+// the Shelly energy meter measuring it. Both are empty if there's no meter.
+func (c Config) Meter() (string, net.IP) {
+	return c.meter, c.meterIP
+}
+
+// MeterField returns the path to the grid power in W in the JSON document of
+// the meter URL
+func (c Config) MeterField() string {
+	return c.meterFld
+}
+
+// MeterExportPositive returns true if the meter URL has the grid power
+// positive when exporting
+func (c Config) MeterExportPositive() bool {
+	return c.meterExp
+}
+
+// MeterInterval returns how often the meter is read
+func (c Config) MeterInterval() time.Duration {
+	return c.meterInt
+}
+
+// PowerLimit returns the most power the household may import in W, with
+// devices switched off to stay below it. Zero means no limit.
+func (c Config) PowerLimit() float64 {
+	return c.limit
 }
 
-func (c Config) DarkHours() int {
-	return c.darkHours
+// GetDevice returns the device called name. The default device is returned for
+// an empty name or DefaultDevice
+func (c Config) GetDevice(name string) (Device, bool) {
+	if name == "" || name == DefaultDevice {
+		return c.Device, true
+	}
+	d, ok := c.devices[name]
+	return d, ok
+}
+
+// DeviceByIP returns the device configured with ip
+func (c Config) DeviceByIP(ip net.IP) (Device, bool) {
+	for _, d := range c.Devices() {
+		if d.shellyIP != nil && d.shellyIP.Equal(ip) {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// Devices returns all configured devices, starting with the default device
+func (c Config) Devices() []Device {
+	rv := make([]Device, 0, len(c.devices)+1)
+	rv = append(rv, c.Device)
+	names := make([]string, 0, len(c.devices))
+	for n := range c.devices {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		rv = append(rv, c.devices[n])
+	}
+	return rv
+}
+
+// Name returns the name of the device
+func (d Device) Name() string {
+	return d.name
+}
+
+func (d Device) IP() net.IP {
+	return d.shellyIP
+}
+
+func (d Device) Hours() int {
+	return d.hours
+}
+
+// Runtime returns the total runtime of the schedule. It's the configured
+// runtime, or else the configured hours
+func (d Device) Runtime() time.Duration {
+	if d.runtime > 0 {
+		return d.runtime
+	}
+	return time.Duration(d.hours) * time.Hour
+}
+
+// SlotLength returns the length of the slots the schedule is planned in
+func (d Device) SlotLength() time.Duration {
+	return d.slotLen
+}
+
+func (d Device) DarkHours() int {
+	return d.darkHours
+}
+
+// MinRun returns the minimum length of a continuous run. Zero means no minimum
+func (d Device) MinRun() time.Duration {
+	return d.minRun
+}
+
+// MinOff returns the minimum time off between two runs. Zero means no minimum
+func (d Device) MinOff() time.Duration {
+	return d.minOff
+}
+
+// MaxStarts returns the maximum number of times per day the appliance may be
+// switched on. Zero means no limit
+func (d Device) MaxStarts() int {
+	return d.maxStarts
+}
+
+// MaxJobs returns the number of schedule jobs the device can hold
+func (d Device) MaxJobs() int {
+	return d.maxJobs
+}
+
+// Strategy returns the name of the strategy used to select hours
+func (d Device) Strategy() string {
+	return d.strategy
+}
+
+// MaxPrice returns the price limit for the threshold strategy
+func (d Device) MaxPrice() float64 {
+	return d.maxPrice
+}
+
+// MinHours returns the minimum number of hours the threshold strategy selects
+func (d Device) MinHours() int {
+	return d.minHours
+}
+
+// MaxHours returns the maximum number of hours the threshold strategy selects.
+// Zero means no limit
+func (d Device) MaxHours() int {
+	return d.maxHours
+}
+
+// EarliestStart returns the time of day the deadline strategy may start running
+func (d Device) EarliestStart() time.Duration {
+	return d.earliest
+}
+
+// Deadline returns the time of day the deadline strategy must be done running by
+func (d Device) Deadline() time.Duration {
+	return d.deadline
+}
+
+// MaxGap returns the maximum time between runs for the spread strategy
+func (d Device) MaxGap() time.Duration {
+	return d.maxGap
+}
+
+// CarryCap returns the maximum runtime carried over from one day to the next.
+// Zero means runtime isn't carried over
+func (d Device) CarryCap() time.Duration {
+	return d.carryCap
+}
+
+// CarryDecay returns the share of the shortfall carried over to the next day
+func (d Device) CarryDecay() float64 {
+	return d.decay
+}
+
+// Location returns the latitude and longitude of the device, and whether it's set
+func (d Device) Location() (float64, float64, bool) {
+	return d.lat, d.lon, d.lat != 0 || d.lon != 0
+}
+
+// DarkWindow returns the fixed times of day it's considered dark, and whether
+// they're set
+func (d Device) DarkWindow() (time.Duration, time.Duration, bool) {
+	return d.darkStart, d.darkEnd, d.darkSet
+}
+
+// TimeZone returns the configured time zone of the device, or nil if it isn't
+// set, in which case the time zone configured on the Shelly should be used
+func (d Device) TimeZone() *time.Location {
+	return d.loc
+}
+
+// RPCTimeout returns the time allowed for a single call to the Shelly
+func (d Device) RPCTimeout() time.Duration {
+	return d.timeout
+}
+
+// RPCRetries returns how many times a call to the Shelly is retried if it
+// can't be reached
+func (d Device) RPCRetries() int {
+	return d.retries
+}
+
+// BreakerThreshold returns the number of failed calls to the Shelly in a row,
+// after which it isn't called until BreakerCooldown has passed. Zero means
+// calls are always made
+func (d Device) BreakerThreshold() int {
+	return d.threshold
+}
+
+// BreakerCooldown returns how long the Shelly isn't called after
+// BreakerThreshold failed calls
+func (d Device) BreakerCooldown() time.Duration {
+	return d.cooldown
+}
+
+// Reconcile returns what to do when the schedule on the device has drifted
+// from the one installed: ReconcileOff, ReconcileReport or ReconcileRepair
+func (d Device) Reconcile() string {
+	return d.reconcile
+}
+
+// ReconcileInterval returns how often the device is checked for drift
+func (d Device) ReconcileInterval() time.Duration {
+	return d.interval
+}
+
+// ClockCheck returns what to do when the clock or time zone of the device is
+// wrong: ClockOff, ClockWarn or ClockRefuse
+func (d Device) ClockCheck() string {
+	return d.clock
+}
+
+// MaxSkew returns how far the clock of the device may be off
+func (d Device) MaxSkew() time.Duration {
+	return d.maxSkew
+}
+
+// SyncClock returns true if the time zone and time server should be set on the
+// device when its clock or time zone is wrong
+func (d Device) SyncClock() bool {
+	return d.syncClock
+}
+
+// SNTPServer returns the time server set on the device by SyncClock. Empty
+// means the device's own setting is kept
+func (d Device) SNTPServer() string {
+	return d.sntp
+}
+
+// Windows returns the periods of the day the "fixed" strategy runs in
+func (d Device) Windows() []Window {
+	return d.windows
+}
+
+// QuietHours returns the periods of the day the device must never run in, on
+// the weekday day
+func (d Device) QuietHours(day time.Weekday) []Window {
+	if (day == time.Saturday || day == time.Sunday) && d.quietWE != nil {
+		return d.quietWE
+	}
+	return d.quiet
+}
+
+// Quiet returns true if the period of length from start overlaps the quiet
+// hours of the device. A period crossing midnight belongs to the day it starts
+// on, so Friday night's quiet hours last into Saturday morning.
+func (d Device) Quiet(start time.Time, length time.Duration) bool {
+	end := start.Add(length)
+	y, m, day := start.Date()
+	for i := -1; i <= 1; i++ {
+		midnight := time.Date(y, m, day+i, 0, 0, 0, 0, start.Location())
+		if !midnight.Before(end) {
+			break
+		}
+		for _, w := range d.QuietHours(midnight.Weekday()) {
+			to := w.To
+			if to <= w.From {
+				to += 24 * time.Hour
+			}
+			if start.Before(midnight.Add(to)) && end.After(midnight.Add(w.From)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// QuietPolicy returns what to do when the runtime doesn't fit outside the
+// quiet hours: QuietError or QuietReduce
+func (d Device) QuietPolicy() string {
+	return d.quietPol
+}
+
+// CO2Weight returns the price per kg of CO2 the "weighted" strategy adds to
+// the price
+func (d Device) CO2Weight() float64 {
+	return d.co2Weight
+}
+
+// RatedWatts returns the load of the appliance in W, or zero if it's not set
+func (d Device) RatedWatts() float64 {
+	return d.watts
+}
+
+// Solar returns true if the device runs on solar surplus when its rated load
+// is exported
+func (d Device) Solar() bool {
+	return d.solar
+}
+
+// Priority returns how important it is to keep the device on when the
+// household is over the power limit. The lowest priority is switched off first.
+// Zero means never.
+func (d Device) Priority() int {
+	return d.priority
 }
 
 func (c *Config) Load(filename string) error {
@@ -87,16 +607,434 @@ func (c *Config) Load(filename string) error {
 	if c.token == "" {
 		return errors.New("empty token")
 	}
-	c.shellyIP = net.ParseIP(d.ShellyIP)
-	c.darkHours = defaultValue(d.DarkHours, 3)
-	c.hours = defaultValue(d.Hours, 12)
 	c.port = d.Port
+	c.dataDir = defaultString(d.DataDir, ".")
+	c.calendars = d.Calendars
+	if c.tariffs, err = parseTariffs(d.Tariffs); err != nil {
+		return err
+	}
+	if d.VAT < 0 || d.VAT > 1 {
+		return fmt.Errorf("vat must be between 0 and 1, not %g", d.VAT)
+	}
+	c.tax, c.vat = d.Tax, d.VAT
+	c.co2Source, c.co2Area = d.CO2Source, defaultString(d.CO2Area, "DK1")
+	if c.meter, c.meterIP, err = parseMeter(d.Meter, d.MeterField); err != nil {
+		return err
+	}
+	c.meterFld, c.meterExp = d.MeterField, d.MeterExport
+	c.meterInt = time.Duration(defaultValue(d.MeterInterval, 30)) * time.Second
+	c.limit = float64(atLeastZero(d.PowerLimit))
+	earliest, err := ParseClock(defaultString(d.Earliest, "00:00"))
+	if err != nil {
+		return fmt.Errorf("earliest_start: %w", err)
+	}
+	deadline, err := ParseClock(defaultString(d.Deadline, "24:00"))
+	if err != nil {
+		return fmt.Errorf("deadline: %w", err)
+	}
+	darkStart, darkEnd, darkSet, err := parseDarkWindow(d.DarkStart, d.DarkEnd)
+	if err != nil {
+		return err
+	}
+	loc, err := parseTimeZone(d.TimeZone)
+	if err != nil {
+		return err
+	}
+	slotLen, err := parseSlotLength(defaultValue(d.SlotLen, 60))
+	if err != nil {
+		return err
+	}
+	reconcile, err := parseReconcile(defaultString(d.Reconcile, ReconcileReport))
+	if err != nil {
+		return err
+	}
+	clock, err := parseClockCheck(defaultString(d.Clock, ClockWarn))
+	if err != nil {
+		return err
+	}
+	windows, err := parseWindows(d.Windows)
+	if err != nil {
+		return err
+	}
+	profiles, err := parseProfiles(d.Profiles)
+	if err != nil {
+		return err
+	}
+	quiet, err := parseWindows(d.QuietHours)
+	if err != nil {
+		return fmt.Errorf("quiet_hours: %w", err)
+	}
+	quietWE, err := parseWindows(d.QuietWeekend)
+	if err != nil {
+		return fmt.Errorf("quiet_hours_weekend: %w", err)
+	}
+	quietPol, err := parseQuietPolicy(defaultString(d.QuietPolicy, QuietError))
+	if err != nil {
+		return err
+	}
+	c.Device = Device{
+		name:      DefaultDevice,
+		shellyIP:  net.ParseIP(d.ShellyIP),
+		darkHours: defaultValue(d.DarkHours, 3),
+		hours:     defaultValue(d.Hours, 12),
+		minRun:    time.Duration(d.MinRun) * time.Minute,
+		minOff:    time.Duration(d.MinOff) * time.Minute,
+		maxStarts: d.MaxStarts,
+		maxJobs:   defaultValue(d.MaxJobs, 20),
+		strategy:  d.Strategy,
+		maxPrice:  d.MaxPrice,
+		minHours:  d.MinHours,
+		maxHours:  d.MaxHours,
+		earliest:  earliest,
+		deadline:  deadline,
+		maxGap:    time.Duration(d.MaxGap) * time.Minute,
+		carryCap:  time.Duration(d.CarryCap) * time.Minute,
+		decay:     defaultFloat(d.Decay, 1),
+		lat:       d.Latitude,
+		lon:       d.Longitude,
+		darkStart: darkStart,
+		darkEnd:   darkEnd,
+		darkSet:   darkSet,
+		loc:       loc,
+		slotLen:   slotLen,
+		runtime:   time.Duration(d.Runtime) * time.Minute,
+		timeout:   time.Duration(atLeastZero(defaultValue(d.Timeout, 10))) * time.Second,
+		retries:   atLeastZero(defaultValue(d.Retries, 2)),
+		threshold: atLeastZero(defaultValue(d.Threshold, 5)),
+		cooldown:  time.Duration(defaultValue(d.Cooldown, 60)) * time.Second,
+		reconcile: reconcile,
+		interval:  time.Duration(defaultValue(d.Interval, 15)) * time.Minute,
+		clock:     clock,
+		maxSkew:   time.Duration(defaultValue(d.MaxSkew, 120)) * time.Second,
+		syncClock: defaultBool(d.SyncClock, false),
+		sntp:      d.SNTP,
+		windows:   windows,
+		profiles:  profiles,
+		quiet:     quiet,
+		quietWE:   quietWE,
+		quietPol:  quietPol,
+		co2Weight: d.CO2Weight,
+		watts:     float64(atLeastZero(d.RatedWatts)),
+		solar:     d.Solar,
+		priority:  atLeastZero(d.Priority),
+	}
+	if err := checkLoad(d.RatedWatts, d.Solar, d.Priority); err != nil {
+		return err
+	}
+	if err := checkInterval("reconcile_interval", d.Interval); err != nil {
+		return err
+	}
+	if err := checkInterval("meter_interval", d.MeterInterval); err != nil {
+		return err
+	}
+	c.devices = make(map[string]Device, len(d.Devices))
+	for name, dc := range d.Devices {
+		if name == DefaultDevice {
+			return fmt.Errorf("device name %q is reserved", name)
+		}
+		earliest, err := ParseClock(defaultString(dc.Earliest, defaultString(d.Earliest, "00:00")))
+		if err != nil {
+			return fmt.Errorf("device %s: earliest_start: %w", name, err)
+		}
+		deadline, err := ParseClock(defaultString(dc.Deadline, defaultString(d.Deadline, "24:00")))
+		if err != nil {
+			return fmt.Errorf("device %s: deadline: %w", name, err)
+		}
+		darkStart, darkEnd, darkSet, err := parseDarkWindow(defaultString(dc.DarkStart, d.DarkStart), defaultString(dc.DarkEnd, d.DarkEnd))
+		if err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		loc, err := parseTimeZone(defaultString(dc.TimeZone, d.TimeZone))
+		if err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		slotLen, err := parseSlotLength(defaultValue(dc.SlotLen, defaultValue(d.SlotLen, 60)))
+		if err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		reconcile, err := parseReconcile(defaultString(dc.Reconcile, c.reconcile))
+		if err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		clock, err := parseClockCheck(defaultString(dc.Clock, c.clock))
+		if err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		windows := c.windows
+		if dc.Windows != nil {
+			if windows, err = parseWindows(dc.Windows); err != nil {
+				return fmt.Errorf("device %s: %w", name, err)
+			}
+		}
+		// A device setting its quiet hours doesn't inherit the top-level weekend ones
+		quiet, quietWE := c.quiet, c.quietWE
+		if dc.QuietHours != nil {
+			if quiet, err = parseWindows(dc.QuietHours); err != nil {
+				return fmt.Errorf("device %s: quiet_hours: %w", name, err)
+			}
+			quietWE = nil
+		}
+		if dc.QuietWeekend != nil {
+			if quietWE, err = parseWindows(dc.QuietWeekend); err != nil {
+				return fmt.Errorf("device %s: quiet_hours_weekend: %w", name, err)
+			}
+		}
+		quietPol, err := parseQuietPolicy(defaultString(dc.QuietPolicy, c.quietPol))
+		if err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		// Profiles are made for one appliance, so they aren't inherited
+		profiles, err := parseProfiles(dc.Profiles)
+		if err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		// A device setting its hours doesn't inherit the top-level runtime
+		runtime := dc.Runtime
+		if runtime == 0 && dc.Hours == 0 {
+			runtime = d.Runtime
+		}
+		// Named devices inherit anything they don't set from the top-level settings
+		c.devices[name] = Device{
+			name:      name,
+			shellyIP:  net.ParseIP(dc.ShellyIP),
+			darkHours: defaultValue(dc.DarkHours, c.darkHours),
+			hours:     defaultValue(dc.Hours, c.hours),
+			minRun:    time.Duration(defaultValue(dc.MinRun, d.MinRun)) * time.Minute,
+			minOff:    time.Duration(defaultValue(dc.MinOff, d.MinOff)) * time.Minute,
+			maxStarts: defaultValue(dc.MaxStarts, d.MaxStarts),
+			maxJobs:   defaultValue(dc.MaxJobs, c.maxJobs),
+			strategy:  defaultString(dc.Strategy, c.strategy),
+			maxPrice:  defaultFloat(dc.MaxPrice, c.maxPrice),
+			minHours:  defaultValue(dc.MinHours, c.minHours),
+			maxHours:  defaultValue(dc.MaxHours, c.maxHours),
+			earliest:  earliest,
+			deadline:  deadline,
+			maxGap:    time.Duration(defaultValue(dc.MaxGap, d.MaxGap)) * time.Minute,
+			carryCap:  time.Duration(defaultValue(dc.CarryCap, d.CarryCap)) * time.Minute,
+			decay:     defaultFloat(dc.Decay, c.decay),
+			lat:       defaultFloat(dc.Latitude, c.lat),
+			lon:       defaultFloat(dc.Longitude, c.lon),
+			darkStart: darkStart,
+			darkEnd:   darkEnd,
+			darkSet:   darkSet,
+			loc:       loc,
+			slotLen:   slotLen,
+			runtime:   time.Duration(runtime) * time.Minute,
+			timeout:   time.Duration(atLeastZero(defaultValue(dc.Timeout, defaultValue(d.Timeout, 10)))) * time.Second,
+			retries:   atLeastZero(defaultValue(dc.Retries, defaultValue(d.Retries, 2))),
+			threshold: atLeastZero(defaultValue(dc.Threshold, defaultValue(d.Threshold, 5))),
+			cooldown:  time.Duration(defaultValue(dc.Cooldown, defaultValue(d.Cooldown, 60))) * time.Second,
+			reconcile: reconcile,
+			interval:  time.Duration(defaultValue(dc.Interval, defaultValue(d.Interval, 15))) * time.Minute,
+			clock:     clock,
+			maxSkew:   time.Duration(defaultValue(dc.MaxSkew, defaultValue(d.MaxSkew, 120))) * time.Second,
+			syncClock: defaultBool(dc.SyncClock, c.syncClock),
+			sntp:      defaultString(dc.SNTP, d.SNTP),
+			windows:   windows,
+			profiles:  profiles,
+			quiet:     quiet,
+			quietWE:   quietWE,
+			quietPol:  quietPol,
+			co2Weight: defaultFloat(dc.CO2Weight, c.co2Weight),
+			// The load and priority are the appliance's own, so they aren't inherited
+			watts:    float64(atLeastZero(dc.RatedWatts)),
+			solar:    dc.Solar,
+			priority: atLeastZero(dc.Priority),
+		}
+		if err := checkLoad(dc.RatedWatts, dc.Solar, dc.Priority); err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+		if err := checkInterval("reconcile_interval", dc.Interval); err != nil {
+			return fmt.Errorf("device %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ParseClock parses a time of day in the format "15:04" and returns it as the
+// time since midnight. "24:00" is allowed, meaning the end of the day
+func ParseClock(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, should be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// checkLoad checks that a device running on solar surplus or being switched off
+// for the power limit has its load set
+func checkLoad(watts int, solar bool, priority int) error {
+	if watts > 0 {
+		return nil
+	}
+	switch {
+	case solar:
+		return errors.New("solar needs rated_watts")
+	case priority > 0:
+		return errors.New("priority needs rated_watts")
+	}
 	return nil
 }
 
+// checkInterval checks that an interval setting isn't negative, since it's used
+// for a ticker. Zero means the default.
+func checkInterval(setting string, v int) error {
+	if v < 0 {
+		return fmt.Errorf("%s must be positive, not %d", setting, v)
+	}
+	return nil
+}
+
+// parseSlotLength checks that a slot length in minutes is 15, 30 or 60
+func parseSlotLength(minutes int) (time.Duration, error) {
+	switch minutes {
+	case 15, 30, 60:
+		return time.Duration(minutes) * time.Minute, nil
+	}
+	return 0, fmt.Errorf("slot_length must be 15, 30 or 60 minutes, not %d", minutes)
+}
+
+// parseReconcile checks the reconcile policy
+func parseReconcile(policy string) (string, error) {
+	switch policy {
+	case ReconcileOff, ReconcileReport, ReconcileRepair:
+		return policy, nil
+	}
+	return "", fmt.Errorf("reconcile must be %q, %q or %q, not %q", ReconcileOff, ReconcileReport, ReconcileRepair, policy)
+}
+
+// parseClockCheck checks the clock check policy
+func parseClockCheck(policy string) (string, error) {
+	switch policy {
+	case ClockOff, ClockWarn, ClockRefuse:
+		return policy, nil
+	}
+	return "", fmt.Errorf("clock_check must be %q, %q or %q, not %q", ClockOff, ClockWarn, ClockRefuse, policy)
+}
+
+// parseTariffs parses the [[tariff]] sections. A tariff without from and to
+// applies all day
+func parseTariffs(tcs []tariffconf) ([]TariffPeriod, error) {
+	rv := make([]TariffPeriod, 0, len(tcs))
+	for i, tc := range tcs {
+		from, err := ParseClock(defaultString(tc.From, "00:00"))
+		if err != nil {
+			return nil, fmt.Errorf("tariff %d: from: %w", i+1, err)
+		}
+		to, err := ParseClock(defaultString(tc.To, "24:00"))
+		if err != nil {
+			return nil, fmt.Errorf("tariff %d: to: %w", i+1, err)
+		}
+		t := TariffPeriod{Window: Window{From: from, To: to}, Price: tc.Price}
+		for _, m := range tc.Months {
+			if m < 1 || m > 12 {
+				return nil, fmt.Errorf("tariff %d: invalid month %d, must be 1 to 12", i+1, m)
+			}
+			t.Months = append(t.Months, time.Month(m))
+		}
+		rv = append(rv, t)
+	}
+	return rv, nil
+}
+
+// parseMeter checks the meter, which is either an http(s) URL with a JSON
+// document having the grid power in field, or the IP of a Shelly energy meter
+func parseMeter(meter, field string) (string, net.IP, error) {
+	if meter == "" {
+		return "", nil, nil
+	}
+	if strings.HasPrefix(meter, "http://") || strings.HasPrefix(meter, "https://") {
+		if field == "" {
+			return "", nil, errors.New("meter_field is needed with a meter URL")
+		}
+		return meter, nil, nil
+	}
+	ip := net.ParseIP(meter)
+	if ip == nil {
+		return "", nil, fmt.Errorf("meter must be an http(s) URL or an IP, not %q", meter)
+	}
+	return "", ip, nil
+}
+
+// parseQuietPolicy checks the quiet hours policy
+func parseQuietPolicy(policy string) (string, error) {
+	switch policy {
+	case QuietError, QuietReduce:
+		return policy, nil
+	}
+	return "", fmt.Errorf("quiet_hours_policy must be %q or %q, not %q", QuietError, QuietReduce, policy)
+}
+
+// parseTimeZone parses an IANA time zone name, like "Europe/Copenhagen". An
+// empty name returns nil
+func parseTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("timezone: %w", err)
+	}
+	return loc, nil
+}
+
+// parseDarkWindow parses dark_start and dark_end. Either both or none must be set
+func parseDarkWindow(start, end string) (time.Duration, time.Duration, bool, error) {
+	if start == "" && end == "" {
+		return 0, 0, false, nil
+	}
+	if start == "" || end == "" {
+		return 0, 0, false, errors.New("dark_start and dark_end must both be set")
+	}
+	s, err := ParseClock(start)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("dark_start: %w", err)
+	}
+	e, err := ParseClock(end)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("dark_end: %w", err)
+	}
+	return s, e, true, nil
+}
+
 func defaultValue(i, d int) int {
 	if i == 0 {
 		return d
 	}
 	return i
 }
+
+// atLeastZero returns i, or zero if i is negative. It allows settings where 0
+// means the default to be turned off with a negative value
+func atLeastZero(i int) int {
+	if i < 0 {
+		return 0
+	}
+	return i
+}
+
+func defaultFloat(f, d float64) float64 {
+	if f == 0 {
+		return d
+	}
+	return f
+}
+
+// defaultBool returns *b, or d if b isn't set, so a device can turn off a
+// setting that's on at the top level
+func defaultBool(b *bool, d bool) bool {
+	if b == nil {
+		return d
+	}
+	return *b
+}
+
+func defaultString(s, d string) string {
+	if s == "" {
+		return d
+	}
+	return s
+}
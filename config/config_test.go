@@ -0,0 +1,99 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDevice_Quiet(t *testing.T) {
+	d := Device{
+		quiet:   []Window{{From: 22 * time.Hour, To: 7 * time.Hour}},
+		quietWE: []Window{{From: 23 * time.Hour, To: 9 * time.Hour}},
+	}
+	// 2022-07-01 is a Friday
+	at := func(day, hour, minute int) time.Time { return time.Date(2022, 7, day, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		name  string
+		start time.Time
+		want  bool
+	}{
+		{name: "Friday evening", start: at(1, 21, 0), want: false},
+		{name: "Friday night", start: at(1, 22, 30), want: true},
+		{name: "Saturday night from Friday", start: at(2, 6, 0), want: true},
+		{name: "Saturday morning after Friday's", start: at(2, 7, 30), want: false},
+		{name: "Saturday evening", start: at(2, 22, 0), want: false},
+		{name: "Saturday night", start: at(2, 23, 15), want: true},
+		{name: "Sunday morning from Saturday", start: at(3, 8, 0), want: true},
+		{name: "Monday morning from Sunday", start: at(4, 8, 30), want: true},
+		{name: "Monday", start: at(4, 9, 0), want: false},
+		{name: "Monday night", start: at(4, 22, 0), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Quiet(tt.start, 30*time.Minute); got != tt.want {
+				t.Errorf("Quiet(%s) = %t, want %t", tt.start.Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Load_intervals(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    string
+		wantErr bool
+	}{
+		{name: "default", conf: ""},
+		{name: "reconcile_interval", conf: "reconcile_interval = 5"},
+		{name: "negative reconcile_interval", conf: "reconcile_interval = -5", wantErr: true},
+		{name: "negative device reconcile_interval", conf: "[device.boiler]\nreconcile_interval = -5", wantErr: true},
+		{name: "meter_interval", conf: "meter_interval = 10"},
+		{name: "negative meter_interval", conf: "meter_interval = -10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			if err := c.Load(writeConfig(t, tt.conf)); (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_Load_syncClock(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want bool
+	}{
+		{name: "default", conf: "[device.boiler]", want: false},
+		{name: "inherited", conf: "sync_clock = true\n[device.boiler]", want: true},
+		{name: "set on the device", conf: "[device.boiler]\nsync_clock = true", want: true},
+		{name: "turned off on the device", conf: "sync_clock = true\n[device.boiler]\nsync_clock = false", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			if err := c.Load(writeConfig(t, tt.conf)); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.devices["boiler"].SyncClock(); got != tt.want {
+				t.Errorf("SyncClock() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeConfig writes a config file with conf and the required settings, and
+// returns its name
+func writeConfig(t *testing.T, conf string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "schedule.conf")
+	data := "mid = \"123456789012345678\"\ntoken = \"token\"\n" + conf + "\n"
+	if err := ioutil.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// profileconf is a [profile.<name>] section, replacing some of the settings of
+// a device on the days its rules match
+type profileconf struct {
+	Hours     *int     `toml:"hours"`
+	DarkHours *int     `toml:"darkhours"`
+	Strategy  string   `toml:"strategy"`
+	Windows   []string `toml:"windows"`
+	From      string   `toml:"from"`
+	To        string   `toml:"to"`
+	Months    []int    `toml:"months"`
+	Weekdays  []string `toml:"weekdays"`
+	Priority  int      `toml:"priority"`
+}
+
+// Window is a period of the day, from the time of day From to To. It crosses
+// midnight if To is before From
+type Window struct {
+	From time.Duration
+	To   time.Duration
+}
+
+// profile is a named set of settings replacing those of a device
+type profile struct {
+	name      string
+	hours     *int
+	darkHours *int
+	strategy  string
+	windows   []Window
+	rule      dateRule
+	priority  int
+}
+
+// dateRule decides the days a profile is active. All the parts that are set
+// must match. A rule with no parts never matches, so the profile can only be
+// selected manually.
+type dateRule struct {
+	// from and to are dates as yyyymmdd, or mmdd if the range is every year
+	from, to int
+	yearly   bool
+	// months and weekdays are bit sets, with a bit for each month and weekday
+	months   uint16
+	weekdays uint8
+}
+
+// empty returns true if the rule has no parts
+func (r dateRule) empty() bool {
+	return r.from == 0 && r.months == 0 && r.weekdays == 0
+}
+
+// matches returns true if the rule matches the date of t
+func (r dateRule) matches(t time.Time) bool {
+	if r.empty() {
+		return false
+	}
+	y, m, d := t.Date()
+	if r.months != 0 && r.months&(1<<uint(m)) == 0 {
+		return false
+	}
+	if r.weekdays != 0 && r.weekdays&(1<<uint(t.Weekday())) == 0 {
+		return false
+	}
+	if r.from == 0 {
+		return true
+	}
+	day := int(m)*100 + d
+	if !r.yearly {
+		day += y * 10000
+	}
+	if r.from <= r.to {
+		return day >= r.from && day <= r.to
+	}
+	// A yearly range crossing new year, like November to March
+	return day >= r.from || day <= r.to
+}
+
+// Profiles returns the names of the profiles of the device
+func (d Device) Profiles() []string {
+	rv := make([]string, 0, len(d.profiles))
+	for name := range d.profiles {
+		rv = append(rv, name)
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// ActiveProfile returns the name of the profile whose rules match the date of
+// t, or "" if none do. If more match, the one with the highest priority wins,
+// and then the first by name.
+func (d Device) ActiveProfile(t time.Time) string {
+	var rv *profile
+	for _, name := range d.Profiles() {
+		p := d.profiles[name]
+		if !p.rule.matches(t) {
+			continue
+		}
+		if rv == nil || p.priority > rv.priority {
+			rv = &p
+		}
+	}
+	if rv == nil {
+		return ""
+	}
+	return rv.name
+}
+
+// WithProfile returns the device with the settings of the profile called name
+// replacing its own, and false if there's no such profile
+func (d Device) WithProfile(name string) (Device, bool) {
+	p, ok := d.profiles[name]
+	if !ok {
+		return d, false
+	}
+	if p.hours != nil {
+		d.hours, d.runtime = *p.hours, 0
+	}
+	if p.darkHours != nil {
+		d.darkHours = *p.darkHours
+	}
+	if p.strategy != "" {
+		d.strategy = p.strategy
+	}
+	if p.windows != nil {
+		d.windows = p.windows
+	}
+	return d, true
+}
+
+// parseProfiles parses the profile sections of a device
+func parseProfiles(pcs map[string]profileconf) (map[string]profile, error) {
+	rv := make(map[string]profile, len(pcs))
+	for name, pc := range pcs {
+		p := profile{
+			name:      name,
+			hours:     pc.Hours,
+			darkHours: pc.DarkHours,
+			strategy:  pc.Strategy,
+			priority:  pc.Priority,
+		}
+		var err error
+		if p.windows, err = parseWindows(pc.Windows); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		if p.rule, err = parseDateRule(pc); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", name, err)
+		}
+		rv[name] = p
+	}
+	return rv, nil
+}
+
+// parseDateRule parses the rules of a profile: a range of dates `from` and
+// `to`, both either "MM-DD" for every year, or "YYYY-MM-DD", and lists of
+// months (1-12) and weekdays ("mon" to "sun")
+func parseDateRule(pc profileconf) (dateRule, error) {
+	var r dateRule
+	if (pc.From == "") != (pc.To == "") {
+		return r, fmt.Errorf("from and to must both be set")
+	}
+	if pc.From != "" {
+		from, fromYearly, err := parseDate(pc.From)
+		if err != nil {
+			return r, fmt.Errorf("from: %w", err)
+		}
+		to, toYearly, err := parseDate(pc.To)
+		if err != nil {
+			return r, fmt.Errorf("to: %w", err)
+		}
+		if fromYearly != toYearly {
+			return r, fmt.Errorf("from and to must both have a year, or both be without")
+		}
+		if !fromYearly && to < from {
+			return r, fmt.Errorf("from %s is after to %s", pc.From, pc.To)
+		}
+		r.from, r.to, r.yearly = from, to, fromYearly
+	}
+	for _, m := range pc.Months {
+		if m < 1 || m > 12 {
+			return r, fmt.Errorf("invalid month %d, must be 1 to 12", m)
+		}
+		r.months |= 1 << uint(m)
+	}
+	for _, w := range pc.Weekdays {
+		wd, err := parseWeekday(w)
+		if err != nil {
+			return r, err
+		}
+		r.weekdays |= 1 << uint(wd)
+	}
+	return r, nil
+}
+
+// parseDate parses "YYYY-MM-DD" as yyyymmdd, or "MM-DD" as mmdd and true
+func parseDate(s string) (int, bool, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.Year()*10000 + int(t.Month())*100 + t.Day(), false, nil
+	}
+	// A leap year, so "02-29" is allowed
+	if t, err := time.Parse("2006-01-02", "2000-"+s); err == nil {
+		return int(t.Month())*100 + t.Day(), true, nil
+	}
+	return 0, false, fmt.Errorf("invalid date %q, should be YYYY-MM-DD or MM-DD", s)
+}
+
+// parseWeekday parses the first three letters of the English name of a weekday
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if len(s) >= 3 && strings.HasPrefix(strings.ToLower(d.String()), strings.ToLower(s)) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %q", s)
+}
+
+// parseWindows parses periods of the day like "06:00-08:00"
+func parseWindows(ws []string) ([]Window, error) {
+	if ws == nil {
+		return nil, nil
+	}
+	rv := make([]Window, 0, len(ws))
+	for _, w := range ws {
+		parts := strings.Split(w, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid window %q, should be HH:MM-HH:MM", w)
+		}
+		from, err := ParseClock(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", w, err)
+		}
+		to, err := ParseClock(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", w, err)
+		}
+		if from == to {
+			return nil, fmt.Errorf("window %q is empty", w)
+		}
+		rv = append(rv, Window{From: from, To: to})
+	}
+	return rv, nil
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateRule_Matches(t *testing.T) {
+	date := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 12, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name string
+		pc   profileconf
+		t    time.Time
+		want bool
+	}{
+		{name: "no rules", t: date(2022, 7, 1), want: false},
+		{name: "in date range", pc: profileconf{From: "2022-06-20", To: "2022-08-10"}, t: date(2022, 7, 1), want: true},
+		{name: "last day of date range", pc: profileconf{From: "2022-06-20", To: "2022-08-10"}, t: date(2022, 8, 10), want: true},
+		{name: "date range another year", pc: profileconf{From: "2022-06-20", To: "2022-08-10"}, t: date(2023, 7, 1), want: false},
+		{name: "yearly range", pc: profileconf{From: "06-20", To: "08-10"}, t: date(2025, 7, 1), want: true},
+		{name: "yearly range across new year, before", pc: profileconf{From: "11-01", To: "03-31"}, t: date(2022, 12, 24), want: true},
+		{name: "yearly range across new year, after", pc: profileconf{From: "11-01", To: "03-31"}, t: date(2023, 2, 1), want: true},
+		{name: "yearly range across new year, outside", pc: profileconf{From: "11-01", To: "03-31"}, t: date(2023, 7, 1), want: false},
+		{name: "leap day", pc: profileconf{From: "02-29", To: "02-29"}, t: date(2024, 2, 29), want: true},
+		{name: "leap day in a range", pc: profileconf{From: "02-28", To: "03-01"}, t: date(2024, 2, 29), want: true},
+		{name: "month", pc: profileconf{Months: []int{6, 7, 8}}, t: date(2022, 7, 1), want: true},
+		{name: "other month", pc: profileconf{Months: []int{12, 1}}, t: date(2022, 7, 1), want: false},
+		{name: "weekday", pc: profileconf{Weekdays: []string{"sat", "Sunday"}}, t: date(2022, 7, 2), want: true},
+		{name: "other weekday", pc: profileconf{Weekdays: []string{"sat", "sun"}}, t: date(2022, 7, 1), want: false},
+		{name: "all parts", pc: profileconf{From: "06-01", To: "08-31", Weekdays: []string{"fri"}}, t: date(2022, 7, 1), want: true},
+		{name: "not all parts", pc: profileconf{From: "06-01", To: "08-31", Weekdays: []string{"mon"}}, t: date(2022, 7, 1), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseDateRule(tt.pc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.matches(tt.t); got != tt.want {
+				t.Errorf("matches(%s) = %t, want %t", tt.t.Format("2006-01-02"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		s          string
+		want       int
+		wantYearly bool
+		wantErr    bool
+	}{
+		{s: "2022-07-01", want: 20220701},
+		{s: "07-01", want: 701, wantYearly: true},
+		{s: "02-29", want: 229, wantYearly: true},
+		{s: "2023-02-29", wantErr: true},
+		{s: "13-01", wantErr: true},
+		{s: "1st of July", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, yearly, err := parseDate(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || yearly != tt.wantYearly {
+				t.Errorf("parseDate() = %d, %t, want %d, %t", got, yearly, tt.want, tt.wantYearly)
+			}
+		})
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Weekday
+		wantErr bool
+	}{
+		{s: "mon", want: time.Monday},
+		{s: "Sat", want: time.Saturday},
+		{s: "thursday", want: time.Thursday},
+		{s: "SUN", want: time.Sunday},
+		{s: "tu", wantErr: true},
+		{s: "mondays", wantErr: true},
+		{s: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseWeekday(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWeekday() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseWeekday() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevice_ActiveProfile(t *testing.T) {
+	profiles, err := parseProfiles(map[string]profileconf{
+		"summer":   {Months: []int{6, 7, 8}},
+		"weekend":  {Weekdays: []string{"sat", "sun"}, Priority: 1},
+		"holiday":  {From: "2022-07-01", To: "2022-07-03", Priority: 2},
+		"vacation": {From: "2022-07-01", To: "2022-07-03", Priority: 2},
+		"manual":   {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Device{profiles: profiles}
+	tests := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{name: "none", t: time.Date(2022, 1, 5, 12, 0, 0, 0, time.UTC), want: ""},
+		{name: "only one", t: time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC), want: "summer"},
+		{name: "higher priority", t: time.Date(2022, 6, 4, 12, 0, 0, 0, time.UTC), want: "weekend"},
+		{name: "first by name of the highest", t: time.Date(2022, 7, 2, 12, 0, 0, 0, time.UTC), want: "holiday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.ActiveProfile(tt.t); got != tt.want {
+				t.Errorf("ActiveProfile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -20,6 +20,11 @@ func ProcessCommon(r *http.Request) context.Context {
 	return ctx
 }
 
+// WithPretend returns a copy of ctx with the pretend flag set to p
+func WithPretend(ctx context.Context, p bool) context.Context {
+	return context.WithValue(ctx, pretend, p)
+}
+
 func Pretend(ctx context.Context) bool {
 	if ctx == nil {
 		return false
@@ -0,0 +1,80 @@
+package schellydule
+
+import (
+	"fmt"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// Drift is how the jobs on a Shelly differ from the jobs installed on it
+type Drift struct {
+	// Missing are runs that were installed, but aren't on the Shelly
+	Missing sch.Schedule
+	// Extra are runs on the Shelly that weren't installed
+	Extra sch.Schedule
+	// NoRefresher is true if the job renewing the schedule is missing
+	NoRefresher bool
+}
+
+// Empty returns true if the Shelly has the jobs that were installed
+func (d Drift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && !d.NoRefresher
+}
+
+// Describe returns a line for each difference
+func (d Drift) Describe() []string {
+	var rv []string
+	for _, e := range d.Missing {
+		rv = append(rv, fmt.Sprintf("run %s-%s is missing", e.Start.Format("15:04"), e.Stop.Format("15:04")))
+	}
+	for _, e := range d.Extra {
+		rv = append(rv, fmt.Sprintf("run %s-%s wasn't installed", e.Start.Format("15:04"), e.Stop.Format("15:04")))
+	}
+	if d.NoRefresher {
+		rv = append(rv, "the job renewing the schedule is missing")
+	}
+	return rv
+}
+
+// FindDrift compares the jobs on a Shelly in the time zone loc, got, with the
+// jobs installed on it, want. Runs are compared by their times of day, so a
+// changed cost or enabled state isn't drift.
+func FindDrift(want, got shelly.Schedules, loc *time.Location) (Drift, error) {
+	var d Drift
+	wantRuns, err := ScheduleIn(want, loc)
+	if err != nil {
+		return d, fmt.Errorf("installed schedule: %w", err)
+	}
+	gotRuns, err := ScheduleIn(got, loc)
+	if err != nil {
+		return d, fmt.Errorf("schedule on the device: %w", err)
+	}
+	matched := make([]bool, len(gotRuns))
+	for _, w := range wantRuns {
+		found := false
+		for i, g := range gotRuns {
+			if !matched[i] && g.Start.Equal(w.Start) && g.Stop.Equal(w.Stop) {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			d.Missing = append(d.Missing, w)
+		}
+	}
+	for i, g := range gotRuns {
+		if !matched[i] {
+			d.Extra = append(d.Extra, g)
+		}
+	}
+	d.NoRefresher = true
+	for _, j := range got {
+		if j.IsRefresher() {
+			d.NoRefresher = false
+			break
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,76 @@
+package schellydule
+
+import (
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+var refresher = shelly.JobSpec{
+	Id:       42,
+	Enable:   true,
+	Timespec: "0 1 0 * * *",
+	Calls:    []shelly.Call{{Method: "HTTP.Get", Params: map[string]interface{}{"url": "http://10.0.0.2:8080/renewSchedules"}}},
+}
+
+// jobsFor returns the jobs for runs starting at the given hours today in loc,
+// each lasting an hour, and the refresher
+func jobsFor(loc *time.Location, cost float64, hours ...int) shelly.Schedules {
+	today := sch.Hour(time.Now().In(loc), 0)
+	var s sch.Schedule
+	for _, h := range hours {
+		start := today.Add(time.Duration(h) * time.Hour)
+		s = append(s, sch.Entry{Start: start, Stop: start.Add(time.Hour), Cost: cost})
+	}
+	return append(shelly.ShellyScheduleIn(s, true, loc).Jobs, refresher)
+}
+
+func TestFindDrift(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := jobsFor(loc, 1, 2, 3, 14)
+	tests := []struct {
+		name          string
+		got           shelly.Schedules
+		wantMissing   int
+		wantExtra     int
+		wantRefresher bool
+	}{
+		{name: "no drift", got: want},
+		{name: "other costs", got: jobsFor(loc, 2, 2, 3, 14)},
+		{name: "run deleted", got: jobsFor(loc, 1, 2, 14), wantMissing: 1},
+		{name: "run added", got: jobsFor(loc, 1, 2, 3, 14, 20), wantExtra: 1},
+		{name: "run moved", got: jobsFor(loc, 1, 2, 3, 15), wantMissing: 1, wantExtra: 1},
+		{name: "all deleted", got: shelly.Schedules{}, wantMissing: 3, wantRefresher: true},
+		{name: "refresher deleted", got: want[:len(want)-1], wantRefresher: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindDrift(want, tt.got, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Missing) != tt.wantMissing || len(got.Extra) != tt.wantExtra || got.NoRefresher != tt.wantRefresher {
+				t.Errorf("FindDrift() = %d missing, %d extra, no refresher %t, want %d, %d, %t",
+					len(got.Missing), len(got.Extra), got.NoRefresher, tt.wantMissing, tt.wantExtra, tt.wantRefresher)
+			}
+			if empty := tt.wantMissing == 0 && tt.wantExtra == 0 && !tt.wantRefresher; got.Empty() != empty {
+				t.Errorf("Empty() = %t, want %t", got.Empty(), empty)
+			}
+			if n := len(got.Describe()); n != tt.wantMissing+tt.wantExtra+boolInt(tt.wantRefresher) {
+				t.Errorf("Describe() has %d lines: %q", n, got.Describe())
+			}
+		})
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,60 @@
+package schellydule
+
+import (
+	"strings"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// Gap is a period where a schedule doesn't run
+type Gap struct {
+	Start time.Time
+	Stop  time.Time
+}
+
+// Length returns the length of the gap
+func (g Gap) Length() time.Duration {
+	return g.Stop.Sub(g.Start)
+}
+
+// Gaps returns the periods between from and to that s doesn't cover. Gaps of a
+// minute or less are ignored, since stops at midnight are moved to 23:59.
+func Gaps(s sch.Schedule, from, to time.Time) []Gap {
+	var rv []Gap
+	t := from
+	for _, e := range Compact(s) {
+		if e.Stop.Before(from) || e.Start.After(to) {
+			continue
+		}
+		if e.Start.Sub(t) > time.Minute {
+			rv = append(rv, Gap{Start: t, Stop: e.Start})
+		}
+		if e.Stop.After(t) {
+			t = e.Stop
+		}
+	}
+	if to.Sub(t) > time.Minute {
+		rv = append(rv, Gap{Start: t, Stop: to})
+	}
+	return rv
+}
+
+// Timeline draws s from `from` as a line of n characters, each representing a
+// step. A step is drawn as '#' if the schedule runs at the start of it, and
+// '.' otherwise.
+func Timeline(s sch.Schedule, from time.Time, step time.Duration, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		t := from.Add(time.Duration(i) * step)
+		c := '.'
+		for _, e := range s {
+			if !t.Before(e.Start) && t.Before(e.Stop) {
+				c = '#'
+				break
+			}
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package schellydule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+func TestGaps(t *testing.T) {
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	tests := []struct {
+		name string
+		s    sch.Schedule
+		want []Gap
+	}{
+		{
+			name: "empty schedule is one gap",
+			s:    sch.Schedule{},
+			want: []Gap{{Start: at(0, 0), Stop: at(24, 0)}},
+		},
+		{
+			name: "gaps before, between and after",
+			s:    sch.Schedule{entry(2, 4, 0), entry(8, 10, 0)},
+			want: []Gap{{Start: at(0, 0), Stop: at(2, 0)}, {Start: at(4, 0), Stop: at(8, 0)}, {Start: at(10, 0), Stop: at(24, 0)}},
+		},
+		{
+			name: "stop at 23:59 is not a gap",
+			s:    sch.Schedule{{Start: at(0, 0), Stop: at(12, 0)}, {Start: at(20, 0), Stop: at(23, 59)}},
+			want: []Gap{{Start: at(12, 0), Stop: at(20, 0)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Gaps(tt.s, at(0, 0), at(24, 0)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Gaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	s := sch.Schedule{entry(2, 4, 0), entry(8, 10, 0)}
+	want := "..##....##.."
+	if got := Timeline(s, day, time.Hour, 12); got != want {
+		t.Errorf("Timeline() = %q, want %q", got, want)
+	}
+}
@@ -9,14 +9,11 @@ require (
 	github.com/adamhassel/schedule v0.0.0-20220626210512-a755c00fd42e
 	github.com/robfig/cron/v3 v3.0.1
 	github.com/thlib/go-timezone-local v0.0.0-20210907160436-ef149e42d28e
-	github.com/tidwall/gjson v1.14.1
 )
 
 require (
 	github.com/kelvins/sunrisesunset v0.0.0-20210220141756-39fa1bd816d5 // indirect
 	github.com/rickar/cal/v2 v2.1.5 // indirect
-	github.com/tidwall/match v1.1.1 // indirect
-	github.com/tidwall/pretty v1.2.0 // indirect
 	golang.org/x/sys v0.0.0-20220624220833-87e55d714810 // indirect
 )
 
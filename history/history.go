@@ -0,0 +1,230 @@
+// Package history keeps track of how long each device has actually run
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dateFormat is the format of the keys of the days in the store
+const dateFormat = "2006-01-02"
+
+// keepDays is the number of days of history kept for each device
+const keepDays = 400
+
+// Day is the runtime record of a device for a single day
+type Day struct {
+	// Planned is the runtime the schedule asked for
+	Planned time.Duration `json:"planned"`
+	// Delivered is the time the relay was actually on
+	Delivered time.Duration `json:"delivered"`
+	// Energy is the energy used in Wh, if the device measures it
+	Energy float64 `json:"energy,omitempty"`
+	// Boosted is the runtime asked for by boosts, on top of or instead of the
+	// schedule
+	Boosted time.Duration `json:"boosted,omitempty"`
+	// Solar is the runtime moved from the schedule to run on solar surplus
+	Solar time.Duration `json:"solar,omitempty"`
+}
+
+// Shortfall returns how much less the device ran than planned. It's negative if
+// the device ran more than planned.
+func (d Day) Shortfall() time.Duration {
+	return d.Planned - d.Delivered
+}
+
+type sample struct {
+	t      time.Time
+	on     bool
+	energy float64
+}
+
+// Store is a persistent store of daily runtimes
+type Store struct {
+	mu       sync.Mutex
+	filename string
+	days     map[string]map[string]*Day
+	last     map[string]sample
+	// maxInterval is the longest time between two samples that is counted as
+	// runtime. Longer intervals means we weren't watching.
+	maxInterval time.Duration
+}
+
+// Open returns a store persisted in filename, reading any existing history
+func Open(filename string, sampleInterval time.Duration) (*Store, error) {
+	s := &Store{
+		filename:    filename,
+		days:        make(map[string]map[string]*Day),
+		last:        make(map[string]sample),
+		maxInterval: 2 * sampleInterval,
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.days); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// day returns the record for device on the date of t, creating it if needed.
+// Must be called with the lock held.
+func (s *Store) day(device string, t time.Time) *Day {
+	days, ok := s.days[device]
+	if !ok {
+		days = make(map[string]*Day)
+		s.days[device] = days
+	}
+	key := t.Format(dateFormat)
+	d, ok := days[key]
+	if !ok {
+		d = &Day{}
+		days[key] = d
+	}
+	return d
+}
+
+// Record records the relay state and energy counter (in Wh) of device at time t.
+// The time since the previous sample is counted as runtime if the relay was on
+// then.
+func (s *Store) Record(device string, t time.Time, on bool, energy float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev, ok := s.last[device]
+	s.last[device] = sample{t: t, on: on, energy: energy}
+	if !ok || !t.After(prev.t) || t.Sub(prev.t) > s.maxInterval {
+		return
+	}
+	d := s.day(device, prev.t)
+	if prev.on {
+		d.Delivered += t.Sub(prev.t)
+	}
+	// The counter resets when the device reboots
+	if energy >= prev.energy {
+		d.Energy += energy - prev.energy
+	}
+}
+
+// Add adds runtime to device on the date of t, for runs that weren't sampled
+func (s *Store) Add(device string, t time.Time, runtime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.day(device, t).Delivered += runtime
+}
+
+// AddBoost records a boost of device at time t, running for length
+func (s *Store) AddBoost(device string, t time.Time, length time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.day(device, t).Boosted += length
+}
+
+// AddSolar records that device ran on solar surplus for length at time t
+func (s *Store) AddSolar(device string, t time.Time, length time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.day(device, t).Solar += length
+}
+
+// SetPlanned sets the runtime planned for device on the date of t
+func (s *Store) SetPlanned(device string, t time.Time, planned time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.day(device, t).Planned = planned
+}
+
+// Day returns the record of device on the date of t
+func (s *Store) Day(device string, t time.Time) (Day, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.days[device][t.Format(dateFormat)]
+	if !ok {
+		return Day{}, false
+	}
+	return *d, true
+}
+
+// Days returns the records of device from the date of `from` up to and
+// including the date of `to`, keyed by date
+func (s *Store) Days(device string, from, to time.Time) map[string]Day {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rv := make(map[string]Day)
+	first, last := from.Format(dateFormat), to.Format(dateFormat)
+	for k, d := range s.days[device] {
+		if k >= first && k <= last {
+			rv[k] = *d
+		}
+	}
+	return rv
+}
+
+// Carry returns the runtime to add to the plan of device on the date of t: the
+// shortfall of the day before, multiplied by decay and limited to ±max. Since
+// the plan of the day before included its own carry-over, a shortfall that
+// isn't made up decays a little every day.
+func (s *Store) Carry(device string, t time.Time, max time.Duration, decay float64) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	y, ok := s.Day(device, t.AddDate(0, 0, -1))
+	if !ok || y.Planned == 0 {
+		return 0
+	}
+	carry := time.Duration(float64(y.Shortfall()) * decay)
+	if carry > max {
+		return max
+	}
+	if carry < -max {
+		return -max
+	}
+	return carry
+}
+
+// Save writes the store to disk, dropping days older than keepDays
+func (s *Store) Save() error {
+	s.mu.Lock()
+	oldest := time.Now().AddDate(0, 0, -keepDays).Format(dateFormat)
+	for _, days := range s.days {
+		keys := make([]string, 0, len(days))
+		for k := range days {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k >= oldest {
+				break
+			}
+			delete(days, k)
+		}
+	}
+	data, err := json.Marshal(s.days)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so a crash doesn't leave a broken file
+	tmp, err := ioutil.TempFile(filepath.Dir(s.filename), filepath.Base(s.filename))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filename)
+}
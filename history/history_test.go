@@ -0,0 +1,123 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var day = time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+func TestStore_Record(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "history.json"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(m int) time.Time { return day.Add(time.Duration(m) * time.Minute) }
+	s.Record("pool", at(0), true, 0)
+	s.Record("pool", at(1), true, 10)
+	s.Record("pool", at(2), false, 20)
+	s.Record("pool", at(3), true, 20)
+	// A gap in sampling isn't counted
+	s.Record("pool", at(30), true, 50)
+	s.Record("pool", at(31), false, 55)
+
+	got, ok := s.Day("pool", day)
+	if !ok {
+		t.Fatal("no day recorded")
+	}
+	if want := 3 * time.Minute; got.Delivered != want {
+		t.Errorf("Delivered = %s, want %s", got.Delivered, want)
+	}
+	if want := 25.0; got.Energy != want {
+		t.Errorf("Energy = %f, want %f", got.Energy, want)
+	}
+}
+
+func TestStore_Carry(t *testing.T) {
+	tests := []struct {
+		name      string
+		planned   time.Duration
+		delivered time.Duration
+		max       time.Duration
+		decay     float64
+		want      time.Duration
+	}{
+		{
+			name:      "shortfall",
+			planned:   12 * time.Hour,
+			delivered: 10 * time.Hour,
+			max:       4 * time.Hour,
+			decay:     1,
+			want:      2 * time.Hour,
+		},
+		{
+			name:      "surplus",
+			planned:   12 * time.Hour,
+			delivered: 13 * time.Hour,
+			max:       4 * time.Hour,
+			decay:     1,
+			want:      -time.Hour,
+		},
+		{
+			name:      "capped",
+			planned:   12 * time.Hour,
+			delivered: 2 * time.Hour,
+			max:       4 * time.Hour,
+			decay:     1,
+			want:      4 * time.Hour,
+		},
+		{
+			name:      "decayed",
+			planned:   12 * time.Hour,
+			delivered: 10 * time.Hour,
+			max:       4 * time.Hour,
+			decay:     0.5,
+			want:      time.Hour,
+		},
+		{
+			name:      "disabled",
+			planned:   12 * time.Hour,
+			delivered: 10 * time.Hour,
+			decay:     1,
+			want:      0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(filepath.Join(t.TempDir(), "history.json"), time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.SetPlanned("pool", day, tt.planned)
+			s.Add("pool", day, tt.delivered)
+			if got := s.Carry("pool", day.AddDate(0, 0, 1), tt.max, tt.decay); got != tt.want {
+				t.Errorf("Carry() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_Save(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.json")
+	s, err := Open(filename, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.SetPlanned("pool", now, time.Hour)
+	s.SetPlanned("pool", now.AddDate(-2, 0, 0), time.Hour)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(filename, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := s.Day("pool", now); !ok || d.Planned != time.Hour {
+		t.Errorf("Day() = %v, %t after reload", d, ok)
+	}
+	if _, ok := s.Day("pool", now.AddDate(-2, 0, 0)); ok {
+		t.Error("old day wasn't dropped")
+	}
+}
@@ -0,0 +1,283 @@
+package schellydule
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// Calendar actions, tagging events as `block:<device>` or `run:<device>` in
+// the categories, summary or description of an event. The device `*` is every
+// device.
+const (
+	// ActionBlock keeps the device off during the event
+	ActionBlock = "block"
+	// ActionRun keeps the device on during the event
+	ActionRun = "run"
+)
+
+// tagPattern matches a tag in the summary or description of an event
+var tagPattern = regexp.MustCompile(`#?\b(block|run):([^\s,;]+)`)
+
+// ReadCalendar reads the events of an iCalendar (RFC 5545) calendar from r.
+// Times without a time zone are in loc. Recurring events are returned once,
+// with their recurrence, see Event.Occurrences.
+func ReadCalendar(r io.Reader, loc *time.Location) ([]Event, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var rv []Event
+	var e *Event
+	var duration time.Duration
+	for n, l := range lines {
+		name, params, value := splitContentLine(l)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			e, duration = &Event{}, 0
+		case e == nil:
+			continue
+		case name == "END" && value == "VEVENT":
+			if e.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: event %q has no start", n+1, e.UID)
+			}
+			if e.Stop.IsZero() {
+				switch {
+				case duration > 0:
+					e.Stop = e.Start.Add(duration)
+				case e.AllDay:
+					e.Stop = e.Start.AddDate(0, 0, 1)
+				default:
+					e.Stop = e.Start
+				}
+			}
+			rv = append(rv, *e)
+			e = nil
+		case name == "UID":
+			e.UID = unescapeText(value)
+		case name == "SUMMARY":
+			e.Summary = unescapeText(value)
+		case name == "DESCRIPTION":
+			e.Description = unescapeText(value)
+		case name == "CATEGORIES":
+			for _, c := range strings.Split(value, ",") {
+				e.Categories = append(e.Categories, unescapeText(strings.TrimSpace(c)))
+			}
+		case name == "DTSTART", name == "DTEND":
+			t, allDay, err := parseICSTime(value, params, loc)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", n+1, name, err)
+			}
+			if name == "DTSTART" {
+				e.Start, e.AllDay = t, allDay
+			} else {
+				e.Stop = t
+			}
+		case name == "DURATION":
+			if duration, err = parseICSDuration(value); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+		case name == "RRULE":
+			e.RRule = value
+		case name == "RDATE", name == "EXDATE":
+			for _, v := range strings.Split(value, ",") {
+				// A period uses the length of the event
+				v = strings.SplitN(v, "/", 2)[0]
+				t, _, err := parseICSTime(v, params, loc)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s: %w", n+1, name, err)
+				}
+				if name == "RDATE" {
+					e.RDates = append(e.RDates, t)
+				} else {
+					e.ExDates = append(e.ExDates, t)
+				}
+			}
+		}
+	}
+	return rv, nil
+}
+
+// LoadCalendar reads the calendar at src, which is a local file or an http(s)
+// URL. Times without a time zone are in loc.
+func LoadCalendar(ctx context.Context, src string, loc *time.Location) ([]Event, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ReadCalendar(f, loc)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", src, resp.Status)
+	}
+	return ReadCalendar(resp.Body, loc)
+}
+
+// Tags returns the actions the event has for device: ActionBlock, ActionRun or both
+func (e Event) Tags(device string) []string {
+	var rv []string
+	add := func(action, dev string) {
+		if dev != device && dev != "*" {
+			return
+		}
+		for _, a := range rv {
+			if a == action {
+				return
+			}
+		}
+		rv = append(rv, action)
+	}
+	for _, c := range e.Categories {
+		if parts := strings.SplitN(c, ":", 2); len(parts) == 2 && (parts[0] == ActionBlock || parts[0] == ActionRun) {
+			add(parts[0], parts[1])
+		}
+	}
+	for _, m := range tagPattern.FindAllStringSubmatch(e.Summary+"\n"+e.Description, -1) {
+		add(m[1], m[2])
+	}
+	return rv
+}
+
+// Intervals returns the periods of the events tagged for device to be blocked
+// and to run, each compacted
+func Intervals(events []Event, device string) (blocked, run sch.Schedule) {
+	for _, e := range events {
+		if !e.Stop.After(e.Start) {
+			continue
+		}
+		for _, action := range e.Tags(device) {
+			entry := sch.Entry{Start: e.Start, Stop: e.Stop}
+			if action == ActionBlock {
+				blocked = append(blocked, entry)
+			} else {
+				run = append(run, entry)
+			}
+		}
+	}
+	if blocked != nil {
+		blocked = Compact(blocked)
+	}
+	if run != nil {
+		run = Compact(run)
+	}
+	return blocked, run
+}
+
+// Overlapping returns a function telling if a slot overlaps any entry of s
+func Overlapping(s sch.Schedule) func(Slot) bool {
+	return func(slot Slot) bool {
+		for _, e := range s {
+			if e.Start.Before(slot.End()) && e.Stop.After(slot.Start) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// unfoldLines reads the content lines of a calendar, joining folded lines
+func unfoldLines(r io.Reader) ([]string, error) {
+	var rv []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		l := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(rv) > 0 {
+			rv[len(rv)-1] += l[1:]
+			continue
+		}
+		rv = append(rv, l)
+	}
+	return rv, scanner.Err()
+}
+
+// splitContentLine splits a content line like "DTSTART;TZID=Europe/Copenhagen:20220701T120000"
+// into its name, parameters and value
+func splitContentLine(l string) (string, map[string]string, string) {
+	// Parameter values may be quoted, and contain colons
+	colon, quoted := -1, false
+	for i, r := range l {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return strings.ToUpper(l), nil, ""
+	}
+	parts := strings.Split(l[:colon], ";")
+	params := make(map[string]string, len(parts)-1)
+	for _, p := range parts[1:] {
+		if kv := strings.SplitN(p, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, l[colon+1:]
+}
+
+// parseICSTime parses a DATE or DATE-TIME value, and returns true if it's a date
+func parseICSTime(value string, params map[string]string, loc *time.Location) (time.Time, bool, error) {
+	if tz := params["TZID"]; tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		loc = l
+	}
+	if params["VALUE"] == "DATE" || len(value) == len(icsDate) {
+		t, err := time.ParseInLocation(icsDate, value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icsTime, value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation(strings.TrimSuffix(icsTime, "Z"), value, loc)
+	return t, false, err
+}
+
+// icsDurationPattern matches a positive duration like "P1DT2H30M" or "P1W"
+var icsDurationPattern = regexp.MustCompile(`^\+?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseICSDuration parses a DURATION value
+func parseICSDuration(value string) (time.Duration, error) {
+	m := icsDurationPattern.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var rv time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(m[i+1])
+		rv += time.Duration(n) * unit
+	}
+	return rv, nil
+}
+
+// unescapeText reverses escapeText
+func unescapeText(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(s)
+}
@@ -0,0 +1,254 @@
+package schellydule
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+const testCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:party\r\n" +
+	"DTSTART;TZID=Europe/Copenhagen:20220701T180000\r\n" +
+	"DTEND;TZID=Europe/Copenhagen:20220701T230000\r\n" +
+	"SUMMARY:Garden party #block:pool\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:sauna\r\n" +
+	"DTSTART:20220701T150000Z\r\n" +
+	"DURATION:PT2H\r\n" +
+	"SUMMARY:Sauna\r\n" +
+	"CATEGORIES:run:sauna,family\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:holiday\r\n" +
+	"DTSTART;VALUE=DATE:20220702\r\n" +
+	"SUMMARY:Away\r\n" +
+	"DESCRIPTION:Nobody home\\, so block:* for the\r\n" +
+	"  whole day\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestReadCalendar(t *testing.T) {
+	cph, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadCalendar(strings.NewReader(testCalendar), cph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{
+			UID:     "party",
+			Start:   time.Date(2022, 7, 1, 18, 0, 0, 0, cph),
+			Stop:    time.Date(2022, 7, 1, 23, 0, 0, 0, cph),
+			Summary: "Garden party #block:pool",
+		},
+		{
+			UID:        "sauna",
+			Start:      time.Date(2022, 7, 1, 15, 0, 0, 0, time.UTC),
+			Stop:       time.Date(2022, 7, 1, 17, 0, 0, 0, time.UTC),
+			Summary:    "Sauna",
+			Categories: []string{"run:sauna", "family"},
+		},
+		{
+			UID:         "holiday",
+			Start:       time.Date(2022, 7, 2, 0, 0, 0, 0, cph),
+			Stop:        time.Date(2022, 7, 3, 0, 0, 0, 0, cph),
+			AllDay:      true,
+			Summary:     "Away",
+			Description: "Nobody home, so block:* for the whole day",
+		},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("ReadCalendar() got %d events, want %d", len(events), len(want))
+	}
+	for i := range want {
+		if !events[i].Start.Equal(want[i].Start) || !events[i].Stop.Equal(want[i].Stop) {
+			t.Errorf("event %s: %s - %s, want %s - %s", want[i].UID, events[i].Start, events[i].Stop, want[i].Start, want[i].Stop)
+		}
+		events[i].Start, events[i].Stop = want[i].Start, want[i].Stop
+		if !reflect.DeepEqual(events[i], want[i]) {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+func TestIntervals(t *testing.T) {
+	cph, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := ReadCalendar(strings.NewReader(testCalendar), cph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		device  string
+		blocked sch.Schedule
+		run     sch.Schedule
+	}{
+		{
+			device: "pool",
+			blocked: sch.Schedule{
+				{Start: events[0].Start, Stop: events[0].Stop},
+				{Start: events[2].Start, Stop: events[2].Stop},
+			},
+		},
+		{
+			device:  "sauna",
+			blocked: sch.Schedule{{Start: events[2].Start, Stop: events[2].Stop}},
+			run:     sch.Schedule{{Start: events[1].Start, Stop: events[1].Stop}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			blocked, run := Intervals(events, tt.device)
+			if !reflect.DeepEqual(blocked, tt.blocked) {
+				t.Errorf("Intervals() blocked = %v, want %v", blocked, tt.blocked)
+			}
+			if !reflect.DeepEqual(run, tt.run) {
+				t.Errorf("Intervals() run = %v, want %v", run, tt.run)
+			}
+		})
+	}
+}
+
+func TestLoadCalendar(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/family.ics" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(testCalendar))
+	}))
+	defer srv.Close()
+	file := filepath.Join(t.TempDir(), "family.ics")
+	if err := ioutil.WriteFile(file, []byte(testCalendar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		src     string
+		wantErr bool
+	}{
+		{name: "file", src: file},
+		{name: "url", src: srv.URL + "/family.ics"},
+		{name: "missing file", src: file + ".missing", wantErr: true},
+		{name: "not found", src: srv.URL + "/other.ics", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := LoadCalendar(context.Background(), tt.src, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadCalendar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(events) != 3 {
+				t.Errorf("LoadCalendar() got %d events, want 3", len(events))
+			}
+		})
+	}
+}
+
+func TestEvent_Occurrences(t *testing.T) {
+	cph, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2022-07-01 is a Friday
+	at := func(day, hour int) time.Time { return time.Date(2022, 7, day, hour, 0, 0, 0, cph) }
+	tests := []struct {
+		name    string
+		rule    string
+		exdates []time.Time
+		rdates  []time.Time
+		from    time.Time
+		to      time.Time
+		want    []int
+		wantErr bool
+	}{
+		{name: "not recurring", from: at(1, 0), to: at(8, 0), want: []int{1}},
+		{name: "daily", rule: "FREQ=DAILY", from: at(3, 0), to: at(6, 0), want: []int{3, 4, 5}},
+		{name: "every other day", rule: "FREQ=DAILY;INTERVAL=2", from: at(1, 0), to: at(8, 0), want: []int{1, 3, 5, 7}},
+		{name: "count", rule: "FREQ=DAILY;COUNT=3", from: at(1, 0), to: at(8, 0), want: []int{1, 2, 3}},
+		{name: "until", rule: "FREQ=DAILY;UNTIL=20220703T235959Z", from: at(1, 0), to: at(8, 0), want: []int{1, 2, 3}},
+		{name: "exdate", rule: "FREQ=DAILY;COUNT=3", exdates: []time.Time{at(2, 18)}, from: at(1, 0), to: at(8, 0), want: []int{1, 3}},
+		{name: "rdate", rdates: []time.Time{at(5, 18)}, from: at(1, 0), to: at(8, 0), want: []int{1, 5}},
+		{name: "weekly", rule: "FREQ=WEEKLY", from: at(1, 0), to: at(20, 0), want: []int{1, 8, 15}},
+		{name: "weekdays", rule: "FREQ=WEEKLY;BYDAY=MO,WE,FR", from: at(1, 0), to: at(11, 0), want: []int{1, 4, 6, 8}},
+		{name: "weekdays every other week", rule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", from: at(1, 0), to: at(20, 0), want: []int{1, 11, 15}},
+		{name: "daily on weekends", rule: "FREQ=DAILY;BYDAY=SA,SU", from: at(1, 0), to: at(11, 0), want: []int{2, 3, 9, 10}},
+		{name: "monthly", rule: "FREQ=MONTHLY", from: at(1, 0), to: at(8, 0), wantErr: true},
+		{name: "by month", rule: "FREQ=DAILY;BYMONTH=7", from: at(1, 0), to: at(8, 0), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := Event{UID: "swim", Start: at(1, 18), Stop: at(1, 20), RRule: tt.rule, RDates: tt.rdates, ExDates: tt.exdates}
+			got, err := e.Occurrences(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Occurrences() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var days []int
+			for _, o := range got {
+				if o.Stop.Sub(o.Start) != 2*time.Hour || o.Start.Hour() != 18 || o.RRule != "" {
+					t.Errorf("Occurrences() = %+v, want a plain event 18:00-20:00", o)
+				}
+				days = append(days, o.Start.Day())
+			}
+			if !reflect.DeepEqual(days, tt.want) {
+				t.Errorf("Occurrences() on days %v, want %v", days, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCalendar_Recurrence(t *testing.T) {
+	cal := "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:swim\r\n" +
+		"DTSTART:20220701T160000Z\r\n" +
+		"DTEND:20220701T180000Z\r\n" +
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,FR\r\n" +
+		"RDATE:20220706T160000Z,20220707T160000Z\r\n" +
+		"EXDATE:20220704T160000Z\r\n" +
+		"SUMMARY:Swimming #block:pool\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	events, err := ReadCalendar(strings.NewReader(cal), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("ReadCalendar() got %d events, want 1", len(events))
+	}
+	at := func(day int) time.Time { return time.Date(2022, 7, day, 16, 0, 0, 0, time.UTC) }
+	e := events[0]
+	if e.RRule != "FREQ=WEEKLY;BYDAY=MO,FR" || !reflect.DeepEqual(e.RDates, []time.Time{at(6), at(7)}) || !reflect.DeepEqual(e.ExDates, []time.Time{at(4)}) {
+		t.Errorf("ReadCalendar() = %+v, want the RRULE, RDATE and EXDATE", e)
+	}
+	occurrences, err := e.Occurrences(at(1), at(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked, _ := Intervals(occurrences, "pool")
+	want := sch.Schedule{
+		{Start: at(1), Stop: at(1).Add(2 * time.Hour)},
+		{Start: at(6), Stop: at(6).Add(2 * time.Hour)},
+		{Start: at(7), Stop: at(7).Add(2 * time.Hour)},
+		{Start: at(8), Stop: at(8).Add(2 * time.Hour)},
+	}
+	if !reflect.DeepEqual(blocked, want) {
+		t.Errorf("Intervals() = %v, want %v", blocked, want)
+	}
+}
@@ -0,0 +1,362 @@
+// Package jobs runs and tracks background jobs, like retrying a schedule
+// renewal until the power prices are available
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/errors"
+)
+
+// keepFinished is how long finished jobs are kept
+const keepFinished = 7 * 24 * time.Hour
+
+var (
+	// ErrNotFound is returned for unknown job IDs
+	ErrNotFound = errors.New("no such job")
+	// ErrFinished is returned when cancelling a job that has already finished
+	ErrFinished = errors.New("job has already finished")
+	// ErrUnknownKind is returned when submitting a job of a kind without a runner
+	ErrUnknownKind = errors.New("unknown kind of job")
+)
+
+// State is the state of a job
+type State string
+
+const (
+	// StateRunning means an attempt is running
+	StateRunning State = "running"
+	// StateWaiting means the job waits for the next attempt
+	StateWaiting State = "waiting"
+	// StateSucceeded means an attempt succeeded
+	StateSucceeded State = "succeeded"
+	// StateFailed means the job failed, and won't be attempted again
+	StateFailed State = "failed"
+	// StateCancelled means the job was cancelled
+	StateCancelled State = "cancelled"
+)
+
+// Job is a job and its progress
+type Job struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Device string `json:"device"`
+	// Target is what the job acts on for the device, like the IP of a Shelly
+	Target string `json:"target,omitempty"`
+	// Params are what the runner needs to run the job, also after a restart
+	Params      map[string]string `json:"params,omitempty"`
+	State       State             `json:"state"`
+	Attempts    int               `json:"attempts"`
+	MaxAttempts int               `json:"max_attempts"`
+	Interval    time.Duration     `json:"interval"`
+	LastError   string            `json:"last_error,omitempty"`
+	NextAttempt *time.Time        `json:"next_attempt,omitempty"`
+	Created     time.Time         `json:"created"`
+	Updated     time.Time         `json:"updated"`
+}
+
+// Finished returns true if the job won't be attempted again
+func (j Job) Finished() bool {
+	return j.State == StateSucceeded || j.State == StateFailed || j.State == StateCancelled
+}
+
+// Runner makes an attempt at running j
+type Runner func(ctx context.Context, j Job) error
+
+type kind struct {
+	run Runner
+	// retry tells if the job should be attempted again after err
+	retry func(err error) bool
+}
+
+// Manager runs jobs, and persists them so they survive a restart
+type Manager struct {
+	mu       sync.Mutex
+	filename string
+	jobs     map[string]*Job
+	cancels  map[string]context.CancelFunc
+	kinds    map[string]kind
+}
+
+// Open returns a manager persisted in filename, reading any existing jobs.
+// Unfinished jobs are continued by Resume.
+func Open(filename string) (*Manager, error) {
+	m := &Manager{
+		filename: filename,
+		jobs:     make(map[string]*Job),
+		cancels:  make(map[string]context.CancelFunc),
+		kinds:    make(map[string]kind),
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.jobs); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Register sets the runner of jobs of kind k. A failed attempt is retried if
+// retry returns true for the error.
+func (m *Manager) Register(k string, run Runner, retry func(err error) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kinds[k] = kind{run: run, retry: retry}
+}
+
+// Submit makes the first attempt at a job of kind k for device and target, and
+// keeps retrying it in the background every interval, for at most maxAttempts
+// attempts. The job is returned as it is after the first attempt. If an
+// unfinished job of the same kind already exists for device and target, that
+// job is returned instead, and created is false.
+func (m *Manager) Submit(k, device, target string, params map[string]string, maxAttempts int, interval time.Duration) (job Job, created bool, err error) {
+	m.mu.Lock()
+	if _, ok := m.kinds[k]; !ok {
+		m.mu.Unlock()
+		return Job{}, false, fmt.Errorf("%w: %s", ErrUnknownKind, k)
+	}
+	for _, j := range m.jobs {
+		if j.Kind == k && j.Device == device && j.Target == target && !j.Finished() {
+			m.mu.Unlock()
+			return *j, false, nil
+		}
+	}
+	id, err := newID()
+	if err != nil {
+		m.mu.Unlock()
+		return Job{}, false, err
+	}
+	now := time.Now()
+	j := &Job{
+		ID:          id,
+		Kind:        k,
+		Device:      device,
+		Target:      target,
+		Params:      params,
+		State:       StateRunning,
+		MaxAttempts: maxAttempts,
+		Interval:    interval,
+		Created:     now,
+		Updated:     now,
+	}
+	m.jobs[id] = j
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancels[id] = cancel
+	m.mu.Unlock()
+
+	if m.attempt(ctx, id) {
+		go m.loop(ctx, id)
+	}
+	return m.snapshot(id), true, nil
+}
+
+// Resume continues the unfinished jobs read by Open. Runners must be
+// registered first.
+func (m *Manager) Resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, j := range m.jobs {
+		if j.Finished() {
+			continue
+		}
+		if _, ok := m.kinds[j.Kind]; !ok {
+			m.finish(j, StateFailed, ErrUnknownKind)
+			continue
+		}
+		// A job that was running when we stopped is attempted again right away
+		if j.State == StateRunning {
+			now := time.Now()
+			j.State, j.NextAttempt = StateWaiting, &now
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		m.cancels[id] = cancel
+		go m.loop(ctx, id)
+	}
+	m.saveLocked()
+}
+
+// Get returns the job with id
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// List returns all jobs, newest first
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rv := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		rv = append(rv, *j)
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Created.After(rv[j].Created) })
+	return rv
+}
+
+// Cancel stops the job with id. A running attempt is cancelled through its context.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if j.Finished() {
+		return *j, ErrFinished
+	}
+	if cancel, ok := m.cancels[id]; ok {
+		cancel()
+		delete(m.cancels, id)
+	}
+	m.finish(j, StateCancelled, nil)
+	m.saveLocked()
+	return *j, nil
+}
+
+// loop attempts the job with id at its next attempt time, until it finishes
+func (m *Manager) loop(ctx context.Context, id string) {
+	for {
+		m.mu.Lock()
+		j, ok := m.jobs[id]
+		if !ok || j.Finished() || j.NextAttempt == nil {
+			m.mu.Unlock()
+			return
+		}
+		wait := time.Until(*j.NextAttempt)
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		m.mu.Lock()
+		if j.Finished() {
+			m.mu.Unlock()
+			return
+		}
+		j.State, j.Updated = StateRunning, time.Now()
+		m.mu.Unlock()
+		if !m.attempt(ctx, id) {
+			return
+		}
+	}
+}
+
+// attempt runs the job with id once, and returns true if it should be attempted again
+func (m *Manager) attempt(ctx context.Context, id string) bool {
+	m.mu.Lock()
+	j := m.jobs[id]
+	k := m.kinds[j.Kind]
+	j.Attempts++
+	j.NextAttempt = nil
+	snapshot := *j
+	m.mu.Unlock()
+
+	err := k.run(ctx, snapshot)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.saveLocked()
+	switch {
+	case j.Finished():
+		// Cancelled while running
+		return false
+	case err == nil:
+		m.finish(j, StateSucceeded, nil)
+		return false
+	case k.retry == nil || !k.retry(err) || j.Attempts >= j.MaxAttempts:
+		m.finish(j, StateFailed, err)
+		return false
+	}
+	next := time.Now().Add(j.Interval)
+	j.State, j.LastError, j.NextAttempt, j.Updated = StateWaiting, err.Error(), &next, time.Now()
+	return true
+}
+
+// finish sets the final state of j. Must be called with the lock held.
+func (m *Manager) finish(j *Job, state State, err error) {
+	j.State, j.NextAttempt, j.Updated = state, nil, time.Now()
+	if err != nil {
+		j.LastError = err.Error()
+	}
+	if cancel, ok := m.cancels[j.ID]; ok {
+		cancel()
+		delete(m.cancels, j.ID)
+	}
+}
+
+func (m *Manager) snapshot(id string) Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.jobs[id]
+}
+
+// saveLocked writes the jobs to disk, dropping jobs finished more than
+// keepFinished ago. Must be called with the lock held. Errors are only logged,
+// since losing the jobs means they aren't resumed, but nothing else.
+func (m *Manager) saveLocked() {
+	if m.filename == "" {
+		return
+	}
+	oldest := time.Now().Add(-keepFinished)
+	for id, j := range m.jobs {
+		if j.Finished() && j.Updated.Before(oldest) {
+			delete(m.jobs, id)
+		}
+	}
+	if err := m.write(); err != nil {
+		log.Printf("error saving jobs: %s", err)
+	}
+}
+
+// write writes the jobs to disk. Must be called with the lock held.
+func (m *Manager) write() error {
+	data, err := json.Marshal(m.jobs)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so a crash doesn't leave a broken file
+	tmp, err := ioutil.TempFile(filepath.Dir(m.filename), filepath.Base(m.filename))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.filename)
+}
+
+// newID returns a random job ID
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/errors"
+)
+
+var errTemporary = errors.New("temporary")
+
+func isTemporary(err error) bool {
+	return errors.Is(err, errTemporary)
+}
+
+// waitFor waits until the job with id has finished
+func waitFor(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, ok := m.Get(id); ok && j.Finished() {
+			return j
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s didn't finish", id)
+	return Job{}
+}
+
+func TestManager_Submit(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int32
+		err          error
+		maxAttempts  int
+		wantState    State
+		wantAttempts int
+	}{
+		{name: "first attempt succeeds", wantState: StateSucceeded, wantAttempts: 1, maxAttempts: 3},
+		{name: "retried until it succeeds", failures: 2, err: errTemporary, maxAttempts: 3, wantState: StateSucceeded, wantAttempts: 3},
+		{name: "gives up", failures: 5, err: errTemporary, maxAttempts: 3, wantState: StateFailed, wantAttempts: 3},
+		{name: "permanent error", failures: 1, err: errors.New("permanent"), maxAttempts: 3, wantState: StateFailed, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Open(filepath.Join(t.TempDir(), "jobs.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var calls int32
+			m.Register("renew", func(ctx context.Context, j Job) error {
+				if atomic.AddInt32(&calls, 1) <= tt.failures {
+					return tt.err
+				}
+				return nil
+			}, isTemporary)
+			j, created, err := m.Submit("renew", "pool", "", nil, tt.maxAttempts, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !created {
+				t.Error("Submit() didn't create a job")
+			}
+			got := waitFor(t, m, j.ID)
+			if got.State != tt.wantState {
+				t.Errorf("state = %s, want %s", got.State, tt.wantState)
+			}
+			if got.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got.Attempts, tt.wantAttempts)
+			}
+			if tt.wantState == StateFailed && got.LastError == "" {
+				t.Error("failed job has no error")
+			}
+		})
+	}
+}
+
+func TestManager_Dedupe(t *testing.T) {
+	m, err := Open(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Register("renew", func(ctx context.Context, j Job) error { return errTemporary }, isTemporary)
+	first, _, err := m.Submit("renew", "pool", "", nil, 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.State != StateWaiting || first.NextAttempt == nil {
+		t.Errorf("first job is %s, want waiting with a next attempt", first.State)
+	}
+	second, created, err := m.Submit("renew", "pool", "", nil, 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || second.ID != first.ID {
+		t.Errorf("Submit() created a duplicate job %s of %s", second.ID, first.ID)
+	}
+	other, created, err := m.Submit("renew", "heatpump", "", nil, 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || other.ID == first.ID {
+		t.Error("Submit() didn't create a job for another device")
+	}
+	target, created, err := m.Submit("renew", "pool", "192.168.1.20", nil, 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || target.ID == first.ID {
+		t.Error("Submit() didn't create a job for another target")
+	}
+}
+
+func TestManager_Cancel(t *testing.T) {
+	m, err := Open(filepath.Join(t.TempDir(), "jobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Register("renew", func(ctx context.Context, j Job) error { return errTemporary }, isTemporary)
+	j, _, err := m.Submit("renew", "pool", "", nil, 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Cancel(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != StateCancelled || got.NextAttempt != nil {
+		t.Errorf("Cancel() state = %s, want cancelled without a next attempt", got.State)
+	}
+	if _, err := m.Cancel(j.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("Cancel() twice error = %v, want %v", err, ErrFinished)
+	}
+	if _, err := m.Cancel("nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Cancel() unknown error = %v, want %v", err, ErrNotFound)
+	}
+	// A new job can be submitted once the old one is cancelled
+	if _, created, _ := m.Submit("renew", "pool", "", nil, 100, time.Hour); !created {
+		t.Error("Submit() after Cancel() didn't create a job")
+	}
+}
+
+func TestManager_Resume(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "jobs.json")
+	m, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Register("renew", func(ctx context.Context, j Job) error { return errTemporary }, isTemporary)
+	j, _, err := m.Submit("renew", "pool", "", map[string]string{"query": "hours=4"}, 100, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Cancel(j.ID)
+	waiting, _, err := m.Submit("renew", "heatpump", "", map[string]string{"query": "hours=4"}, 100, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restart
+	m2, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params string
+	m2.Register("renew", func(ctx context.Context, j Job) error {
+		params = j.Params["query"]
+		return nil
+	}, isTemporary)
+	m2.Resume()
+	got := waitFor(t, m2, waiting.ID)
+	if got.State != StateSucceeded {
+		t.Errorf("resumed job state = %s, want succeeded", got.State)
+	}
+	if params != "hours=4" {
+		t.Errorf("resumed job params = %q, want %q", params, "hours=4")
+	}
+	if c, _ := m2.Get(j.ID); c.State != StateCancelled {
+		t.Errorf("cancelled job state = %s after restart, want cancelled", c.State)
+	}
+	m.Cancel(waiting.ID)
+}
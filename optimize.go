@@ -0,0 +1,457 @@
+package schellydule
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/adamhassel/errors"
+	sch "github.com/adamhassel/schedule"
+)
+
+// ErrInfeasible is returned when no schedule satisfies the requirements
+var ErrInfeasible = errors.New("no schedule satisfies the requirements")
+
+// Slot is a priced interval that the appliance can be scheduled to run in
+type Slot struct {
+	Start  time.Time
+	Length time.Duration
+	// Price is the price per kWh in the slot
+	Price float64
+	// CO2 is the grams of CO2 emitted per kWh in the slot, if known
+	CO2 float64
+}
+
+// End returns the time the slot ends
+func (s Slot) End() time.Time {
+	return s.Start.Add(s.Length)
+}
+
+// Cost returns the cost of running a 1 kW load for the length of the slot
+func (s Slot) Cost() float64 {
+	return s.Price * s.Length.Hours()
+}
+
+// Slots is a list of slots, sorted by start time
+type Slots []Slot
+
+// SlotsFromHourPrices converts a list of prices to Slots. The slot length is
+// the shortest time between two prices, and at most an hour, so prices every
+// 15 minutes give 15 minute slots.
+func SlotsFromHourPrices(hp sch.HourPrices) Slots {
+	rv := make(Slots, 0, len(hp))
+	for _, h := range hp {
+		rv = append(rv, Slot{Start: h.Hour, Price: h.Price})
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Start.Before(rv[j].Start) })
+	length := time.Hour
+	for i := 1; i < len(rv); i++ {
+		if d := rv[i].Start.Sub(rv[i-1].Start); d > 0 && d < length {
+			length = d
+		}
+	}
+	for i := range rv {
+		rv[i].Length = length
+	}
+	return rv
+}
+
+// Split returns s with every slot split into slots of length l, priced as the
+// slot they're part of, and with its CO2 intensity. Slots that are not longer than l are kept as they are.
+func (s Slots) Split(l time.Duration) Slots {
+	if l <= 0 {
+		return s
+	}
+	rv := make(Slots, 0, len(s))
+	for _, e := range s {
+		if e.Length <= l {
+			rv = append(rv, e)
+			continue
+		}
+		for t := e.Start; t.Before(e.End()); t = t.Add(l) {
+			length := l
+			if end := e.End(); t.Add(l).After(end) {
+				length = end.Sub(t)
+			}
+			rv = append(rv, Slot{Start: t, Length: length, Price: e.Price, CO2: e.CO2})
+		}
+	}
+	return rv
+}
+
+// Join returns s with the slots shorter than l joined into slots of length l,
+// counted from midnight, priced at the average of the slots they're made of.
+// Slots that are not shorter than l are kept as they are.
+func (s Slots) Join(l time.Duration) Slots {
+	if l <= 0 {
+		return s
+	}
+	rv := make(Slots, 0, len(s))
+	for _, e := range s {
+		if e.Length >= l {
+			rv = append(rv, e)
+			continue
+		}
+		start := slotAt(e.Start, l).Start
+		if last := len(rv) - 1; last >= 0 && rv[last].Start.Equal(start) && rv[last].Length < l {
+			j := &rv[last]
+			total := j.Length + e.Length
+			j.Price = (j.Price*j.Length.Hours() + e.Price*e.Length.Hours()) / total.Hours()
+			j.CO2 = (j.CO2*j.Length.Hours() + e.CO2*e.Length.Hours()) / total.Hours()
+			j.Length = total
+			continue
+		}
+		e.Start = start
+		rv = append(rv, e)
+	}
+	return rv
+}
+
+// In returns a copy of s with the start times in loc
+func (s Slots) In(loc *time.Location) Slots {
+	rv := make(Slots, len(s))
+	for i, e := range s {
+		e.Start = e.Start.In(loc)
+		rv[i] = e
+	}
+	return rv
+}
+
+// Cost returns the combined cost of the slots
+func (s Slots) Cost() float64 {
+	var rv float64
+	for _, e := range s {
+		rv += e.Cost()
+	}
+	return rv
+}
+
+// Schedule merges adjacent slots into schedule entries
+func (s Slots) Schedule() sch.Schedule {
+	var rv = make(sch.Schedule, 0, len(s))
+	for _, e := range s {
+		if l := len(rv) - 1; l >= 0 && rv[l].Stop.Equal(e.Start) {
+			rv[l].Stop = e.End()
+			rv[l].Cost += e.Cost()
+			continue
+		}
+		rv = append(rv, sch.Entry{Start: e.Start, Stop: e.End(), Cost: e.Cost()})
+	}
+	return rv
+}
+
+// Plan returns a plan for running in the slots
+func (s Slots) Plan() Plan {
+	return Plan{Schedule: s.Schedule(), Cost: s.Cost()}
+}
+
+// Constraints limit how selected slots may be laid out
+type Constraints struct {
+	// MinRun is the minimum length of a continuous run
+	MinRun time.Duration
+	// MinOff is the minimum time off between two runs
+	MinOff time.Duration
+	// MaxStarts is the maximum number of runs. Zero means no limit
+	MaxStarts int
+	// MaxGap is the maximum time off between runs, including before the first
+	// and after the last run. Zero means no limit
+	MaxGap time.Duration
+	// MaxDark is the maximum runtime between sunset and sunrise. Only enforced if Dark is set
+	MaxDark time.Duration
+	// Dark returns true if t is between sunset and sunrise
+	Dark func(t time.Time) bool
+	// Blocked returns true if the slot must not be selected, and Forced if it
+	// must. Blocked wins if both are true.
+	Blocked func(s Slot) bool
+	Forced  func(s Slot) bool
+}
+
+// Active returns true if c constrains the run layout
+func (c Constraints) Active() bool {
+	return c.MinRun > 0 || c.MinOff > 0 || c.MaxStarts > 0 || c.MaxGap > 0 || c.Blocked != nil || c.Forced != nil
+}
+
+// blocked returns true if c doesn't allow selecting slot
+func (c Constraints) blocked(slot Slot) bool {
+	return c.Blocked != nil && c.Blocked(slot)
+}
+
+// forced returns true if c requires selecting slot
+func (c Constraints) forced(slot Slot) bool {
+	return c.Forced != nil && c.Forced(slot) && !c.blocked(slot)
+}
+
+// Allowed returns the slots in s that c doesn't block
+func (s Slots) Allowed(c Constraints) Slots {
+	rv := make(Slots, 0, len(s))
+	for _, e := range s {
+		if !c.blocked(e) {
+			rv = append(rv, e)
+		}
+	}
+	return rv
+}
+
+// Unblocked returns how much of length from at runs before the first slot c
+// blocks. Slots are slot long, counted from midnight.
+func (c Constraints) Unblocked(at time.Time, length, slot time.Duration) time.Duration {
+	end := at.Add(length)
+	t := at
+	for t.Before(end) {
+		s := slotAt(t, slot)
+		if c.blocked(s) {
+			break
+		}
+		t = s.End()
+	}
+	if t.After(end) {
+		t = end
+	}
+	return t.Sub(at)
+}
+
+// slotAt returns the slot of length `length` that t is in, counted from
+// midnight
+func slotAt(t time.Time, length time.Duration) Slot {
+	t = t.Truncate(time.Second)
+	since := sinceMidnight(t)
+	return Slot{Start: t.Add(since/length*length - since), Length: length}
+}
+
+// Forced returns the number of slots in s that c requires selecting
+func (s Slots) Forced(c Constraints) int {
+	n := 0
+	for _, e := range s {
+		if c.forced(e) {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan is a generated schedule, and what it costs
+type Plan struct {
+	Schedule sch.Schedule
+	// Cost is the cost of running a 1 kW load according to the schedule
+	Cost float64
+	// Premium is the extra cost the run constraints added, compared to simply
+	// picking the cheapest slots
+	Premium float64
+}
+
+// Cheapest returns a plan with the n cheapest slots in s that satisfy c.
+func (s Slots) Cheapest(n int, c Constraints) (Plan, error) {
+	selected, err := optimize(s, n, c)
+	if err != nil {
+		return Plan{}, err
+	}
+	rv := selected.Plan()
+	if !c.Active() {
+		return rv, nil
+	}
+	baseline, err := optimize(s, n, Constraints{MaxDark: c.MaxDark, Dark: c.Dark, Blocked: c.Blocked, Forced: c.Forced})
+	if err != nil {
+		return Plan{}, err
+	}
+	rv.Premium = rv.Cost - baseline.Cost()
+	return rv, nil
+}
+
+// optimize finds the cheapest selection of n slots satisfying c, using dynamic
+// programming over the slots. The state tracks the number of slots selected,
+// runs started, dark slots selected and a "phase", which is either the initial
+// state (nothing selected yet), the length of the current run, the length of
+// the current pause or, if there's a maximum gap, the time since the start
+// without running. Lengths are capped at what the constraints require.
+func optimize(s Slots, n int, c Constraints) (Slots, error) {
+	if n <= 0 {
+		return Slots{}, nil
+	}
+	if n > len(s) {
+		return nil, fmt.Errorf("%w: cannot select %d slots from %d available", ErrInfeasible, n, len(s))
+	}
+	length := s[0].Length
+	runPhases := SlotCount(c.MinRun, length)
+	if runPhases < 1 {
+		runPhases = 1
+	}
+	offSlots := SlotCount(c.MinOff, length)
+	gapSlots := int(c.MaxGap / length)
+	if c.MaxGap > 0 && gapSlots < offSlots {
+		return nil, fmt.Errorf("%w: maximum gap is shorter than the minimum time off", ErrInfeasible)
+	}
+	offPhases := offSlots
+	if gapSlots > offPhases {
+		offPhases = gapSlots
+	}
+	if offPhases < 1 {
+		offPhases = 1
+	}
+	// Phases are laid out as: initial, run lengths, pause lengths, lead lengths
+	leadStart := 1 + runPhases + offPhases
+	leadPhases := 0
+	if c.MaxGap > 0 {
+		leadPhases = gapSlots
+	}
+	phases := leadStart + leadPhases
+	bdim := 1
+	if c.MaxStarts > 0 {
+		bdim = c.MaxStarts + 1
+	}
+	ddim, maxDark := 1, int(c.MaxDark/length)
+	trackDark := c.Dark != nil && maxDark < n
+	if trackDark {
+		ddim = maxDark + 1
+	}
+	size := (n + 1) * bdim * ddim * phases
+	idx := func(cnt, b, d, p int) int { return ((cnt*bdim+b)*ddim+d)*phases + p }
+
+	cur := make([]float64, size)
+	next := make([]float64, size)
+	// back holds, for each slot and resulting state, the phase we came from
+	// (shifted left by one) and whether the slot was selected (lowest bit).
+	back := make([][]uint16, len(s))
+	for i := range cur {
+		cur[i] = math.Inf(1)
+	}
+	cur[idx(0, 0, 0, 0)] = 0
+
+	for i, slot := range s {
+		for j := range next {
+			next[j] = math.Inf(1)
+		}
+		back[i] = make([]uint16, size)
+		dark := trackDark && c.Dark(slot.Start)
+		blocked, forced := c.blocked(slot), c.forced(slot)
+		for cnt := 0; cnt <= n; cnt++ {
+			for b := 0; b < bdim; b++ {
+				for d := 0; d < ddim; d++ {
+					for p := 0; p < phases; p++ {
+						v := cur[idx(cnt, b, d, p)]
+						if math.IsInf(v, 1) {
+							continue
+						}
+						relax := func(np, ncnt, nb, nd int, on bool, cost float64) {
+							j := idx(ncnt, nb, nd, np)
+							if v+cost < next[j] {
+								next[j] = v + cost
+								code := uint16(p) << 1
+								if on {
+									code |= 1
+								}
+								back[i][j] = code
+							}
+						}
+						// Leave the slot off
+						switch {
+						case forced:
+							// A forced slot can't be left off
+						case p == 0:
+							if c.MaxGap == 0 {
+								relax(0, cnt, b, d, false, 0)
+							} else if leadPhases > 0 {
+								relax(leadStart, cnt, b, d, false, 0)
+							}
+						case p <= runPhases:
+							if p == runPhases && (c.MaxGap == 0 || gapSlots > 0) {
+								relax(runPhases+1, cnt, b, d, false, 0)
+							}
+						case p < leadStart:
+							if off := p - runPhases + 1; c.MaxGap == 0 || off <= gapSlots {
+								relax(runPhases+min(off, offPhases), cnt, b, d, false, 0)
+							}
+						default:
+							if lead := p - leadStart + 2; lead <= leadPhases {
+								relax(leadStart+lead-1, cnt, b, d, false, 0)
+							}
+						}
+						// Select the slot
+						if cnt == n || blocked {
+							continue
+						}
+						nd := d
+						if dark {
+							if nd++; nd > maxDark {
+								continue
+							}
+						}
+						switch {
+						case p == 0 || p > runPhases:
+							if p > runPhases && p < leadStart && p-runPhases < offSlots {
+								continue
+							}
+							nb := b
+							if bdim > 1 {
+								if nb++; nb > c.MaxStarts {
+									continue
+								}
+							}
+							relax(1, cnt+1, nb, nd, true, slot.Cost())
+						default:
+							relax(min(p+1, runPhases), cnt+1, b, nd, true, slot.Cost())
+						}
+					}
+				}
+			}
+		}
+		cur, next = next, cur
+	}
+
+	// Find the cheapest valid end state
+	best, bestCost := -1, math.Inf(1)
+	for b := 0; b < bdim; b++ {
+		for d := 0; d < ddim; d++ {
+			for p := 0; p < phases; p++ {
+				if p > 0 && p < runPhases {
+					continue // ended in a run that's too short
+				}
+				if j := idx(n, b, d, p); cur[j] < bestCost {
+					best, bestCost = j, cur[j]
+				}
+			}
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("%w: no selection of %d slots satisfies the constraints", ErrInfeasible, n)
+	}
+
+	// Walk the back pointers to find the selected slots
+	rv := make(Slots, 0, n)
+	p := best % phases
+	d := (best / phases) % ddim
+	b := (best / phases / ddim) % bdim
+	cnt := n
+	for i := len(s) - 1; i >= 0; i-- {
+		code := back[i][idx(cnt, b, d, p)]
+		prev := int(code >> 1)
+		if code&1 == 1 {
+			rv = append(rv, s[i])
+			cnt--
+			if trackDark && c.Dark(s[i].Start) {
+				d--
+			}
+			if bdim > 1 && (prev == 0 || prev > runPhases) {
+				b--
+			}
+		}
+		p = prev
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Start.Before(rv[j].Start) })
+	return rv, nil
+}
+
+// SlotCount returns the number of slots of length l needed to cover d
+func SlotCount(d, l time.Duration) int {
+	if d <= 0 || l <= 0 {
+		return 0
+	}
+	return int((d + l - 1) / l)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
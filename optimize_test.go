@@ -0,0 +1,324 @@
+package schellydule
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+var day = time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+
+// hourSlots returns hourly slots starting at midnight with the given prices
+func hourSlots(prices ...float64) Slots {
+	rv := make(Slots, 0, len(prices))
+	for i, p := range prices {
+		rv = append(rv, Slot{Start: day.Add(time.Duration(i) * time.Hour), Length: time.Hour, Price: p})
+	}
+	return rv
+}
+
+// startHours returns the start hour of each slot
+func startHours(s Slots) []int {
+	rv := make([]int, 0, len(s))
+	for _, e := range s {
+		rv = append(rv, e.Start.Hour())
+	}
+	return rv
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       Slots
+		n       int
+		c       Constraints
+		want    []int
+		wantErr bool
+	}{
+		{
+			name: "no constraints picks the cheapest",
+			s:    hourSlots(1, 9, 1, 9, 1, 9),
+			n:    3,
+			want: []int{0, 2, 4},
+		},
+		{
+			name: "minimum run joins blocks",
+			s:    hourSlots(1, 9, 1, 2, 1, 9),
+			n:    3,
+			c:    Constraints{MinRun: 2 * time.Hour},
+			want: []int{2, 3, 4},
+		},
+		{
+			name: "minimum off keeps runs apart",
+			s:    hourSlots(1, 5, 1, 1, 8, 9),
+			n:    2,
+			c:    Constraints{MinOff: 2 * time.Hour},
+			want: []int{2, 3},
+		},
+		{
+			name: "maximum starts",
+			s:    hourSlots(1, 9, 1, 9, 2, 9),
+			n:    3,
+			c:    Constraints{MaxStarts: 1},
+			want: []int{0, 1, 2},
+		},
+		{
+			name: "run at the end must be long enough",
+			s:    hourSlots(4, 4, 9, 9, 9, 1),
+			n:    2,
+			c:    Constraints{MinRun: 2 * time.Hour},
+			want: []int{0, 1},
+		},
+		{
+			name: "dark limit",
+			s:    hourSlots(1, 2, 5, 5, 9, 9),
+			n:    3,
+			c:    Constraints{MaxDark: time.Hour, Dark: func(t time.Time) bool { return t.Hour() < 2 }},
+			want: []int{0, 2, 3},
+		},
+		{
+			name: "maximum gap",
+			s:    hourSlots(1, 1, 9, 9, 8, 9, 5, 9),
+			n:    3,
+			c:    Constraints{MaxGap: 3 * time.Hour},
+			want: []int{0, 1, 4},
+		},
+		{
+			name: "maximum gap includes the start and end of the day",
+			s:    hourSlots(1, 9, 1, 9, 9),
+			n:    2,
+			c:    Constraints{MaxGap: time.Hour},
+			want: []int{1, 3},
+		},
+		{
+			name: "blocked slots are skipped",
+			s:    hourSlots(1, 9, 1, 9, 2, 9),
+			n:    2,
+			c:    Constraints{Blocked: func(s Slot) bool { return s.Start.Hour() == 2 }},
+			want: []int{0, 4},
+		},
+		{
+			name: "forced slots are selected",
+			s:    hourSlots(1, 9, 3, 9, 2, 9),
+			n:    2,
+			c:    Constraints{Forced: func(s Slot) bool { return s.Start.Hour() == 5 }},
+			want: []int{0, 5},
+		},
+		{
+			name: "blocked wins over forced",
+			s:    hourSlots(1, 9, 3, 9, 2, 9),
+			n:    2,
+			c: Constraints{
+				Blocked: func(s Slot) bool { return s.Start.Hour() == 5 },
+				Forced:  func(s Slot) bool { return s.Start.Hour() >= 4 },
+			},
+			want: []int{0, 4},
+		},
+		{
+			name:    "maximum gap shorter than minimum off",
+			s:       hourSlots(1, 1, 1, 1),
+			n:       2,
+			c:       Constraints{MaxGap: time.Hour, MinOff: 2 * time.Hour},
+			wantErr: true,
+		},
+		{
+			name:    "impossible",
+			s:       hourSlots(1, 1, 1, 1),
+			n:       3,
+			c:       Constraints{MinRun: 2 * time.Hour, MaxStarts: 1, MinOff: time.Hour, MaxDark: 0, Dark: func(time.Time) bool { return true }},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := optimize(tt.s, tt.n, tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("optimize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if hours := startHours(got); !reflect.DeepEqual(hours, tt.want) {
+				t.Errorf("optimize() got = %v, want %v", hours, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlots_Cheapest(t *testing.T) {
+	s := hourSlots(1, 9, 1, 9, 2, 9)
+	plan, err := s.Cheapest(3, Constraints{MaxStarts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Schedule) != 1 {
+		t.Errorf("Cheapest() got %d entries, want 1", len(plan.Schedule))
+	}
+	if plan.Cost != 11 {
+		t.Errorf("Cheapest() cost = %f, want 11", plan.Cost)
+	}
+	if math.Abs(plan.Premium-7) > 1e-9 {
+		t.Errorf("Cheapest() premium = %f, want 7", plan.Premium)
+	}
+}
+
+func TestSlots_Split(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      Slots
+		l      time.Duration
+		want   int
+		starts []string
+	}{
+		{
+			name:   "quarters",
+			s:      hourSlots(1, 2),
+			l:      15 * time.Minute,
+			want:   8,
+			starts: []string{"00:00", "00:15", "00:30", "00:45", "01:00", "01:15", "01:30", "01:45"},
+		},
+		{
+			name:   "half hours",
+			s:      hourSlots(1),
+			l:      30 * time.Minute,
+			want:   2,
+			starts: []string{"00:00", "00:30"},
+		},
+		{
+			name:   "hours are kept",
+			s:      hourSlots(1, 2),
+			l:      time.Hour,
+			want:   2,
+			starts: []string{"00:00", "01:00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.s.Split(tt.l)
+			if len(got) != tt.want {
+				t.Fatalf("Split() got %d slots, want %d", len(got), tt.want)
+			}
+			starts := make([]string, 0, len(got))
+			for _, e := range got {
+				starts = append(starts, e.Start.Format("15:04"))
+			}
+			if !reflect.DeepEqual(starts, tt.starts) {
+				t.Errorf("Split() starts = %v, want %v", starts, tt.starts)
+			}
+			if math.Abs(got.Cost()-tt.s.Cost()) > 1e-9 {
+				t.Errorf("Split() cost = %f, want %f", got.Cost(), tt.s.Cost())
+			}
+		})
+	}
+}
+
+func TestOptimize_QuarterHours(t *testing.T) {
+	// The cheapest 45 minutes lie across the hour 01:00
+	s := Slots{
+		{Start: day, Length: 15 * time.Minute, Price: 9},
+		{Start: day.Add(15 * time.Minute), Length: 15 * time.Minute, Price: 9},
+		{Start: day.Add(30 * time.Minute), Length: 15 * time.Minute, Price: 5},
+		{Start: day.Add(45 * time.Minute), Length: 15 * time.Minute, Price: 1},
+		{Start: day.Add(60 * time.Minute), Length: 15 * time.Minute, Price: 1},
+		{Start: day.Add(75 * time.Minute), Length: 15 * time.Minute, Price: 1},
+		{Start: day.Add(90 * time.Minute), Length: 15 * time.Minute, Price: 8},
+		{Start: day.Add(105 * time.Minute), Length: 15 * time.Minute, Price: 2},
+	}
+	plan, err := s.Fit(SlotCount(45*time.Minute, 15*time.Minute), Constraints{MinRun: 30 * time.Minute}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Schedule) != 1 {
+		t.Fatalf("Fit() got %d entries, want 1", len(plan.Schedule))
+	}
+	e := plan.Schedule[0]
+	if got := e.Start.Format("15:04") + "-" + e.Stop.Format("15:04"); got != "00:45-01:30" {
+		t.Errorf("Fit() = %s, want 00:45-01:30", got)
+	}
+}
+
+func TestSlotsFromHourPrices(t *testing.T) {
+	quarters := make(sch.HourPrices, 0, 96)
+	for i := 0; i < 96; i++ {
+		quarters = append(quarters, sch.HourPrice{Hour: day.Add(time.Duration(i) * 15 * time.Minute), Price: float64(i % 4)})
+	}
+	tests := []struct {
+		name string
+		hp   sch.HourPrices
+		want time.Duration
+	}{
+		{name: "hours", hp: sch.HourPrices{{Hour: day.Add(time.Hour), Price: 2}, {Hour: day, Price: 1}}, want: time.Hour},
+		{name: "quarters", hp: quarters, want: 15 * time.Minute},
+		{name: "missing hours", hp: sch.HourPrices{{Hour: day, Price: 1}, {Hour: day.Add(3 * time.Hour), Price: 2}}, want: time.Hour},
+		{name: "one price", hp: sch.HourPrices{{Hour: day, Price: 1}}, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := SlotsFromHourPrices(tt.hp)
+			for i, e := range s {
+				if e.Length != tt.want {
+					t.Fatalf("SlotsFromHourPrices()[%d].Length = %s, want %s", i, e.Length, tt.want)
+				}
+				if i > 0 && e.Start.Before(s[i-1].Start) {
+					t.Fatalf("SlotsFromHourPrices() isn't sorted at %d", i)
+				}
+			}
+		})
+	}
+
+	// The first quarter of every hour is the cheapest, so an hour of runtime is
+	// laid out as four quarter hours
+	plan, err := SlotsFromHourPrices(quarters).Cheapest(4, Constraints{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := RuntimeBetween(plan.Schedule, day, day.Add(24*time.Hour)); got != time.Hour {
+		t.Errorf("Cheapest() runs %s, want 1h0m0s", got)
+	}
+	for _, e := range plan.Schedule {
+		if e.Start.Minute() != 0 || e.Stop.Sub(e.Start) != 15*time.Minute {
+			t.Errorf("Cheapest() = %s-%s, want a quarter hour on the hour", e.Start.Format("15:04"), e.Stop.Format("15:04"))
+		}
+	}
+
+	// Planning in hours averages the quarter hours
+	hours := SlotsFromHourPrices(quarters).Join(time.Hour)
+	if len(hours) != 24 {
+		t.Fatalf("Join() got %d slots, want 24", len(hours))
+	}
+	for _, e := range hours {
+		if e.Length != time.Hour || e.Start.Minute() != 0 || e.Price != 1.5 {
+			t.Errorf("Join() = %s %s at %g, want an hour on the hour at 1.5", e.Start.Format("15:04"), e.Length, e.Price)
+		}
+	}
+}
+
+func TestConstraints_Unblocked(t *testing.T) {
+	// Blocked from 02:00 to 03:00
+	night := Constraints{Blocked: func(s Slot) bool { return s.OverlapsClock(2*time.Hour, 3*time.Hour) }}
+	tests := []struct {
+		name   string
+		c      Constraints
+		at     time.Duration
+		length time.Duration
+		slot   time.Duration
+		want   time.Duration
+	}{
+		{name: "free", c: night, at: 3 * time.Hour, length: 2 * time.Hour, slot: time.Hour, want: 2 * time.Hour},
+		{name: "up to a block", c: night, at: 90 * time.Minute, length: time.Hour, slot: 15 * time.Minute, want: 30 * time.Minute},
+		{name: "in a block", c: night, at: 2 * time.Hour, length: time.Hour, slot: time.Hour, want: 0},
+		{name: "mid slot", c: night, at: 70 * time.Minute, length: 10 * time.Minute, slot: time.Hour, want: 10 * time.Minute},
+		{name: "unconstrained", at: 2 * time.Hour, length: time.Hour, slot: time.Hour, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.Unblocked(day.Add(tt.at), tt.length, tt.slot); got != tt.want {
+				t.Errorf("Unblocked() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package schellydule
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedRecurrence is returned for recurrence rules that can't be
+// expanded. Only FREQ=DAILY and FREQ=WEEKLY, with INTERVAL, COUNT, UNTIL and
+// BYDAY, are supported.
+var ErrUnsupportedRecurrence = errors.New("unsupported recurrence")
+
+// icsWeekdays are the weekdays of BYDAY
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// rrule is a parsed recurrence rule
+type rrule struct {
+	weekly    bool
+	interval  int
+	count     int
+	until     time.Time
+	byDay     map[time.Weekday]bool
+	weekStart time.Weekday
+}
+
+// parseRRule parses the value of an RRULE. Times in UNTIL without a time zone
+// are in loc.
+func parseRRule(value string, loc *time.Location) (rrule, error) {
+	rv := rrule{interval: 1, weekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return rrule{}, fmt.Errorf("invalid rule part %q", part)
+		}
+		var err error
+		switch key, v := strings.ToUpper(kv[0]), strings.ToUpper(kv[1]); key {
+		case "FREQ":
+			switch v {
+			case "DAILY":
+			case "WEEKLY":
+				rv.weekly = true
+			default:
+				return rrule{}, fmt.Errorf("%w: FREQ=%s", ErrUnsupportedRecurrence, v)
+			}
+		case "INTERVAL":
+			if rv.interval, err = strconv.Atoi(v); err != nil || rv.interval < 1 {
+				return rrule{}, fmt.Errorf("invalid INTERVAL %q", v)
+			}
+		case "COUNT":
+			if rv.count, err = strconv.Atoi(v); err != nil || rv.count < 1 {
+				return rrule{}, fmt.Errorf("invalid COUNT %q", v)
+			}
+		case "UNTIL":
+			if rv.until, _, err = parseICSTime(v, nil, loc); err != nil {
+				return rrule{}, fmt.Errorf("invalid UNTIL %q: %w", v, err)
+			}
+		case "BYDAY":
+			rv.byDay = make(map[time.Weekday]bool)
+			for _, d := range strings.Split(v, ",") {
+				wd, ok := icsWeekdays[d]
+				if !ok {
+					// Like "1MO", which only goes with monthly and yearly rules
+					return rrule{}, fmt.Errorf("%w: BYDAY=%s", ErrUnsupportedRecurrence, v)
+				}
+				rv.byDay[wd] = true
+			}
+		case "WKST":
+			wd, ok := icsWeekdays[v]
+			if !ok {
+				return rrule{}, fmt.Errorf("invalid WKST %q", v)
+			}
+			rv.weekStart = wd
+		default:
+			return rrule{}, fmt.Errorf("%w: %s", ErrUnsupportedRecurrence, key)
+		}
+	}
+	return rv, nil
+}
+
+// Occurrences returns the occurrences of e overlapping from to to. An event
+// that doesn't recur is its only occurrence.
+func (e Event) Occurrences(from, to time.Time) ([]Event, error) {
+	if e.RRule == "" && len(e.RDates) == 0 {
+		if e.Stop.After(from) && e.Start.Before(to) {
+			return []Event{e}, nil
+		}
+		return nil, nil
+	}
+	starts := []time.Time{e.Start}
+	if e.RRule != "" {
+		r, err := parseRRule(e.RRule, e.Start.Location())
+		if err != nil {
+			return nil, err
+		}
+		starts = r.starts(e.Start, to)
+	}
+	starts = append(starts, e.RDates...)
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	length := e.Stop.Sub(e.Start)
+	days := int(math.Round(length.Hours() / 24))
+	var rv []Event
+	for i, start := range starts {
+		if i > 0 && start.Equal(starts[i-1]) || e.excluded(start) {
+			continue
+		}
+		o := e
+		o.Start, o.Stop = start, start.Add(length)
+		if e.AllDay {
+			// Days aren't always 24 hours
+			o.Stop = start.AddDate(0, 0, days)
+		}
+		o.RRule, o.RDates, o.ExDates = "", nil, nil
+		if o.Stop.After(from) && o.Start.Before(to) {
+			rv = append(rv, o)
+		}
+	}
+	return rv, nil
+}
+
+// excluded returns true if the occurrence starting at start is an EXDATE of e
+func (e Event) excluded(start time.Time) bool {
+	for _, x := range e.ExDates {
+		if x.Equal(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// starts returns the starts r gives the event starting at first, before the
+// time to
+func (r rrule) starts(first, to time.Time) []time.Time {
+	var rv []time.Time
+	n := 0
+	// add adds t if it's an occurrence, and returns false when there are no more
+	add := func(t time.Time) bool {
+		if !r.until.IsZero() && t.After(r.until) || r.count > 0 && n >= r.count || !t.Before(to) {
+			return false
+		}
+		if r.byDay != nil && !r.byDay[t.Weekday()] || t.Before(first) {
+			return true
+		}
+		n++
+		rv = append(rv, t)
+		return true
+	}
+	if !r.weekly || r.byDay == nil {
+		step := r.interval
+		if r.weekly {
+			step *= 7
+		}
+		for i := 0; add(first.AddDate(0, 0, i*step)); i++ {
+		}
+		return rv
+	}
+	// Every day of the weeks the rule runs in, from the start of the week of first
+	week := first.AddDate(0, 0, -((int(first.Weekday()) - int(r.weekStart) + 7) % 7))
+	for w := 0; ; w += r.interval {
+		for d := 0; d < 7; d++ {
+			if !add(week.AddDate(0, 0, w*7+d)) {
+				return rv
+			}
+		}
+	}
+}
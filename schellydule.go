@@ -77,7 +77,18 @@ type PairedSchedule struct {
 	Off time.Time
 }
 
+// ParseSchedule parses a job in the time zone of the server
 func ParseSchedule(s shelly.JobSpec) (schedule, error) {
+	loc, err := localZone()
+	if err != nil {
+		return schedule{}, err
+	}
+	return ParseScheduleIn(s, loc)
+}
+
+// ParseScheduleIn parses a job on a device in the time zone loc. The trigger
+// time is on today's date in loc.
+func ParseScheduleIn(s shelly.JobSpec, loc *time.Location) (schedule, error) {
 	var rv schedule
 	for _, c := range s.Calls {
 		if strings.ToLower(c.Method) == "switch.set" {
@@ -93,20 +104,15 @@ func ParseSchedule(s shelly.JobSpec) (schedule, error) {
 		}
 	}
 
-	// Get time zone of server
-	tz, loc, err := timeZone()
-	if err != nil {
-		return schedule{}, err
-	}
-	timespec := "TZ=" + tz + " " + s.Timespec
-
-	t, err := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Second).Parse(timespec)
+	// Without a TZ= prefix, the schedule runs in the location of the time
+	// passed to Next
+	t, err := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Second).Parse(s.Timespec)
 	if err != nil {
 		return schedule{}, err
 	}
 
-	midnight := sch.Hour(time.Now(), 0)
-	rv.trigger = t.Next(midnight).In(loc)
+	midnight := sch.Hour(time.Now().In(loc), 0)
+	rv.trigger = t.Next(midnight)
 
 	// Special case if 't.next' is supposed to run at midnight, which will set
 	// trigger's date to 24 hours from now. This is not important for the shelly,
@@ -119,16 +125,13 @@ func ParseSchedule(s shelly.JobSpec) (schedule, error) {
 	return rv, nil
 }
 
-func timeZone() (string, *time.Location, error) {
+// localZone returns the time zone of the server
+func localZone() (*time.Location, error) {
 	tz, err := tzlocal.RuntimeTZ()
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
-	loc, err := time.LoadLocation(tz)
-	if err != nil {
-		return "", nil, err
-	}
-	return tz, loc, nil
+	return time.LoadLocation(tz)
 }
 
 func (ss schedules) Paired() ([]PairedSchedule, error) {
@@ -155,12 +158,21 @@ func (ss schedules) Paired() ([]PairedSchedule, error) {
 }
 
 func ScheduleToPaired(in shelly.Schedules) ([]PairedSchedule, error) {
+	loc, err := localZone()
+	if err != nil {
+		return nil, err
+	}
+	return ScheduleToPairedIn(in, loc)
+}
+
+// ScheduleToPairedIn pairs the on and off jobs of a device in the time zone loc
+func ScheduleToPairedIn(in shelly.Schedules, loc *time.Location) ([]PairedSchedule, error) {
 	var ss schedules
 	for _, s := range in {
 		if !s.HasMethod("switch.set") {
 			continue
 		}
-		tmp, err := ParseSchedule(s)
+		tmp, err := ParseScheduleIn(s, loc)
 		if err != nil {
 			return nil, err
 		}
@@ -173,7 +185,16 @@ func ScheduleToPaired(in shelly.Schedules) ([]PairedSchedule, error) {
 // 'on' Jobspec, it will return the Jobspec that turns it back off. If j is an
 // 'off' JobSpec, it'll return the jobspec that turned it on
 func FindMatching(j shelly.JobSpec, s shelly.Schedules) (shelly.JobSpec, error) {
-	sched, err := ParseSchedule(j)
+	loc, err := localZone()
+	if err != nil {
+		return shelly.JobSpec{}, err
+	}
+	return FindMatchingIn(j, s, loc)
+}
+
+// FindMatchingIn is FindMatching for a device in the time zone loc
+func FindMatchingIn(j shelly.JobSpec, s shelly.Schedules, loc *time.Location) (shelly.JobSpec, error) {
+	sched, err := ParseScheduleIn(j, loc)
 	if err != nil {
 		return shelly.JobSpec{}, err
 	}
@@ -183,7 +204,7 @@ func FindMatching(j shelly.JobSpec, s shelly.Schedules) (shelly.JobSpec, error)
 		if !e.HasMethod("switch.set") {
 			continue
 		}
-		job, err := ParseSchedule(e)
+		job, err := ParseScheduleIn(e, loc)
 		if err != nil {
 			return shelly.JobSpec{}, err
 		}
@@ -220,12 +241,22 @@ func FindMatching(j shelly.JobSpec, s shelly.Schedules) (shelly.JobSpec, error)
 
 // Schedule converts a list of cronjobs to a schedule.Schedule (a list of start/stop times)
 func Schedule(s shelly.Schedules) (sch.Schedule, error) {
+	loc, err := localZone()
+	if err != nil {
+		return nil, err
+	}
+	return ScheduleIn(s, loc)
+}
+
+// ScheduleIn converts the cronjobs of a device in the time zone loc to a
+// schedule.Schedule, with times on today's date in loc
+func ScheduleIn(s shelly.Schedules, loc *time.Location) (sch.Schedule, error) {
 	var rv = make(sch.Schedule, 0, len(s)/2)
 	for _, job := range s {
 		if !job.HasMethod("switch.set") {
 			continue
 		}
-		js, err := ParseSchedule(job)
+		js, err := ParseScheduleIn(job, loc)
 		if err != nil {
 			return nil, err
 		}
@@ -234,13 +265,13 @@ func Schedule(s shelly.Schedules) (sch.Schedule, error) {
 		}
 
 		var e sch.Entry
-		match, err := FindMatching(job, s)
+		match, err := FindMatchingIn(job, s, loc)
 		if err != nil {
 			return nil, err
 		}
 
 		e.Start = js.TriggerTime()
-		e.Stop, err = match.Time()
+		e.Stop, err = match.TimeIn(loc)
 		e.Cost = js.Cost()
 		if err != nil {
 			return nil, err
@@ -0,0 +1,112 @@
+package schellydule
+
+import (
+	"sort"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// Load is a device drawing power, which may be switched off to keep the
+// household below a power limit
+type Load struct {
+	Name  string
+	Watts float64
+	// Priority is how important the load is. The lowest priority is shed first
+	Priority int
+}
+
+// Shed returns the loads to switch off for power to get down to limit, lowest
+// priority first. Loads of equal priority are shed in the order given. If
+// switching off all loads isn't enough, they're all returned.
+func Shed(power, limit float64, loads []Load) []Load {
+	sorted := make([]Load, len(loads))
+	copy(sorted, loads)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	var rv []Load
+	for _, l := range sorted {
+		if power <= limit {
+			break
+		}
+		rv = append(rv, l)
+		power -= l.Watts
+	}
+	return rv
+}
+
+// Restorable returns the load of shed with the highest priority that can be
+// switched on again without power exceeding limit. Loads of equal priority are
+// restored in the order given.
+func Restorable(power, limit float64, shed []Load) (Load, bool) {
+	var rv Load
+	found := false
+	for _, l := range shed {
+		if power+l.Watts > limit || found && l.Priority <= rv.Priority {
+			continue
+		}
+		rv, found = l, true
+	}
+	return rv, found
+}
+
+// RuntimeBetween returns how long s runs between from and to
+func RuntimeBetween(s sch.Schedule, from, to time.Time) time.Duration {
+	var rv time.Duration
+	for _, e := range Compact(s) {
+		start, stop := e.Start, e.Stop
+		if from.After(start) {
+			start = from
+		}
+		if to.Before(stop) {
+			stop = to
+		}
+		if stop.After(start) {
+			rv += stop.Sub(start)
+		}
+	}
+	return rv
+}
+
+// Extend returns s with length of runtime added from at, in the time s
+// doesn't already run, and not after until. Slots of length slot, counted from
+// midnight, that c blocks are skipped. A run in progress at `at` is made
+// longer, and the runs after it are joined as needed. The runtime that didn't
+// fit is returned too.
+func Extend(s sch.Schedule, at time.Time, length time.Duration, until time.Time, c Constraints, slot time.Duration) (sch.Schedule, time.Duration) {
+	rv := Compact(s)
+	var added sch.Schedule
+	t := at
+	for length > 0 && t.Before(until) {
+		stop := until
+		if c.Blocked != nil {
+			sl := slotAt(t, slot)
+			if c.blocked(sl) {
+				t = sl.End()
+				continue
+			}
+			if sl.End().Before(stop) {
+				stop = sl.End()
+			}
+		}
+		running := false
+		for _, e := range rv {
+			if !e.Start.After(t) && e.Stop.After(t) {
+				t, running = e.Stop, true
+				break
+			}
+			if e.Start.After(t) && e.Start.Before(stop) {
+				stop = e.Start
+			}
+		}
+		if running {
+			continue
+		}
+		if stop.Sub(t) > length {
+			stop = t.Add(length)
+		}
+		added = append(added, sch.Entry{Start: t, Stop: stop})
+		length -= stop.Sub(t)
+		t = stop
+	}
+	return Compact(append(rv, added...)), length
+}
@@ -0,0 +1,145 @@
+package schellydule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+func TestShed(t *testing.T) {
+	loads := []Load{
+		{Name: "charger", Watts: 7400, Priority: 1},
+		{Name: "boiler", Watts: 3000, Priority: 2},
+		{Name: "pool", Watts: 1100, Priority: 1},
+	}
+	tests := []struct {
+		name  string
+		power float64
+		want  []string
+	}{
+		{name: "below limit", power: 10000, want: nil},
+		{name: "lowest priority first", power: 15000, want: []string{"charger"}},
+		{name: "more than one", power: 19000, want: []string{"charger", "pool"}},
+		{name: "not enough", power: 30000, want: []string{"charger", "pool", "boiler"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, l := range Shed(tt.power, 11000, loads) {
+				got = append(got, l.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Shed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestorable(t *testing.T) {
+	shed := []Load{
+		{Name: "charger", Watts: 7400, Priority: 1},
+		{Name: "pool", Watts: 1100, Priority: 1},
+		{Name: "boiler", Watts: 3000, Priority: 2},
+	}
+	tests := []struct {
+		name   string
+		power  float64
+		want   string
+		wantOK bool
+	}{
+		{name: "highest priority first", power: 2000, want: "boiler", wantOK: true},
+		{name: "first of equal priority", power: 9000, want: "pool", wantOK: true},
+		{name: "room for none", power: 10500, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Restorable(tt.power, 11000, shed)
+			if got.Name != tt.want || ok != tt.wantOK {
+				t.Errorf("Restorable() = %s, %t, want %s, %t", got.Name, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRuntimeBetween(t *testing.T) {
+	s := sch.Schedule{entry(1, 3, 0), entry(5, 8, 0)}
+	if got, want := RuntimeBetween(s, day.Add(2*time.Hour), day.Add(6*time.Hour)), 2*time.Hour; got != want {
+		t.Errorf("RuntimeBetween() = %s, want %s", got, want)
+	}
+}
+
+func TestExtend(t *testing.T) {
+	s := sch.Schedule{entry(1, 3, 1), entry(5, 8, 1)}
+	midnight := day.Add(24 * time.Hour)
+	// Blocked from 10:00 to 12:00
+	quiet := Constraints{Blocked: func(s Slot) bool { return s.OverlapsClock(10*time.Hour, 12*time.Hour) }}
+	tests := []struct {
+		name     string
+		at       time.Duration
+		length   time.Duration
+		until    time.Time
+		c        Constraints
+		want     sch.Schedule
+		wantLeft time.Duration
+	}{
+		{
+			name:   "in a gap",
+			at:     4 * time.Hour,
+			length: 30 * time.Minute,
+			until:  midnight,
+			want:   sch.Schedule{entry(1, 3, 1), {Start: day.Add(4 * time.Hour), Stop: day.Add(270 * time.Minute)}, entry(5, 8, 1)},
+		},
+		{
+			name:   "longer run",
+			at:     2 * time.Hour,
+			length: time.Hour,
+			until:  midnight,
+			want:   sch.Schedule{entry(1, 4, 1), entry(5, 8, 1)},
+		},
+		{
+			name:   "joining runs",
+			at:     2 * time.Hour,
+			length: 3 * time.Hour,
+			until:  midnight,
+			want:   sch.Schedule{entry(1, 9, 2)},
+		},
+		{
+			name:     "until",
+			at:       7 * time.Hour,
+			length:   3 * time.Hour,
+			until:    day.Add(10 * time.Hour),
+			want:     sch.Schedule{entry(1, 3, 1), entry(5, 10, 1)},
+			wantLeft: time.Hour,
+		},
+		{
+			name:   "skipping blocked slots",
+			at:     9 * time.Hour,
+			length: 2 * time.Hour,
+			until:  midnight,
+			c:      quiet,
+			want:   sch.Schedule{entry(1, 3, 1), entry(5, 8, 1), entry(9, 10, 0), entry(12, 13, 0)},
+		},
+		{
+			name:     "blocked until",
+			at:       7 * time.Hour,
+			length:   3 * time.Hour,
+			until:    day.Add(12 * time.Hour),
+			c:        quiet,
+			want:     sch.Schedule{entry(1, 3, 1), entry(5, 10, 1)},
+			wantLeft: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, left := Extend(s, day.Add(tt.at), tt.length, tt.until, tt.c, time.Hour)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extend() = %v, want %v", got, tt.want)
+			}
+			if left != tt.wantLeft {
+				t.Errorf("Extend() left %s, want %s", left, tt.wantLeft)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adamhassel/errors"
+)
+
+var (
+	// ErrClockNotSet is returned when the Shelly doesn't know the time, for
+	// example after a restart without network
+	ErrClockNotSet = errors.New("the Shelly's clock isn't set")
+	// ErrClockSkew is returned when the Shelly's clock is too far off
+	ErrClockSkew = errors.New("the Shelly's clock is off")
+	// ErrZoneMismatch is returned when the Shelly is in another time zone than
+	// the schedule is made for
+	ErrZoneMismatch = errors.New("the Shelly is in another time zone")
+)
+
+// Clock is the time and time settings of a Shelly
+type Clock struct {
+	// Time is the time on the Shelly. Zero if its clock isn't set
+	Time time.Time
+	// Skew is how far the Shelly's clock is ahead of ours
+	Skew time.Duration
+	// Zone is the time zone configured on the Shelly
+	Zone string
+	// SNTPServer is the time server the Shelly syncs its clock with
+	SNTPServer string
+}
+
+// GetClock reads the clock and time settings of the Shelly at dest
+func GetClock(ctx context.Context, dest fmt.Stringer) (Clock, error) {
+	c := NewClient(dest)
+	var rv Clock
+	conf, err := c.GetSysConfig(ctx)
+	if err != nil {
+		return rv, err
+	}
+	rv.Zone, rv.SNTPServer = conf.Location.TZ, conf.SNTP.Server
+	before := time.Now()
+	status, err := c.GetSysStatus(ctx)
+	if err != nil {
+		return rv, err
+	}
+	if status.Unixtime == 0 {
+		return rv, nil
+	}
+	// The Shelly read its clock somewhere during the call
+	ours := before.Add(time.Since(before) / 2)
+	rv.Time = time.Unix(status.Unixtime, 0)
+	rv.Skew = rv.Time.Sub(ours)
+	return rv, nil
+}
+
+// Verify returns the problems with the clock, for schedules in the time zone
+// loc: ErrClockNotSet, ErrClockSkew if it's off by more than maxSkew, or
+// ErrZoneMismatch.
+func (c Clock) Verify(loc *time.Location, maxSkew time.Duration) []error {
+	var rv []error
+	if c.Time.IsZero() {
+		rv = append(rv, ErrClockNotSet)
+	} else if c.Skew > maxSkew || c.Skew < -maxSkew {
+		rv = append(rv, fmt.Errorf("%w by %s, more than %s", ErrClockSkew, c.Skew.Round(time.Second), maxSkew))
+	}
+	if !sameZone(c.Zone, loc) {
+		zone := c.Zone
+		if zone == "" {
+			zone = "no time zone"
+		}
+		rv = append(rv, fmt.Errorf("%w: %s, not %s", ErrZoneMismatch, zone, loc))
+	}
+	return rv
+}
+
+// sameZone returns true if the zone called name has the same offsets as loc,
+// in summer and winter
+func sameZone(name string, loc *time.Location) bool {
+	if name == loc.String() {
+		return true
+	}
+	other, err := time.LoadLocation(name)
+	if name == "" || err != nil {
+		return false
+	}
+	year := time.Now().Year()
+	for _, month := range []time.Month{time.January, time.July} {
+		t := time.Date(year, month, 1, 12, 0, 0, 0, time.UTC)
+		_, a := t.In(loc).Zone()
+		_, b := t.In(other).Zone()
+		if a != b {
+			return false
+		}
+	}
+	return true
+}
+
+// SetClock configures the Shelly at dest to use the time zone loc, unless it's
+// nil, and to sync its clock with sntpServer, unless it's empty
+func SetClock(ctx context.Context, dest fmt.Stringer, loc *time.Location, sntpServer string) error {
+	var u SysConfigUpdate
+	if loc != nil {
+		u.Location = &LocationConfig{TZ: loc.String()}
+	}
+	if sntpServer != "" {
+		u.SNTP = &SNTPConfig{Server: sntpServer}
+	}
+	_, err := NewClient(dest).SetSysConfig(ctx, u)
+	return err
+}
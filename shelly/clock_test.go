@@ -0,0 +1,75 @@
+package shelly
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClock_Verify(t *testing.T) {
+	cph, err := time.LoadLocation("Europe/Copenhagen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tests := []struct {
+		name  string
+		clock Clock
+		want  []error
+	}{
+		{name: "ok", clock: Clock{Time: now, Skew: 3 * time.Second, Zone: "Europe/Copenhagen"}},
+		{name: "same offsets", clock: Clock{Time: now, Zone: "Europe/Berlin"}},
+		{name: "not set", clock: Clock{Zone: "Europe/Copenhagen"}, want: []error{ErrClockNotSet}},
+		{name: "ahead", clock: Clock{Time: now, Skew: 5 * time.Minute, Zone: "Europe/Copenhagen"}, want: []error{ErrClockSkew}},
+		{name: "behind", clock: Clock{Time: now, Skew: -5 * time.Minute, Zone: "Europe/Copenhagen"}, want: []error{ErrClockSkew}},
+		{name: "other zone", clock: Clock{Time: now, Zone: "Europe/London"}, want: []error{ErrZoneMismatch}},
+		{name: "no zone", clock: Clock{Time: now}, want: []error{ErrZoneMismatch}},
+		{name: "everything wrong", clock: Clock{Zone: "America/New_York"}, want: []error{ErrClockNotSet, ErrZoneMismatch}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.clock.Verify(cph, 2*time.Minute)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Verify() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !errors.Is(got[i], tt.want[i]) {
+					t.Errorf("Verify()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGetClock(t *testing.T) {
+	ahead := time.Now().Add(10 * time.Minute).Unix()
+	var calls []rpcRequest
+	c := fakeShelly(t, map[string]string{
+		"Sys.GetConfig": `{"result":{"location":{"tz":"Europe/Copenhagen"},"sntp":{"server":"time.google.com"}}}`,
+		"Sys.GetStatus": `{"result":{"unixtime":` + strconv.FormatInt(ahead, 10) + `,"time":"13:04"}}`,
+		"Sys.SetConfig": `{"result":{"restart_required":false}}`,
+	}, &calls)
+	ctx := context.Background()
+	clock, err := GetClock(ctx, addr(c.Addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clock.Zone != "Europe/Copenhagen" || clock.SNTPServer != "time.google.com" {
+		t.Errorf("GetClock() = %+v", clock)
+	}
+	if d := clock.Skew - 10*time.Minute; d > 2*time.Second || d < -2*time.Second {
+		t.Errorf("GetClock() skew = %s, want 10m", clock.Skew)
+	}
+
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	if err := SetClock(ctx, addr(c.Addr), loc, "pool.ntp.org"); err != nil {
+		t.Fatal(err)
+	}
+	last := calls[len(calls)-1]
+	want := `{"config":{"location":{"tz":"Europe/Berlin"},"sntp":{"server":"pool.ntp.org"}}}`
+	if last.Method != "Sys.SetConfig" || string(mustMarshal(t, last.Params)) != want {
+		t.Errorf("SetClock() called %s with %s, want Sys.SetConfig with %s", last.Method, mustMarshal(t, last.Params), want)
+	}
+}
@@ -0,0 +1,519 @@
+package shelly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/adamhassel/schellydule/contx"
+)
+
+// rpcSource identifies us as the sender of RPC requests
+const rpcSource = "schellydule"
+
+// Error codes returned by Gen2 devices
+const (
+	CodeInvalidArgument    = -103
+	CodeDeadlineExceeded   = -104
+	CodeNotFound           = -105
+	CodeResourceExhausted  = -106
+	CodeFailedPrecondition = -107
+	CodeUnavailable        = -108
+	CodeUnauthorized       = 401
+)
+
+// Sentinel errors for the error codes, to use with errors.Is
+var (
+	ErrInvalidArgument    = &RPCError{Code: CodeInvalidArgument}
+	ErrDeadlineExceeded   = &RPCError{Code: CodeDeadlineExceeded}
+	ErrNotFound           = &RPCError{Code: CodeNotFound}
+	ErrResourceExhausted  = &RPCError{Code: CodeResourceExhausted}
+	ErrFailedPrecondition = &RPCError{Code: CodeFailedPrecondition}
+	ErrUnavailable        = &RPCError{Code: CodeUnavailable}
+	ErrUnauthorized       = &RPCError{Code: CodeUnauthorized}
+)
+
+// RPCError is an error returned by the Shelly. Use errors.As to get the code
+// and message, or errors.Is with the sentinel errors to check the code.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Method is the method that failed
+	Method string `json:"-"`
+}
+
+func (e *RPCError) Error() string {
+	if e.Method == "" {
+		return fmt.Sprintf("shelly error %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: shelly error %d: %s", e.Method, e.Code, e.Message)
+}
+
+// Is returns true if target is an *RPCError with the same code
+func (e *RPCError) Is(target error) bool {
+	t, ok := target.(*RPCError)
+	return ok && t.Code == e.Code
+}
+
+type rpcRequest struct {
+	ID     uint64      `json:"id"`
+	Src    string      `json:"src"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	ID     uint64          `json:"id"`
+	Src    string          `json:"src"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// Client calls the JSON-RPC API of a Gen2 Shelly
+type Client struct {
+	// Addr is the host, and optionally port, of the Shelly
+	Addr string
+	// HTTP is the client used for the calls. If nil, the client configured for
+	// the Shelly with Configure is used
+	HTTP *http.Client
+	id   uint64
+}
+
+// NewClient returns a client for the Shelly at dest
+func NewClient(dest fmt.Stringer) *Client {
+	return &Client{Addr: dest.String()}
+}
+
+// Call calls method with params, and decodes the result into result, unless
+// it's nil. Errors from the Shelly are returned as *RPCError. If ctx is
+// pretending, calls that change anything on the Shelly are skipped.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	if contx.Pretend(ctx) && !readOnly(method) {
+		log.Printf("just pretending, not calling %s", method)
+		return nil
+	}
+	req := rpcRequest{
+		ID:     atomic.AddUint64(&c.id, 1),
+		Src:    rpcSource,
+		Method: method,
+		Params: params,
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	u := url.URL{Scheme: "http", Host: c.Addr, Path: "rpc"}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	data, status, err := transportFor(c.Addr).do(ctx, c.HTTP, method, func(ctx context.Context) (*http.Request, error) {
+		hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		hreq.Header.Set("Content-Type", "application/json")
+		return hreq, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	var resp rpcResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		if status != http.StatusOK {
+			return fmt.Errorf("%s: RPC call returned %d %s: %s", method, status, http.StatusText(status), data)
+		}
+		return fmt.Errorf("%s: decoding response: %w", method, err)
+	}
+	if resp.Error != nil {
+		resp.Error.Method = method
+		return resp.Error
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%s: RPC call returned %d %s: %s", method, status, http.StatusText(status), data)
+	}
+	if resp.ID != req.ID {
+		return fmt.Errorf("%s: response id %d doesn't match request id %d", method, resp.ID, req.ID)
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("%s: decoding result: %w", method, err)
+	}
+	return nil
+}
+
+// readOnly returns true if method only reads from the Shelly
+func readOnly(method string) bool {
+	i := strings.LastIndex(method, ".")
+	action := method[i+1:]
+	return strings.HasPrefix(action, "Get") || strings.HasPrefix(action, "List")
+}
+
+// decodeRPCError returns body as an *RPCError for method, if it is one
+func decodeRPCError(method string, body []byte) (*RPCError, bool) {
+	var e RPCError
+	if err := json.Unmarshal(body, &e); err != nil || e.Code == 0 {
+		return nil, false
+	}
+	e.Method = method
+	return &e, true
+}
+
+// DeviceInfo is the result of Shelly.GetDeviceInfo
+type DeviceInfo struct {
+	ID    string `json:"id"`
+	MAC   string `json:"mac"`
+	Model string `json:"model"`
+	Gen   int    `json:"gen"`
+	FwID  string `json:"fw_id"`
+	Ver   string `json:"ver"`
+	App   string `json:"app"`
+	// AuthEn is true if authentication is enabled
+	AuthEn bool `json:"auth_en"`
+}
+
+// GetDeviceInfo calls Shelly.GetDeviceInfo
+func (c *Client) GetDeviceInfo(ctx context.Context) (DeviceInfo, error) {
+	var rv DeviceInfo
+	err := c.Call(ctx, "Shelly.GetDeviceInfo", nil, &rv)
+	return rv, err
+}
+
+// DeviceStatus is the result of Shelly.GetStatus. It has the status of each
+// component, keyed by its name, like "switch:0".
+type DeviceStatus map[string]json.RawMessage
+
+// Input returns the status of input id
+func (s DeviceStatus) Input(id int) (InputStatus, error) {
+	var rv InputStatus
+	err := s.component(fmt.Sprintf("input:%d", id), &rv)
+	return rv, err
+}
+
+// Switch returns the status of switch id
+func (s DeviceStatus) Switch(id int) (SwitchStatus, error) {
+	var rv SwitchStatus
+	err := s.component(fmt.Sprintf("switch:%d", id), &rv)
+	return rv, err
+}
+
+// Sys returns the system status
+func (s DeviceStatus) Sys() (SysStatus, error) {
+	var rv SysStatus
+	err := s.component("sys", &rv)
+	return rv, err
+}
+
+func (s DeviceStatus) component(name string, v interface{}) error {
+	raw, ok := s[name]
+	if !ok {
+		return fmt.Errorf("device has no %s", name)
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// GetStatus calls Shelly.GetStatus
+func (c *Client) GetStatus(ctx context.Context) (DeviceStatus, error) {
+	var rv DeviceStatus
+	err := c.Call(ctx, "Shelly.GetStatus", nil, &rv)
+	return rv, err
+}
+
+// InputStatus is the status of an input
+type InputStatus struct {
+	ID    int  `json:"id"`
+	State bool `json:"state"`
+}
+
+// SwitchStatus is the state of the Shelly's switch
+type SwitchStatus struct {
+	ID     int    `json:"id"`
+	Source string `json:"source"`
+	Output bool   `json:"output"`
+	// APower is the current power in W. Zero if the device doesn't measure power.
+	APower  float64 `json:"apower"`
+	Voltage float64 `json:"voltage"`
+	// AEnergy is the energy used since the device booted. Zero if the device
+	// doesn't measure power.
+	AEnergy EnergyCounter `json:"aenergy"`
+}
+
+// EnergyCounter is an energy meter
+type EnergyCounter struct {
+	// Total is the total energy in Wh
+	Total float64 `json:"total"`
+}
+
+type idParams struct {
+	ID int `json:"id"`
+}
+
+// GetSwitchStatus calls Switch.GetStatus for switch id
+func (c *Client) GetSwitchStatus(ctx context.Context, id int) (SwitchStatus, error) {
+	var rv SwitchStatus
+	err := c.Call(ctx, "Switch.GetStatus", idParams{ID: id}, &rv)
+	return rv, err
+}
+
+// SetSwitch calls Switch.Set, turning switch id on or off. It returns whether
+// the switch was on before.
+func (c *Client) SetSwitch(ctx context.Context, id int, on bool) (bool, error) {
+	var rv struct {
+		WasOn bool `json:"was_on"`
+	}
+	err := c.Call(ctx, "Switch.Set", struct {
+		ID int  `json:"id"`
+		On bool `json:"on"`
+	}{id, on}, &rv)
+	return rv.WasOn, err
+}
+
+// SetSwitchFor calls Switch.Set, turning switch id on or off for d, after
+// which the Shelly switches it back by itself. It returns whether the switch
+// was on before.
+func (c *Client) SetSwitchFor(ctx context.Context, id int, on bool, d time.Duration) (bool, error) {
+	var rv struct {
+		WasOn bool `json:"was_on"`
+	}
+	err := c.Call(ctx, "Switch.Set", struct {
+		ID          int     `json:"id"`
+		On          bool    `json:"on"`
+		ToggleAfter float64 `json:"toggle_after"`
+	}{id, on, d.Seconds()}, &rv)
+	return rv.WasOn, err
+}
+
+// EMStatus is the status of a three phase energy meter, like the Shelly 3EM
+// or Pro 3EM
+type EMStatus struct {
+	ID int `json:"id"`
+	// TotalActPower is the active power of all phases in W. It's negative when
+	// power is exported to the grid.
+	TotalActPower float64 `json:"total_act_power"`
+}
+
+// GetEMStatus calls EM.GetStatus for energy meter id
+func (c *Client) GetEMStatus(ctx context.Context, id int) (EMStatus, error) {
+	var rv EMStatus
+	err := c.Call(ctx, "EM.GetStatus", idParams{ID: id}, &rv)
+	return rv, err
+}
+
+// SysStatus is the result of Sys.GetStatus
+type SysStatus struct {
+	MAC             string `json:"mac"`
+	RestartRequired bool   `json:"restart_required"`
+	// Time is the local time of day, like "13:04", if the device knows the time
+	Time     string `json:"time"`
+	Unixtime int64  `json:"unixtime"`
+	Uptime   int64  `json:"uptime"`
+	RAMSize  int    `json:"ram_size"`
+	RAMFree  int    `json:"ram_free"`
+	FSSize   int    `json:"fs_size"`
+	FSFree   int    `json:"fs_free"`
+}
+
+// GetSysStatus calls Sys.GetStatus
+func (c *Client) GetSysStatus(ctx context.Context) (SysStatus, error) {
+	var rv SysStatus
+	err := c.Call(ctx, "Sys.GetStatus", nil, &rv)
+	return rv, err
+}
+
+// SysConfig is the result of Sys.GetConfig
+type SysConfig struct {
+	Device struct {
+		Name string `json:"name"`
+		MAC  string `json:"mac"`
+	} `json:"device"`
+	Location struct {
+		TZ  string  `json:"tz"`
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	} `json:"location"`
+	SNTP struct {
+		Server string `json:"server"`
+	} `json:"sntp"`
+}
+
+// GetSysConfig calls Sys.GetConfig
+func (c *Client) GetSysConfig(ctx context.Context) (SysConfig, error) {
+	var rv SysConfig
+	err := c.Call(ctx, "Sys.GetConfig", nil, &rv)
+	return rv, err
+}
+
+// SysConfigUpdate is the parameters of Sys.SetConfig. Sections left nil are
+// not changed.
+type SysConfigUpdate struct {
+	Location *LocationConfig `json:"location,omitempty"`
+	SNTP     *SNTPConfig     `json:"sntp,omitempty"`
+}
+
+// LocationConfig is the location section of the system configuration
+type LocationConfig struct {
+	TZ string `json:"tz"`
+}
+
+// SNTPConfig is the sntp section of the system configuration
+type SNTPConfig struct {
+	Server string `json:"server"`
+}
+
+// SetSysConfig calls Sys.SetConfig, and returns true if the Shelly must be
+// restarted for the changes to take effect
+func (c *Client) SetSysConfig(ctx context.Context, u SysConfigUpdate) (bool, error) {
+	var rv struct {
+		RestartRequired bool `json:"restart_required"`
+	}
+	err := c.Call(ctx, "Sys.SetConfig", struct {
+		Config SysConfigUpdate `json:"config"`
+	}{u}, &rv)
+	return rv.RestartRequired, err
+}
+
+// ListSchedules calls Schedule.List
+func (c *Client) ListSchedules(ctx context.Context) (Schedules, error) {
+	var rv Schedule
+	err := c.Call(ctx, "Schedule.List", nil, &rv)
+	return rv.Jobs, err
+}
+
+// CreateSchedule calls Schedule.Create, and returns the ID of the new job
+func (c *Client) CreateSchedule(ctx context.Context, j JobSpec) (int, error) {
+	var rv idParams
+	err := c.Call(ctx, "Schedule.Create", j, &rv)
+	return rv.ID, err
+}
+
+// ScheduleUpdate is the parameters of Schedule.Update. Fields left empty are
+// not changed.
+type ScheduleUpdate struct {
+	ID       int    `json:"id"`
+	Enable   *bool  `json:"enable,omitempty"`
+	Timespec string `json:"timespec,omitempty"`
+	Calls    []Call `json:"calls,omitempty"`
+}
+
+// UpdateSchedule calls Schedule.Update
+func (c *Client) UpdateSchedule(ctx context.Context, u ScheduleUpdate) error {
+	return c.Call(ctx, "Schedule.Update", u, nil)
+}
+
+// DeleteSchedule calls Schedule.Delete
+func (c *Client) DeleteSchedule(ctx context.Context, id int) error {
+	return c.Call(ctx, "Schedule.Delete", idParams{ID: id}, nil)
+}
+
+// DeleteAllSchedules calls Schedule.DeleteAll
+func (c *Client) DeleteAllSchedules(ctx context.Context) error {
+	return c.Call(ctx, "Schedule.DeleteAll", nil, nil)
+}
+
+// KVSItem is a value in the key-value store of the Shelly
+type KVSItem struct {
+	Etag  string `json:"etag"`
+	Value string `json:"value"`
+}
+
+type keyParams struct {
+	Key string `json:"key"`
+}
+
+// KVSGet calls KVS.Get
+func (c *Client) KVSGet(ctx context.Context, key string) (KVSItem, error) {
+	var rv KVSItem
+	err := c.Call(ctx, "KVS.Get", keyParams{Key: key}, &rv)
+	return rv, err
+}
+
+// KVSSet calls KVS.Set, and returns the new etag
+func (c *Client) KVSSet(ctx context.Context, key, value string) (string, error) {
+	var rv struct {
+		Etag string `json:"etag"`
+	}
+	err := c.Call(ctx, "KVS.Set", struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	}{key, value}, &rv)
+	return rv.Etag, err
+}
+
+// KVSDelete calls KVS.Delete
+func (c *Client) KVSDelete(ctx context.Context, key string) error {
+	return c.Call(ctx, "KVS.Delete", keyParams{Key: key}, nil)
+}
+
+// KVSList calls KVS.List, and returns the keys matching the pattern match
+// (like "schellydule.*") with their etags
+func (c *Client) KVSList(ctx context.Context, match string) (map[string]string, error) {
+	var rv struct {
+		Keys map[string]struct {
+			Etag string `json:"etag"`
+		} `json:"keys"`
+	}
+	var params interface{}
+	if match != "" {
+		params = struct {
+			Match string `json:"match"`
+		}{match}
+	}
+	if err := c.Call(ctx, "KVS.List", params, &rv); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string, len(rv.Keys))
+	for k, v := range rv.Keys {
+		keys[k] = v.Etag
+	}
+	return keys, nil
+}
+
+// Webhook is a webhook on the Shelly, calling URLs on an event
+type Webhook struct {
+	ID     int      `json:"id,omitempty"`
+	CID    int      `json:"cid"`
+	Enable bool     `json:"enable"`
+	Event  string   `json:"event"`
+	Name   string   `json:"name,omitempty"`
+	URLs   []string `json:"urls"`
+}
+
+// ListWebhooks calls Webhook.List
+func (c *Client) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	var rv struct {
+		Hooks []Webhook `json:"hooks"`
+	}
+	err := c.Call(ctx, "Webhook.List", nil, &rv)
+	return rv.Hooks, err
+}
+
+// CreateWebhook calls Webhook.Create, and returns the ID of the new webhook
+func (c *Client) CreateWebhook(ctx context.Context, h Webhook) (int, error) {
+	h.ID = 0
+	var rv idParams
+	err := c.Call(ctx, "Webhook.Create", h, &rv)
+	return rv.ID, err
+}
+
+// UpdateWebhook calls Webhook.Update
+func (c *Client) UpdateWebhook(ctx context.Context, h Webhook) error {
+	return c.Call(ctx, "Webhook.Update", h, nil)
+}
+
+// DeleteWebhook calls Webhook.Delete
+func (c *Client) DeleteWebhook(ctx context.Context, id int) error {
+	return c.Call(ctx, "Webhook.Delete", idParams{ID: id}, nil)
+}
+
+// DeleteAllWebhooks calls Webhook.DeleteAll
+func (c *Client) DeleteAllWebhooks(ctx context.Context) error {
+	return c.Call(ctx, "Webhook.DeleteAll", nil, nil)
+}
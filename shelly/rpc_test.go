@@ -0,0 +1,193 @@
+package shelly
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adamhassel/schellydule/contx"
+)
+
+// fakeShelly answers JSON-RPC requests with the result or error of the method
+func fakeShelly(t *testing.T, results map[string]string, calls *[]rpcRequest) *Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/rpc" {
+			t.Errorf("got %s %s, want POST /rpc", r.Method, r.URL.Path)
+		}
+		var req rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if calls != nil {
+			*calls = append(*calls, req)
+		}
+		res, ok := results[req.Method]
+		if !ok {
+			res = `{"error":{"code":404,"message":"No handler for ` + req.Method + `"}}`
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(res), &body); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := json.Marshal(req.ID)
+		body["id"] = id
+		body["src"] = json.RawMessage(`"shellyplus1-test"`)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+	return &Client{Addr: strings.TrimPrefix(srv.URL, "http://")}
+}
+
+func TestClient_Call(t *testing.T) {
+	var calls []rpcRequest
+	c := fakeShelly(t, map[string]string{
+		"Switch.GetStatus":   `{"result":{"id":0,"output":true,"apower":1204.5,"aenergy":{"total":3120.25}}}`,
+		"Shelly.GetStatus":   `{"result":{"input:0":{"id":0,"state":true},"sys":{"uptime":120}}}`,
+		"Sys.GetConfig":      `{"result":{"location":{"tz":"Europe/Copenhagen"}}}`,
+		"Schedule.List":      `{"result":{"jobs":[{"id":1,"enable":true,"timespec":"0 0 6 * * *","calls":[{"method":"Switch.Set","params":{"id":0,"on":true}}]}],"rev":3}}`,
+		"KVS.List":           `{"result":{"keys":{"schellydule.a":{"etag":"x"}},"rev":2}}`,
+		"Schedule.DeleteAll": `{"result":null}`,
+		"Switch.Set":         `{"result":{"was_on":true}}`,
+		"EM.GetStatus":       `{"result":{"id":0,"a_act_power":-820.1,"b_act_power":10.5,"c_act_power":-1500.4,"total_act_power":-2310}}`,
+	}, &calls)
+	ctx := context.Background()
+
+	sw, err := c.GetSwitchStatus(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sw.Output || sw.AEnergy.Total != 3120.25 || sw.APower != 1204.5 {
+		t.Errorf("GetSwitchStatus() = %+v", sw)
+	}
+	status, err := c.GetStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in, err := status.Input(0); err != nil || !in.State {
+		t.Errorf("Input(0) = %+v, %v, want on", in, err)
+	}
+	if _, err := status.Switch(0); err == nil {
+		t.Error("Switch(0) of a device without a switch didn't fail")
+	}
+	conf, err := c.GetSysConfig(ctx)
+	if err != nil || conf.Location.TZ != "Europe/Copenhagen" {
+		t.Errorf("GetSysConfig() = %+v, %v", conf, err)
+	}
+	jobs, err := c.ListSchedules(ctx)
+	if err != nil || len(jobs) != 1 || !jobs[0].HasMethod("Switch.Set") {
+		t.Errorf("ListSchedules() = %+v, %v", jobs, err)
+	}
+	keys, err := c.KVSList(ctx, "schellydule.*")
+	if err != nil || keys["schellydule.a"] != "x" {
+		t.Errorf("KVSList() = %v, %v", keys, err)
+	}
+	if err := c.DeleteAllSchedules(ctx); err != nil {
+		t.Errorf("DeleteAllSchedules() = %v", err)
+	}
+	if wasOn, err := c.SetSwitchFor(ctx, 0, false, 90*time.Minute); err != nil || !wasOn {
+		t.Errorf("SetSwitchFor() = %t, %v", wasOn, err)
+	}
+	if got := string(mustMarshal(t, calls[len(calls)-1].Params)); got != `{"id":0,"on":false,"toggle_after":5400}` {
+		t.Errorf("Switch.Set params = %s", got)
+	}
+	if em, err := c.GetEMStatus(ctx, 0); err != nil || em.TotalActPower != -2310 {
+		t.Errorf("GetEMStatus() = %+v, %v", em, err)
+	}
+
+	for i, call := range calls {
+		if call.ID != uint64(i+1) {
+			t.Errorf("call %d has id %d, want %d", i, call.ID, i+1)
+		}
+		if call.Src != rpcSource {
+			t.Errorf("call %d has src %q, want %q", i, call.Src, rpcSource)
+		}
+	}
+	if got := string(mustMarshal(t, calls[0].Params)); got != `{"id":0}` {
+		t.Errorf("Switch.GetStatus params = %s", got)
+	}
+}
+
+func TestClient_CallErrors(t *testing.T) {
+	c := fakeShelly(t, map[string]string{
+		"Schedule.Delete": `{"error":{"code":-105,"message":"Argument 'id', value 7 not found!"}}`,
+		"Webhook.Create":  `{"error":{"code":-103,"message":"Invalid argument 'event'!"}}`,
+	}, nil)
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		call     func() error
+		want     error
+		wantCode int
+	}{
+		{
+			name:     "not found",
+			call:     func() error { return c.DeleteSchedule(ctx, 7) },
+			want:     ErrNotFound,
+			wantCode: CodeNotFound,
+		},
+		{
+			name: "invalid argument",
+			call: func() error {
+				_, err := c.CreateWebhook(ctx, Webhook{Event: "nope"})
+				return err
+			},
+			want:     ErrInvalidArgument,
+			wantCode: CodeInvalidArgument,
+		},
+		{
+			name:     "unknown method",
+			call:     func() error { return c.KVSDelete(ctx, "a") },
+			wantCode: 404,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) {
+				t.Fatalf("error %v is not an *RPCError", err)
+			}
+			if rpcErr.Code != tt.wantCode || rpcErr.Message == "" || rpcErr.Method == "" {
+				t.Errorf("error = %+v, want code %d with a message and method", rpcErr, tt.wantCode)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.want)
+			}
+			if errors.Is(err, ErrUnavailable) {
+				t.Errorf("errors.Is(%v, %v) = true", err, ErrUnavailable)
+			}
+		})
+	}
+}
+
+func TestClient_CallPretend(t *testing.T) {
+	var calls []rpcRequest
+	c := fakeShelly(t, map[string]string{
+		"Schedule.List": `{"result":{"jobs":[]}}`,
+	}, &calls)
+	ctx := contx.WithPretend(context.Background(), true)
+	if err := c.DeleteAllSchedules(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListSchedules(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0].Method != "Schedule.List" {
+		t.Errorf("pretending made calls %+v, want only Schedule.List", calls)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -4,9 +4,7 @@ package shelly
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -18,7 +16,6 @@ import (
 	"github.com/adamhassel/schedule"
 	"github.com/adamhassel/schellydule/contx"
 	"github.com/robfig/cron/v3"
-	"github.com/tidwall/gjson"
 )
 
 const cronFormat = "05 04 15 * * MON,TUE,WED,THU,FRI,SAT,SUN"
@@ -79,22 +76,31 @@ func (s Schedule) Hours() int {
 	return h
 }
 
+// GetSchedules returns the jobs scheduled on the Shelly
 func GetSchedules(ctx context.Context, ip fmt.Stringer) (Schedules, error) {
-	body, _, err := DoRPCCall(ctx, ip, "GET", "Schedule.List", nil, nil)
-	if err != nil {
-		return Schedules{}, err
-	}
-	var schedules Schedule
-	fmt.Println(string(body))
-	err = json.Unmarshal(body, &schedules)
-	return schedules.Jobs, err
+	return NewClient(ip).ListSchedules(ctx)
 }
 
 // ShellySchedule converts a schedule.Schedule to something a Shelly can understand.
 func ShellySchedule(in schedule.Schedule, enable bool) Schedule {
+	return shellySchedule(in, enable, nil)
+}
+
+// ShellyScheduleIn converts a schedule.Schedule to something a Shelly in the
+// time zone loc can understand.
+func ShellyScheduleIn(in schedule.Schedule, enable bool, loc *time.Location) Schedule {
+	return shellySchedule(in, enable, loc)
+}
+
+// shellySchedule converts in to jobs, with times in loc. If loc is nil, the
+// times are used as they are.
+func shellySchedule(in schedule.Schedule, enable bool, loc *time.Location) Schedule {
 	var out Schedule
 	out.Jobs = make(Schedules, 0, len(in)*2)
 	for _, se := range in {
+		if loc != nil {
+			se.Start, se.Stop = se.Start.In(loc), se.Stop.In(loc)
+		}
 		on := JobSpec{
 			Enable:   enable,
 			Timespec: se.Start.Format(cronFormat),
@@ -123,9 +129,15 @@ func ShellySchedule(in schedule.Schedule, enable bool) Schedule {
 	return out
 }
 
-// Time returns the timestamp for the job (with today's date)
+// Time returns the timestamp for the job (with today's date) in the server's time zone
 func (j JobSpec) Time() (time.Time, error) {
-	today := time.Now().Truncate(24 * time.Hour) // truncate to midnight today
+	return j.TimeIn(time.Local)
+}
+
+// TimeIn returns the timestamp for the job on a device in the time zone loc,
+// with today's date in loc
+func (j JobSpec) TimeIn(loc *time.Location) (time.Time, error) {
+	today := schedule.Hour(time.Now().In(loc), 0)
 	t, err := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Second).Parse(j.Timespec)
 	if err != nil {
 		return time.Time{}, err
@@ -150,9 +162,28 @@ func (j JobSpec) HasMethod(m string) bool {
 	return stringInSlice(m, j.Methods())
 }
 
+// IsRefresher returns true if j is the job renewing the schedules, created by
+// CreateScheduleRefresherSchedule
+func (j JobSpec) IsRefresher() bool {
+	for _, c := range j.Calls {
+		if !strings.EqualFold(c.Method, "HTTP.Get") {
+			continue
+		}
+		raw, ok := c.Params["url"].(string)
+		if !ok {
+			continue
+		}
+		if u, err := url.Parse(raw); err == nil && strings.HasSuffix(u.Path, "/renewSchedules") {
+			return true
+		}
+	}
+	return false
+}
+
 func enableDisableSchedules(ctx context.Context, dest fmt.Stringer, enable bool, ids ...int) error {
+	c := NewClient(dest)
 	for _, id := range ids {
-		if _, _, err := DoGet(ctx, dest, "Schedule.Update", map[string]string{"id": fmt.Sprintf("%d", id), "enable": fmt.Sprintf("%t", enable)}); err != nil {
+		if err := c.UpdateSchedule(ctx, ScheduleUpdate{ID: id, Enable: &enable}); err != nil {
 			return err
 		}
 	}
@@ -179,23 +210,25 @@ func TurnOff(ctx context.Context, dest fmt.Stringer) error {
 
 // SetSwitch sets the Shelly's switch to the given state
 func SetSwitch(ctx context.Context, dest fmt.Stringer, state State) error {
-	_, _, err := DoGet(ctx, dest, "Switch.Set", map[string]string{"id": "0", "on": fmt.Sprintf("%t", state)})
+	_, err := NewClient(dest).SetSwitch(ctx, 0, bool(state))
 	return err
 }
 
-func DeleteAllSchedules(ctx context.Context, dest fmt.Stringer) error {
-	_, _, err := DoGet(ctx, dest, "Schedule.DeleteAll", nil)
+// SetSwitchFor sets the Shelly's switch to the given state for d, after which
+// the Shelly switches it back by itself
+func SetSwitchFor(ctx context.Context, dest fmt.Stringer, state State, d time.Duration) error {
+	_, err := NewClient(dest).SetSwitchFor(ctx, 0, bool(state), d)
 	return err
 }
 
+func DeleteAllSchedules(ctx context.Context, dest fmt.Stringer) error {
+	return NewClient(dest).DeleteAllSchedules(ctx)
+}
+
 func CreateSchedule(ctx context.Context, dest fmt.Stringer, s Schedule) error {
+	c := NewClient(dest)
 	for _, j := range s.Jobs {
-		reqBody, err := json.Marshal(j)
-		if err != nil {
-			return err
-		}
-		fmt.Println(string(reqBody))
-		if _, _, err := DoRPCCall(ctx, dest, "POST", "Schedule.Create", nil, reqBody); err != nil {
+		if _, err := c.CreateSchedule(ctx, j); err != nil {
 			return err
 		}
 	}
@@ -214,43 +247,74 @@ func getOutboundIP() (net.IP, error) {
 	return localAddr.IP, nil
 }
 
-// CreateScheduleRefresherSchedule will make sure that the schedules are refreshed every day @ 23.55
-func CreateScheduleRefresherSchedule(ctx context.Context, dest fmt.Stringer, myPort int) error {
-	t := schedule.Hour(time.Now(), 0).Add(1 * time.Minute)
+// CreateScheduleRefresherSchedule will make sure that the schedules of the
+// device called device are refreshed every day @ 00:01
+func CreateScheduleRefresherSchedule(ctx context.Context, dest fmt.Stringer, myPort int, device string) error {
 	ip, err := getOutboundIP()
 	if err != nil {
 		return err
 	}
-	refresh := JobSpec{
-		Id:     refresherID,
-		Enable: true,
-		// Set the timespec to be tomorrow at 23:30: First add 24 hours, to be sure it's tomorrow. Then truncate to midnight, and finally add 23:30
+	_, err = NewClient(dest).CreateSchedule(ctx, RefresherJob(ip, myPort, device))
+	return err
+}
+
+// RefresherJob returns the job calling /renewSchedules on the service at ip and
+// port, every day at 00:01, for the device called device
+func RefresherJob(ip net.IP, port int, device string) JobSpec {
+	t := schedule.Hour(time.Now(), 0).Add(1 * time.Minute)
+	q := url.Values{}
+	q.Set("device", device)
+	return JobSpec{
+		Id:       refresherID,
+		Enable:   true,
 		Timespec: t.Format(cronFormat),
 		Calls: []Call{{
 			Method: "HTTP.Get",
 			Params: map[string]interface{}{
-				"url": fmt.Sprintf("http://%s:%d/renewSchedules", ip.String(), myPort),
+				"url": fmt.Sprintf("http://%s:%d/renewSchedules?%s", ip.String(), port, q.Encode()),
 			},
 		}},
 	}
-	reqBody, err := json.Marshal(refresh)
-	if err != nil {
-		return err
-	}
-	_, _, err = DoRPCCall(ctx, dest, "POST", "Schedule.Create", nil, reqBody)
-	return err
 }
 
 // GetInputState returns true if the controller input is on, false otherwise
 func GetInputState(ctx context.Context, dest fmt.Stringer) (bool, error) {
-	body, _, err := DoGet(ctx, dest, "Shelly.GetStatus", nil)
+	status, err := NewClient(dest).GetStatus(ctx)
 	if err != nil {
 		return false, err
 	}
-	return gjson.GetBytes(body, "input:0.state").Bool(), nil
+	input, err := status.Input(0)
+	return input.State, err
+}
+
+// GetTimeZone returns the time zone configured on the Shelly
+func GetTimeZone(ctx context.Context, dest fmt.Stringer) (*time.Location, error) {
+	conf, err := NewClient(dest).GetSysConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Location.TZ == "" {
+		return nil, errors.New("no time zone configured on the device")
+	}
+	return time.LoadLocation(conf.Location.TZ)
+}
+
+// GetSwitchStatus returns the state of the Shelly's switch
+func GetSwitchStatus(ctx context.Context, dest fmt.Stringer) (SwitchStatus, error) {
+	return NewClient(dest).GetSwitchStatus(ctx, 0)
+}
+
+// GetGridPower returns the power imported from the grid in W, as measured by
+// the energy meter of the Shelly. Exported power is negative.
+func GetGridPower(ctx context.Context, dest fmt.Stringer) (float64, error) {
+	status, err := NewClient(dest).GetEMStatus(ctx, 0)
+	return status.TotalActPower, err
 }
 
-// DoRPCCall calls RPC endpoints towards the Shelly. Returns body (or nil if empty), http response code and an error
+// DoRPCCall calls RPC endpoints towards the Shelly using GET or POST to
+// /rpc/<method>. Returns body (or nil if empty), http response code and an
+// error. Errors from the Shelly are returned as *RPCError. Prefer the typed
+// calls of Client.
 func DoRPCCall(ctx context.Context, dest fmt.Stringer, httpMethod, method string, options map[string]string, reqBody []byte) ([]byte, int, error) {
 	u := url.URL{
 		Scheme: "http",
@@ -264,29 +328,30 @@ func DoRPCCall(ctx context.Context, dest fmt.Stringer, httpMethod, method string
 		}
 		u.RawQuery = values.Encode()
 	}
-	fmt.Println(u.String())
-	req, err := http.NewRequest(httpMethod, u.String(), bytes.NewReader(reqBody))
-	if err != nil {
-		return nil, http.StatusInternalServerError, err
-	}
 	if contx.Pretend(ctx) {
 		log.Print("just pretending, not doing RPC")
 		return nil, http.StatusOK, nil
 	}
-	r, err := http.DefaultClient.Do(req)
-	if err != nil {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	body, status, err := transportFor(u.Host).do(ctx, nil, method, func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, httpMethod, u.String(), bytes.NewReader(reqBody))
+	})
+	if status == 0 {
 		return nil, http.StatusInternalServerError, err
 	}
-	defer r.Body.Close()
-	body, err := ioutil.ReadAll(r.Body)
-	if r.StatusCode != http.StatusOK {
+	if status != http.StatusOK {
+		if rpcErr, ok := decodeRPCError(method, body); ok && err == nil {
+			return body, status, rpcErr
+		}
 		add := errors.New(string(body))
 		if err != nil {
 			add = errors.Wrap(add, fmt.Errorf("\nAdditionally, an error occurred while reading return body: %w", err))
 		}
-		return body, r.StatusCode, fmt.Errorf("RPC call returned %s (%d) %w", r.Status, r.StatusCode, add)
+		return body, status, fmt.Errorf("RPC call returned %s (%d) %w", http.StatusText(status), status, add)
 	}
-	return body, http.StatusOK, nil
+	return body, http.StatusOK, err
 }
 
 func DoGet(ctx context.Context, dest fmt.Stringer, method string, options map[string]string) ([]byte, int, error) {
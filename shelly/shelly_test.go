@@ -1,6 +1,7 @@
 package shelly
 
 import (
+	"net"
 	"reflect"
 	"testing"
 	"time"
@@ -114,3 +115,34 @@ func TestShellySchedule(t *testing.T) {
 		})
 	}
 }
+
+func TestRefresherJob(t *testing.T) {
+	ip := net.ParseIP("192.168.1.10")
+	tests := []struct {
+		device string
+		want   string
+	}{
+		{device: "pool", want: "http://192.168.1.10:8080/renewSchedules?device=pool"},
+		{device: "heater", want: "http://192.168.1.10:8080/renewSchedules?device=heater"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			j := RefresherJob(ip, 8080, tt.device)
+			if got := j.Calls[0].Params["url"]; got != tt.want {
+				t.Errorf("RefresherJob() url = %v, want %s", got, tt.want)
+			}
+			if !j.IsRefresher() {
+				t.Errorf("IsRefresher() = false, want true")
+			}
+		})
+	}
+	j := RefresherJob(ip, 8080, "pool")
+	j.Calls[0].Params["url"] = "http://192.168.1.10:8080/renewSchedules"
+	if !j.IsRefresher() {
+		t.Errorf("IsRefresher() = false for a refresher without a device, want true")
+	}
+	j.Calls[0].Params["url"] = "http://192.168.1.10:8080/boost?device=pool"
+	if j.IsRefresher() {
+		t.Errorf("IsRefresher() = true for %s, want false", j.Calls[0].Params["url"])
+	}
+}
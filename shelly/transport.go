@@ -0,0 +1,277 @@
+package shelly
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adamhassel/errors"
+)
+
+// ErrCircuitOpen is returned without calling the Shelly, when calls to it have
+// failed too many times in a row
+var ErrCircuitOpen = errors.New("circuit breaker open, not calling the Shelly")
+
+// Options configure the calls to a Shelly
+type Options struct {
+	// Timeout is the time allowed for a single attempt. A deadline on the
+	// context of the call also applies. Zero means no timeout
+	Timeout time.Duration
+	// Retries is how many times a call of an idempotent method is retried if
+	// the Shelly can't be reached
+	Retries int
+	// Backoff is the wait before the first retry. It's doubled for each retry,
+	// and up to half of it is random
+	Backoff time.Duration
+	// BreakerThreshold is the number of failed calls in a row that opens the
+	// circuit breaker. Zero means the breaker never opens
+	BreakerThreshold int
+	// BreakerCooldown is how long the breaker stays open, before a call is let
+	// through to see if the Shelly is back
+	BreakerCooldown time.Duration
+	// HTTP is the client used for the calls. If nil, a client with Timeout is used
+	HTTP *http.Client
+}
+
+// DefaultOptions are used for Shellies that aren't configured
+var DefaultOptions = Options{
+	Timeout:          10 * time.Second,
+	Retries:          2,
+	Backoff:          500 * time.Millisecond,
+	BreakerThreshold: 5,
+	BreakerCooldown:  time.Minute,
+}
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed means calls go through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen means calls fail with ErrCircuitOpen
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen means a single call goes through, to see if the Shelly is back
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerStatus is the state of the circuit breaker of a Shelly
+type BreakerStatus struct {
+	Addr  string       `json:"addr"`
+	State BreakerState `json:"state"`
+	// Failures is the number of failed calls in a row
+	Failures  int    `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+	// RetryAt is when an open breaker lets a call through again
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+}
+
+// breaker stops calls to a Shelly after too many failures in a row
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     BreakerState
+	failures  int
+	lastErr   string
+	openedAt  time.Time
+}
+
+// allow returns ErrCircuitOpen if a call may not be made now
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if retry := b.openedAt.Add(b.cooldown); time.Now().Before(retry) {
+			return fmt.Errorf("%w until %s: %s", ErrCircuitOpen, retry.Format(time.RFC3339), b.lastErr)
+		}
+		b.state = BreakerHalfOpen
+		return nil
+	case BreakerHalfOpen:
+		// Another call is already finding out if the Shelly is back
+		return fmt.Errorf("%w: %s", ErrCircuitOpen, b.lastErr)
+	}
+	return nil
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.failures, b.lastErr = BreakerClosed, 0, ""
+}
+
+func (b *breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr = err.Error()
+	if b.state == BreakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state, b.openedAt = BreakerOpen, time.Now()
+	}
+}
+
+// abort ends a call that neither failed nor succeeded
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+	}
+}
+
+func (b *breaker) status(addr string) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rv := BreakerStatus{Addr: addr, State: b.state, Failures: b.failures, LastError: b.lastErr}
+	if b.state == BreakerOpen {
+		retry := b.openedAt.Add(b.cooldown)
+		rv.RetryAt = &retry
+	}
+	return rv
+}
+
+// transport makes the HTTP calls to a single Shelly
+type transport struct {
+	addr    string
+	opts    Options
+	client  *http.Client
+	breaker *breaker
+}
+
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[string]*transport)
+)
+
+// Configure sets the options for calls to the Shelly at dest. The state of its
+// circuit breaker is reset.
+func Configure(dest fmt.Stringer, o Options) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[dest.String()] = newTransport(dest.String(), o)
+}
+
+func newTransport(addr string, o Options) *transport {
+	client := o.HTTP
+	if client == nil {
+		client = &http.Client{Timeout: o.Timeout}
+	}
+	return &transport{
+		addr:    addr,
+		opts:    o,
+		client:  client,
+		breaker: &breaker{threshold: o.BreakerThreshold, cooldown: o.BreakerCooldown, state: BreakerClosed},
+	}
+}
+
+// transportFor returns the transport for addr, with DefaultOptions if it isn't configured
+func transportFor(addr string) *transport {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	t, ok := transports[addr]
+	if !ok {
+		t = newTransport(addr, DefaultOptions)
+		transports[addr] = t
+	}
+	return t
+}
+
+// Breaker returns the state of the circuit breaker of the Shelly at dest
+func Breaker(dest fmt.Stringer) BreakerStatus {
+	return transportFor(dest.String()).breaker.status(dest.String())
+}
+
+// Breakers returns the state of the circuit breakers of all Shellies called
+// or configured, sorted by address
+func Breakers() []BreakerStatus {
+	transportsMu.Lock()
+	list := make([]*transport, 0, len(transports))
+	for _, t := range transports {
+		list = append(list, t)
+	}
+	transportsMu.Unlock()
+	rv := make([]BreakerStatus, 0, len(list))
+	for _, t := range list {
+		rv = append(rv, t.breaker.status(t.addr))
+	}
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Addr < rv[j].Addr })
+	return rv
+}
+
+// do makes the request built by newReq, retrying idempotent methods if the
+// Shelly can't be reached. client overrides the client of the transport, if
+// not nil. Returns the body and status of the response. Any response from the
+// Shelly, even an error, counts as a success for the circuit breaker, and a
+// call failing all its attempts as a single failure.
+func (t *transport) do(ctx context.Context, client *http.Client, method string, newReq func(ctx context.Context) (*http.Request, error)) ([]byte, int, error) {
+	if client == nil {
+		client = t.client
+	}
+	attempts := 1
+	if idempotent(method) {
+		attempts += t.opts.Retries
+	}
+	if err := t.breaker.allow(); err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", t.addr, err)
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				t.breaker.abort()
+				return nil, 0, fmt.Errorf("%w (after: %s)", ctx.Err(), err)
+			case <-time.After(backoff(t.opts.Backoff, i)):
+			}
+		}
+		var req *http.Request
+		if req, err = newReq(ctx); err != nil {
+			t.breaker.abort()
+			return nil, 0, err
+		}
+		var r *http.Response
+		r, err = client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Our caller gave up, which says nothing about the Shelly
+				t.breaker.abort()
+				return nil, 0, err
+			}
+			continue
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		t.breaker.success()
+		return body, r.StatusCode, err
+	}
+	t.breaker.failure(err)
+	return nil, 0, err
+}
+
+// backoff returns the wait before retry number n (starting at 1)
+func backoff(base time.Duration, n int) time.Duration {
+	d := base << (n - 1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// idempotent returns true if calling method twice has the same effect as once
+func idempotent(method string) bool {
+	if readOnly(method) {
+		return true
+	}
+	action := method[strings.LastIndex(method, ".")+1:]
+	switch action {
+	case "Set", "SetConfig", "Update", "DeleteAll":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package shelly
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyShelly drops the connection for the first failures calls, and then
+// answers with an empty result after delay
+func flakyShelly(t *testing.T, failures int32, delay time.Duration, o Options) (*Client, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			return
+		}
+		time.Sleep(delay)
+		var req rpcRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "result": map[string]interface{}{}})
+	}))
+	t.Cleanup(srv.Close)
+	Configure(srv.Listener.Addr(), o)
+	return NewClient(srv.Listener.Addr()), &calls
+}
+
+func TestTransport_Retries(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		failures  int32
+		wantErr   bool
+		wantCalls int32
+	}{
+		{name: "retried until it answers", method: "Switch.GetStatus", failures: 2, wantCalls: 3},
+		{name: "set is retried", method: "Switch.Set", failures: 1, wantCalls: 2},
+		{name: "gives up", method: "Switch.GetStatus", failures: 5, wantErr: true, wantCalls: 3},
+		{name: "create isn't retried", method: "Schedule.Create", failures: 1, wantErr: true, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, calls := flakyShelly(t, tt.failures, 0, Options{Retries: 2, Backoff: time.Millisecond})
+			err := c.Call(context.Background(), tt.method, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Call() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("Shelly was called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTransport_Breaker(t *testing.T) {
+	c, calls := flakyShelly(t, 2, 0, Options{BreakerThreshold: 2, BreakerCooldown: 50 * time.Millisecond})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := c.Call(ctx, "Switch.GetStatus", nil, nil); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d error = %v, want a connection error", i, err)
+		}
+	}
+	status := Breaker(addr(c.Addr))
+	if status.State != BreakerOpen || status.Failures != 2 || status.RetryAt == nil || status.LastError == "" {
+		t.Errorf("breaker = %+v, want open after 2 failures", status)
+	}
+	if err := c.Call(ctx, "Switch.GetStatus", nil, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Call() with open breaker error = %v, want %v", err, ErrCircuitOpen)
+	}
+	if got := atomic.LoadInt32(calls); got != 2 {
+		t.Errorf("Shelly was called %d times with an open breaker, want 2", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := c.Call(ctx, "Switch.GetStatus", nil, nil); err != nil {
+		t.Errorf("Call() after cooldown error = %v", err)
+	}
+	if status := Breaker(addr(c.Addr)); status.State != BreakerClosed || status.Failures != 0 {
+		t.Errorf("breaker = %+v, want closed after a successful call", status)
+	}
+}
+
+func TestTransport_BreakerRetries(t *testing.T) {
+	c, calls := flakyShelly(t, 3, 0, Options{Retries: 2, Backoff: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Minute})
+	if err := c.Call(context.Background(), "Switch.GetStatus", nil, nil); err == nil {
+		t.Fatal("Call() didn't fail")
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("Shelly was called %d times, want 3", got)
+	}
+	if status := Breaker(addr(c.Addr)); status.State != BreakerClosed || status.Failures != 1 {
+		t.Errorf("breaker = %+v, want closed after 1 failed call", status)
+	}
+}
+
+func TestTransport_Timeout(t *testing.T) {
+	t.Run("per attempt", func(t *testing.T) {
+		c, _ := flakyShelly(t, 0, 200*time.Millisecond, Options{Timeout: 20 * time.Millisecond, BreakerThreshold: 1, BreakerCooldown: time.Minute})
+		start := time.Now()
+		if err := c.Call(context.Background(), "Switch.GetStatus", nil, nil); err == nil {
+			t.Error("Call() of a hung Shelly didn't fail")
+		}
+		if d := time.Since(start); d > 150*time.Millisecond {
+			t.Errorf("Call() took %s", d)
+		}
+		if status := Breaker(addr(c.Addr)); status.State != BreakerOpen {
+			t.Errorf("breaker = %+v, want open after a timeout", status)
+		}
+	})
+	t.Run("context deadline", func(t *testing.T) {
+		c, _ := flakyShelly(t, 0, 200*time.Millisecond, Options{Retries: 2, BreakerThreshold: 1, BreakerCooldown: time.Minute})
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if err := c.Call(ctx, "Switch.GetStatus", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Call() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+		if d := time.Since(start); d > 150*time.Millisecond {
+			t.Errorf("Call() took %s", d)
+		}
+		if status := Breaker(addr(c.Addr)); status.State != BreakerClosed {
+			t.Errorf("breaker = %+v, want closed when the caller gives up", status)
+		}
+	})
+}
+
+type addr string
+
+func (a addr) String() string {
+	return string(a)
+}
@@ -0,0 +1,63 @@
+package shelly
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/adamhassel/schedule"
+)
+
+func TestJobSpec_TimeIn(t *testing.T) {
+	j := JobSpec{Timespec: "0 15 6 * * MON,TUE,WED,THU,FRI,SAT,SUN"}
+	for _, zone := range []string{"UTC", "Europe/Copenhagen", "America/Los_Angeles", "Australia/Sydney"} {
+		t.Run(zone, func(t *testing.T) {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := j.TimeIn(loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			y, m, d := time.Now().In(loc).Date()
+			want := time.Date(y, m, d, 6, 15, 0, 0, loc)
+			if !got.Equal(want) {
+				t.Errorf("TimeIn() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestShellyScheduleIn(t *testing.T) {
+	start := time.Date(2022, 7, 1, 20, 0, 0, 0, time.UTC)
+	in := schedule.Schedule{{Start: start, Stop: start.Add(time.Hour)}}
+	tests := []struct {
+		zone    string
+		wantOn  string
+		wantOff string
+	}{
+		{zone: "UTC", wantOn: "00 00 20", wantOff: "00 00 21"},
+		{zone: "Europe/Copenhagen", wantOn: "00 00 22", wantOff: "00 00 23"},
+		{zone: "America/New_York", wantOn: "00 00 16", wantOff: "00 00 17"},
+		{zone: "Asia/Kolkata", wantOn: "00 30 01", wantOff: "00 30 02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ShellyScheduleIn(in, true, loc)
+			if len(got.Jobs) != 2 {
+				t.Fatalf("ShellyScheduleIn() got %d jobs, want 2", len(got.Jobs))
+			}
+			if on := got.Jobs[0].Timespec[:8]; on != tt.wantOn {
+				t.Errorf("ShellyScheduleIn() on = %q, want %q", on, tt.wantOn)
+			}
+			if off := got.Jobs[1].Timespec[:8]; off != tt.wantOff {
+				t.Errorf("ShellyScheduleIn() off = %q, want %q", off, tt.wantOff)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package schellydule
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// The baseline of a backtest runs every day between these times of day
+const (
+	baselineStart = 8 * time.Hour
+	baselineStop  = 20 * time.Hour
+)
+
+// Planner plans the day starting at day, using prices
+type Planner func(day time.Time, prices sch.HourPrices) (Plan, error)
+
+// Backtest is the result of replaying a planner on historical prices. Costs
+// are for a 1 kW load.
+type Backtest struct {
+	Days []BacktestDay
+	// Runtime and Cost are the totals of the days that could be planned
+	Runtime time.Duration
+	Cost    float64
+	// BaselineRuntime and BaselineCost are the totals of always running
+	// between 08:00 and 20:00 on the same days
+	BaselineRuntime time.Duration
+	BaselineCost    float64
+	// Failed is the number of days that couldn't be planned
+	Failed int
+}
+
+// BacktestDay is the result of a single day of a backtest
+type BacktestDay struct {
+	Day      time.Time
+	Schedule sch.Schedule
+	Runtime  time.Duration
+	Cost     float64
+	// BaselineCost is the cost of running between 08:00 and 20:00
+	BaselineCost float64
+	// Err is set if the day couldn't be planned
+	Err error
+}
+
+// AvgPrice returns the average price per kWh paid
+func (b Backtest) AvgPrice() float64 {
+	return avgPrice(b.Cost, b.Runtime)
+}
+
+// BaselineAvgPrice returns the average price per kWh the baseline paid
+func (b Backtest) BaselineAvgPrice() float64 {
+	return avgPrice(b.BaselineCost, b.BaselineRuntime)
+}
+
+// Savings returns how much cheaper the runtime was than the same runtime at
+// the average price of the baseline
+func (b Backtest) Savings() float64 {
+	return (b.BaselineAvgPrice() - b.AvgPrice()) * b.Runtime.Hours()
+}
+
+func avgPrice(cost float64, runtime time.Duration) float64 {
+	if runtime <= 0 {
+		return 0
+	}
+	return cost / runtime.Hours()
+}
+
+// Simulate replays plan on prices for each day from the date of `from` to the
+// date of `to`, both included, in the time zone of `from`. Days without prices
+// are skipped.
+func Simulate(prices sch.HourPrices, from, to time.Time, plan Planner) Backtest {
+	var rv Backtest
+	last := sch.Hour(to.In(from.Location()), 0)
+	for day := sch.Hour(from, 0); !day.After(last); day = day.AddDate(0, 0, 1) {
+		next := day.AddDate(0, 0, 1)
+		var dayPrices sch.HourPrices
+		for _, hp := range prices {
+			if !hp.Hour.Before(day) && hp.Hour.Before(next) {
+				dayPrices = append(dayPrices, hp)
+			}
+		}
+		if len(dayPrices) == 0 {
+			continue
+		}
+		d := BacktestDay{Day: day}
+		baseline := SlotsFromHourPrices(dayPrices).In(day.Location()).Window(atClock(day, baselineStart), atClock(day, baselineStop))
+		d.BaselineCost = baseline.Cost()
+		p, err := plan(day, dayPrices)
+		if err != nil {
+			d.Err = err
+			rv.Failed++
+			rv.Days = append(rv.Days, d)
+			continue
+		}
+		d.Schedule, d.Cost = p.Schedule, p.Cost
+		for _, e := range p.Schedule {
+			d.Runtime += e.Stop.Sub(e.Start)
+		}
+		rv.Runtime += d.Runtime
+		rv.Cost += d.Cost
+		rv.BaselineCost += d.BaselineCost
+		for _, s := range baseline {
+			rv.BaselineRuntime += s.Length
+		}
+		rv.Days = append(rv.Days, d)
+	}
+	return rv
+}
+
+// csvTimeFormats are the accepted formats of the time column in price CSV files
+var csvTimeFormats = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04"}
+
+// ReadPricesCSV reads hourly prices from r. Each line has the start of the hour
+// and the price per kWh, like "2022-07-01T13:00:00+02:00,1.87". Times without
+// a time zone are in loc. A header line is skipped.
+func ReadPricesCSV(r io.Reader, loc *time.Location) (sch.HourPrices, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+	var rv sch.HourPrices
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rv, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseCSVTime(rec[0], loc)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid price %q", line, rec[1])
+		}
+		rv = append(rv, sch.HourPrice{Hour: t, Price: price})
+	}
+}
+
+func parseCSVTime(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, f := range csvTimeFormats {
+		if t, err := time.ParseInLocation(f, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
@@ -0,0 +1,104 @@
+package schellydule
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// dayPrices returns hourly prices for n days from `day`, priced 1 at night
+// (before 08:00 and from 20:00) and 3 during the day
+func dayPrices(n int) sch.HourPrices {
+	var rv sch.HourPrices
+	for h := 0; h < 24*n; h++ {
+		t := day.Add(time.Duration(h) * time.Hour)
+		price := 3.0
+		if t.Hour() < 8 || t.Hour() >= 20 {
+			price = 1
+		}
+		rv = append(rv, sch.HourPrice{Hour: t, Price: price})
+	}
+	return rv
+}
+
+func TestSimulate(t *testing.T) {
+	prices := dayPrices(3)
+	plan := func(d time.Time, hp sch.HourPrices) (Plan, error) {
+		if d.Day() == 2 {
+			return Plan{}, ErrInfeasible
+		}
+		return SlotsFromHourPrices(hp).Cheapest(4, Constraints{})
+	}
+	got := Simulate(prices, day, day.AddDate(0, 0, 5), plan)
+	if len(got.Days) != 3 {
+		t.Fatalf("Simulate() got %d days, want 3", len(got.Days))
+	}
+	if got.Failed != 1 {
+		t.Errorf("Simulate() failed = %d, want 1", got.Failed)
+	}
+	if got.Runtime != 8*time.Hour {
+		t.Errorf("Simulate() runtime = %s, want 8h", got.Runtime)
+	}
+	if got.Cost != 8 {
+		t.Errorf("Simulate() cost = %f, want 8", got.Cost)
+	}
+	if got.BaselineRuntime != 24*time.Hour || got.BaselineCost != 72 {
+		t.Errorf("Simulate() baseline = %s, %f, want 24h, 72", got.BaselineRuntime, got.BaselineCost)
+	}
+	if got.AvgPrice() != 1 || got.BaselineAvgPrice() != 3 {
+		t.Errorf("Simulate() average prices = %f, %f, want 1, 3", got.AvgPrice(), got.BaselineAvgPrice())
+	}
+	if math.Abs(got.Savings()-16) > 1e-9 {
+		t.Errorf("Simulate() savings = %f, want 16", got.Savings())
+	}
+}
+
+func TestReadPricesCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "header and RFC 3339",
+			in:   "time,price\n2022-07-01T00:00:00Z,1.5\n2022-07-01T01:00:00Z,2\n",
+			want: 2,
+		},
+		{
+			name: "local times and comments",
+			in:   "# prices\n2022-07-01 00:00, 1.5\n2022-07-01 01:00, 2\n2022-07-01 02:00, 2.5\n",
+			want: 3,
+		},
+		{
+			name:    "invalid price",
+			in:      "2022-07-01 00:00,cheap\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			in:      "2022-07-01 00:00,1\nyesterday,2\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadPricesCSV(strings.NewReader(tt.in), time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadPricesCSV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != tt.want {
+				t.Fatalf("ReadPricesCSV() got %d prices, want %d", len(got), tt.want)
+			}
+			if !got[0].Hour.Equal(day) || got[0].Price != 1.5 {
+				t.Errorf("ReadPricesCSV() first = %v, want %s at 1.5", got[0], day)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package schellydule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+// Meter reads the power flowing to or from the grid
+type Meter interface {
+	// GridPower returns the power imported from the grid in W. Exported power
+	// is negative.
+	GridPower(ctx context.Context) (float64, error)
+}
+
+// ShellyMeter reads the grid power from the energy meter of a Shelly 3EM or
+// Pro 3EM, with EM.GetStatus
+type ShellyMeter struct {
+	Addr fmt.Stringer
+}
+
+// GridPower implements Meter
+func (m ShellyMeter) GridPower(ctx context.Context) (float64, error) {
+	return shelly.GetGridPower(ctx, m.Addr)
+}
+
+// HTTPMeter reads the grid power from a field of a JSON document, like the
+// status of an inverter
+type HTTPMeter struct {
+	URL string
+	// Field is the path to the power in W in the document, with the keys of
+	// objects and the indexes of arrays separated by dots, like "emeters.0.power"
+	Field string
+	// ExportPositive is true if the field is positive when power is exported
+	ExportPositive bool
+}
+
+// GridPower implements Meter
+func (m HTTPMeter) GridPower(ctx context.Context) (float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%s: %s", m.URL, resp.Status)
+	}
+	var doc interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return 0, fmt.Errorf("%s: %w", m.URL, err)
+	}
+	v, err := jsonField(doc, m.Field)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", m.URL, err)
+	}
+	if m.ExportPositive {
+		return -v, nil
+	}
+	return v, nil
+}
+
+// jsonField returns the number at path in doc
+func jsonField(doc interface{}, path string) (float64, error) {
+	v := doc
+	for _, key := range strings.Split(path, ".") {
+		switch e := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = e[key]; !ok {
+				return 0, fmt.Errorf("no field %q in %q", key, path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(e) {
+				return 0, fmt.Errorf("no index %q in %q", key, path)
+			}
+			v = e[i]
+		default:
+			return 0, fmt.Errorf("%q isn't an object or array in %q", key, path)
+		}
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("%q isn't a number", path)
+}
+
+// SolarLength returns how long of length a device running according to s can
+// run on solar power from now, with the runtime taken from the runs after it,
+// as Boost does with deduct. It's zero if s already runs at now, or if there's
+// no runtime left to take.
+func SolarLength(s sch.Schedule, now time.Time, length time.Duration) time.Duration {
+	var later time.Duration
+	for _, e := range s {
+		if !e.Start.After(now) && e.Stop.After(now) {
+			return 0
+		}
+		if !e.Start.Before(now.Add(length)) {
+			later += e.Stop.Sub(e.Start)
+		}
+	}
+	// Runs starting after a shorter solar run are a superset of the ones
+	// counted, so all of it can be taken
+	if later < length {
+		return later
+	}
+	return length
+}
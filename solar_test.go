@@ -0,0 +1,69 @@
+package schellydule
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+func TestHTTPMeter_GridPower(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"emeters":[{"power":-1250.5},{"power":310}],"inverter":{"feed_in":"870"},"name":"roof"}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name           string
+		field          string
+		exportPositive bool
+		want           float64
+		wantErr        bool
+	}{
+		{name: "array", field: "emeters.0.power", want: -1250.5},
+		{name: "second meter", field: "emeters.1.power", want: 310},
+		{name: "string export", field: "inverter.feed_in", exportPositive: true, want: -870},
+		{name: "missing field", field: "emeters.0.current", wantErr: true},
+		{name: "bad index", field: "emeters.2.power", wantErr: true},
+		{name: "not a number", field: "name", wantErr: true},
+		{name: "not an object", field: "name.power", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HTTPMeter{URL: srv.URL, Field: tt.field, ExportPositive: tt.exportPositive}.GridPower(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GridPower() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GridPower() = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSolarLength(t *testing.T) {
+	s := sch.Schedule{entry(1, 3, 0), entry(10, 11, 0), entry(20, 22, 0)}
+	tests := []struct {
+		name   string
+		now    time.Duration
+		length time.Duration
+		want   time.Duration
+	}{
+		{name: "from later runs", now: 12 * time.Hour, length: time.Hour, want: time.Hour},
+		{name: "during a run", now: 2 * time.Hour, length: time.Hour, want: 0},
+		{name: "at the end of a run", now: 3 * time.Hour, length: time.Hour, want: time.Hour},
+		{name: "little left", now: 12 * time.Hour, length: 3 * time.Hour, want: 2 * time.Hour},
+		{name: "runs starting during it don't count", now: 19 * time.Hour, length: 2 * time.Hour, want: 0},
+		{name: "nothing left", now: 23 * time.Hour, length: time.Hour, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SolarLength(s, day.Add(tt.now), tt.length); got != tt.want {
+				t.Errorf("SolarLength() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
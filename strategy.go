@@ -0,0 +1,150 @@
+package schellydule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Strategy is the method used to select the slots to run in
+type Strategy string
+
+const (
+	// StrategyCheapest selects a fixed number of the cheapest hours
+	StrategyCheapest Strategy = "cheapest"
+	// StrategyThreshold selects every hour with a price below a limit
+	StrategyThreshold Strategy = "threshold"
+	// StrategyDeadline selects the cheapest hours between an earliest start and
+	// a deadline
+	StrategyDeadline Strategy = "deadline"
+	// StrategySpread selects the cheapest hours, with a limit on the time between
+	// runs
+	StrategySpread Strategy = "spread"
+	// StrategyFixed selects the hours in fixed periods of the day, regardless
+	// of the price
+	StrategyFixed Strategy = "fixed"
+	// StrategyGreenest selects a fixed number of the hours with the lowest CO2
+	// emission
+	StrategyGreenest Strategy = "greenest"
+	// StrategyWeighted selects a fixed number of the hours with the lowest
+	// price plus a price put on their CO2 emission
+	StrategyWeighted Strategy = "weighted"
+)
+
+var strategies = []Strategy{StrategyCheapest, StrategyThreshold, StrategyDeadline, StrategySpread, StrategyFixed, StrategyGreenest, StrategyWeighted}
+
+// ParseStrategy returns the strategy called s. An empty string is StrategyCheapest
+func ParseStrategy(s string) (Strategy, error) {
+	if s == "" {
+		return StrategyCheapest, nil
+	}
+	for _, e := range strategies {
+		if strings.EqualFold(s, string(e)) {
+			return e, nil
+		}
+	}
+	return "", fmt.Errorf("unknown strategy %q", s)
+}
+
+// Threshold returns every slot in s priced below limit. At least min slots are
+// returned, topping up with the cheapest remaining slots, and if max is
+// positive, at most max slots, keeping the cheapest.
+func (s Slots) Threshold(limit float64, min, max int) Slots {
+	byPrice := make(Slots, len(s))
+	copy(byPrice, s)
+	sort.SliceStable(byPrice, func(i, j int) bool { return byPrice[i].Price < byPrice[j].Price })
+	n := 0
+	for n < len(byPrice) && byPrice[n].Price < limit {
+		n++
+	}
+	if n < min {
+		n = min
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	if n > len(byPrice) {
+		n = len(byPrice)
+	}
+	rv := byPrice[:n]
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Start.Before(rv[j].Start) })
+	return rv
+}
+
+// Window returns the slots in s that lie entirely within from and to
+func (s Slots) Window(from, to time.Time) Slots {
+	rv := make(Slots, 0, len(s))
+	for _, e := range s {
+		if e.Start.Before(from) || e.End().After(to) {
+			continue
+		}
+		rv = append(rv, e)
+	}
+	return rv
+}
+
+// Between returns the slots in s that lie entirely within the times of day
+// from and to. The period crosses midnight if to is before from.
+func (s Slots) Between(from, to time.Duration) Slots {
+	rv := make(Slots, 0, len(s))
+	for _, e := range s {
+		start := sinceMidnight(e.Start)
+		end := start + e.Length
+		if from < to && start >= from && end <= to ||
+			to < from && (start >= from && end <= 24*time.Hour || end <= to) {
+			rv = append(rv, e)
+		}
+	}
+	return rv
+}
+
+// OverlapsClock returns true if the slot overlaps the period of the day from
+// the time of day from to to, on any day. The period crosses midnight if to is
+// before from.
+func (s Slot) OverlapsClock(from, to time.Duration) bool {
+	if to <= from {
+		to += 24 * time.Hour
+	}
+	start := sinceMidnight(s.Start)
+	end := start + s.Length
+	// The period may start the day before, the day of or the day after the slot
+	for day := -24 * time.Hour; day <= 24*time.Hour; day += 24 * time.Hour {
+		if start < to+day && end > from+day {
+			return true
+		}
+	}
+	return false
+}
+
+// sinceMidnight returns the time of day of t
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// DeadlineWindow returns the window that ends at the first time of day `by`
+// after t, and starts at the time of day `earliest` before that, but not before
+// t. The window may cross midnight. If earliest and by are the same, the window
+// is 24 hours long.
+func DeadlineWindow(t time.Time, earliest, by time.Duration) (time.Time, time.Time) {
+	day := t
+	end := atClock(day, by)
+	if !end.After(t) {
+		day = day.AddDate(0, 0, 1)
+		end = atClock(day, by)
+	}
+	start := atClock(day, earliest)
+	if !start.Before(end) {
+		start = atClock(day.AddDate(0, 0, -1), earliest)
+	}
+	if start.Before(t) {
+		start = t
+	}
+	return start, end
+}
+
+// atClock returns the time on t's date at the time of day c
+func atClock(t time.Time, c time.Duration) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, int(c/time.Hour), int(c%time.Hour/time.Minute), 0, 0, t.Location())
+}
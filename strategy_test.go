@@ -0,0 +1,173 @@
+package schellydule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSlots_Threshold(t *testing.T) {
+	tests := []struct {
+		name  string
+		s     Slots
+		limit float64
+		min   int
+		max   int
+		want  []int
+	}{
+		{
+			name:  "below limit",
+			s:     hourSlots(1, 5, 2, 9, 3, 4),
+			limit: 3,
+			want:  []int{0, 2},
+		},
+		{
+			name:  "negative prices only",
+			s:     hourSlots(1, -1, 2, -3, 0, 4),
+			limit: 0,
+			want:  []int{1, 3},
+		},
+		{
+			name:  "topped up to minimum",
+			s:     hourSlots(1, 5, 2, 9, 3, 4),
+			limit: 2,
+			min:   3,
+			want:  []int{0, 2, 4},
+		},
+		{
+			name:  "capped at maximum",
+			s:     hourSlots(1, 5, 2, 9, 3, 4),
+			limit: 10,
+			max:   2,
+			want:  []int{0, 2},
+		},
+		{
+			name:  "minimum larger than available",
+			s:     hourSlots(1, 5),
+			limit: 0,
+			min:   3,
+			want:  []int{0, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := startHours(tt.s.Threshold(tt.limit, tt.min, tt.max)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Threshold() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeadlineWindow(t *testing.T) {
+	clock := func(h, m int) time.Duration { return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute }
+	at := func(d, h int) time.Time { return day.AddDate(0, 0, d).Add(time.Duration(h) * time.Hour) }
+	tests := []struct {
+		name      string
+		t         time.Time
+		earliest  time.Duration
+		by        time.Duration
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "same day",
+			t:         at(0, 0),
+			earliest:  clock(8, 0),
+			by:        clock(17, 0),
+			wantStart: at(0, 8),
+			wantEnd:   at(0, 17),
+		},
+		{
+			name:      "across midnight, from midnight",
+			t:         at(0, 0),
+			earliest:  clock(22, 0),
+			by:        clock(7, 0),
+			wantStart: at(0, 0),
+			wantEnd:   at(0, 7),
+		},
+		{
+			name:      "across midnight, in the evening",
+			t:         at(0, 21),
+			earliest:  clock(22, 0),
+			by:        clock(7, 0),
+			wantStart: at(0, 22),
+			wantEnd:   at(1, 7),
+		},
+		{
+			name:      "started already",
+			t:         at(0, 10),
+			earliest:  clock(8, 0),
+			by:        clock(17, 0),
+			wantStart: at(0, 10),
+			wantEnd:   at(0, 17),
+		},
+		{
+			name:      "whole day",
+			t:         at(0, 0),
+			earliest:  0,
+			by:        clock(24, 0),
+			wantStart: at(0, 0),
+			wantEnd:   at(1, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := DeadlineWindow(tt.t, tt.earliest, tt.by)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("DeadlineWindow() = %s - %s, want %s - %s", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestSlots_Between(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int
+		want     []int
+	}{
+		{name: "morning", from: 1, to: 3, want: []int{1, 2}},
+		{name: "crossing midnight", from: 4, to: 2, want: []int{0, 1, 4, 5}},
+		{name: "until midnight", from: 4, to: 24, want: []int{4, 5}},
+		{name: "nothing", from: 7, to: 8, want: []int{}},
+	}
+	s := hourSlots(1, 2, 3, 4, 5, 6)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Between(time.Duration(tt.from)*time.Hour, time.Duration(tt.to)*time.Hour)
+			want := make(Slots, 0, len(tt.want))
+			for _, i := range tt.want {
+				want = append(want, s[i])
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Between() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSlot_OverlapsClock(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    time.Duration
+		length   time.Duration
+		from, to time.Duration
+		want     bool
+	}{
+		{name: "inside", start: 23 * time.Hour, length: time.Hour, from: 22 * time.Hour, to: 7 * time.Hour, want: true},
+		{name: "after midnight", start: 3 * time.Hour, length: time.Hour, from: 22 * time.Hour, to: 7 * time.Hour, want: true},
+		{name: "partly", start: 21*time.Hour + 30*time.Minute, length: time.Hour, from: 22 * time.Hour, to: 7 * time.Hour, want: true},
+		{name: "before", start: 21 * time.Hour, length: time.Hour, from: 22 * time.Hour, to: 7 * time.Hour},
+		{name: "after", start: 7 * time.Hour, length: time.Hour, from: 22 * time.Hour, to: 7 * time.Hour},
+		{name: "daytime", start: 12 * time.Hour, length: 15 * time.Minute, from: 12 * time.Hour, to: 13 * time.Hour, want: true},
+		{name: "outside daytime", start: 13 * time.Hour, length: 15 * time.Minute, from: 12 * time.Hour, to: 13 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Slot{Start: day.Add(tt.start), Length: tt.length}
+			if got := s.OverlapsClock(tt.from, tt.to); got != tt.want {
+				t.Errorf("OverlapsClock() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package schellydule
+
+import (
+	"math"
+	"time"
+
+	"github.com/adamhassel/errors"
+)
+
+var (
+	// ErrPolarNight is returned when the sun doesn't rise on a day
+	ErrPolarNight = errors.New("the sun doesn't rise")
+	// ErrMidnightSun is returned when the sun doesn't set on a day
+	ErrMidnightSun = errors.New("the sun doesn't set")
+)
+
+// zenith is the angle of the sun at sunrise and sunset, accounting for
+// refraction and the size of the sun
+const zenith = 90.833
+
+// Sun returns the time of sunrise and sunset on t's date at the given latitude
+// and longitude (in degrees, north and east positive), in t's location. It
+// uses the algorithm from the Almanac for Computers, which is accurate to a
+// couple of minutes.
+func Sun(t time.Time, lat, lon float64) (sunrise, sunset time.Time, err error) {
+	sunrise, err = sunEvent(t, lat, lon, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	sunset, err = sunEvent(t, lat, lon, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return sunrise, sunset, nil
+}
+
+func sunEvent(t time.Time, lat, lon float64, rising bool) (time.Time, error) {
+	rad := func(d float64) float64 { return d * math.Pi / 180 }
+	deg := func(r float64) float64 { return r * 180 / math.Pi }
+	mod := func(a, b float64) float64 { return math.Mod(math.Mod(a, b)+b, b) }
+
+	lngHour := lon / 15
+	approx := 18.0
+	if rising {
+		approx = 6
+	}
+	n := float64(t.YearDay()) + (approx-lngHour)/24
+	// The sun's mean anomaly and true longitude
+	m := 0.9856*n - 3.289
+	l := mod(m+1.916*math.Sin(rad(m))+0.020*math.Sin(rad(2*m))+282.634, 360)
+	// The sun's right ascension, in the same quadrant as l, in hours
+	ra := mod(deg(math.Atan(0.91764*math.Tan(rad(l)))), 360)
+	ra += math.Floor(l/90)*90 - math.Floor(ra/90)*90
+	ra /= 15
+	// The sun's declination and local hour angle
+	sinDec := 0.39782 * math.Sin(rad(l))
+	cosDec := math.Cos(math.Asin(sinDec))
+	cosH := (math.Cos(rad(zenith)) - sinDec*math.Sin(rad(lat))) / (cosDec * math.Cos(rad(lat)))
+	if cosH > 1 {
+		return time.Time{}, ErrPolarNight
+	}
+	if cosH < -1 {
+		return time.Time{}, ErrMidnightSun
+	}
+	h := deg(math.Acos(cosH))
+	if rising {
+		h = 360 - h
+	}
+	h /= 15
+	local := h + ra - 0.06571*n - 6.622
+	ut := mod(local-lngHour, 24)
+
+	y, mo, d := t.Date()
+	rv := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC).Add(time.Duration(ut * float64(time.Hour))).In(t.Location())
+	// UT may be on the day before or after t's date
+	if ly, lm, ld := rv.Date(); time.Date(ly, lm, ld, 0, 0, 0, 0, time.UTC).Before(time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)) {
+		rv = rv.AddDate(0, 0, 1)
+	} else if ld != d {
+		rv = rv.AddDate(0, 0, -1)
+	}
+	return rv, nil
+}
+
+// DarkByLocation returns a function that returns true if it's dark at the
+// given latitude and longitude at time t
+func DarkByLocation(lat, lon float64) func(t time.Time) bool {
+	return func(t time.Time) bool {
+		sunrise, sunset, err := Sun(t, lat, lon)
+		switch {
+		case errors.Is(err, ErrPolarNight):
+			return true
+		case err != nil:
+			return false
+		}
+		return t.Before(sunrise) || !t.Before(sunset)
+	}
+}
+
+// DarkByClock returns a function that returns true if the time of day of t is
+// between start and end. The window may cross midnight.
+func DarkByClock(start, end time.Duration) func(t time.Time) bool {
+	return func(t time.Time) bool {
+		s, e := atClock(t, start), atClock(t, end)
+		if !s.After(e) {
+			return !t.Before(s) && t.Before(e)
+		}
+		return !t.Before(s) || t.Before(e)
+	}
+}
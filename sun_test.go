@@ -0,0 +1,92 @@
+package schellydule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSun(t *testing.T) {
+	cest := time.FixedZone("CEST", 2*60*60)
+	est := time.FixedZone("EST", -5*60*60)
+	tests := []struct {
+		name        string
+		t           time.Time
+		lat, lon    float64
+		wantSunrise time.Time
+		wantSunset  time.Time
+		wantErr     error
+	}{
+		{
+			name:        "Copenhagen, midsummer",
+			t:           time.Date(2022, 6, 21, 12, 0, 0, 0, cest),
+			lat:         55.68,
+			lon:         12.57,
+			wantSunrise: time.Date(2022, 6, 21, 4, 25, 0, 0, cest),
+			wantSunset:  time.Date(2022, 6, 21, 21, 57, 0, 0, cest),
+		},
+		{
+			name:        "New York, winter",
+			t:           time.Date(2022, 12, 21, 0, 0, 0, 0, est),
+			lat:         40.71,
+			lon:         -74.01,
+			wantSunrise: time.Date(2022, 12, 21, 7, 17, 0, 0, est),
+			wantSunset:  time.Date(2022, 12, 21, 16, 32, 0, 0, est),
+		},
+		{
+			name:    "Tromsø, winter",
+			t:       time.Date(2022, 12, 21, 12, 0, 0, 0, time.UTC),
+			lat:     69.65,
+			lon:     18.96,
+			wantErr: ErrPolarNight,
+		},
+		{
+			name:    "Tromsø, summer",
+			t:       time.Date(2022, 6, 21, 12, 0, 0, 0, time.UTC),
+			lat:     69.65,
+			lon:     18.96,
+			wantErr: ErrMidnightSun,
+		},
+	}
+	within := func(a, b time.Time) bool {
+		d := a.Sub(b)
+		return d < 5*time.Minute && d > -5*time.Minute
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sunrise, sunset, err := Sun(tt.t, tt.lat, tt.lon)
+			if err != tt.wantErr {
+				t.Fatalf("Sun() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !within(sunrise, tt.wantSunrise) || !within(sunset, tt.wantSunset) {
+				t.Errorf("Sun() = %s - %s, want %s - %s", sunrise, sunset, tt.wantSunrise, tt.wantSunset)
+			}
+		})
+	}
+}
+
+func TestDarkByClock(t *testing.T) {
+	at := func(h int) time.Time { return day.Add(time.Duration(h) * time.Hour) }
+	night := DarkByClock(22*time.Hour, 6*time.Hour)
+	evening := DarkByClock(18*time.Hour, 23*time.Hour)
+	tests := []struct {
+		dark func(time.Time) bool
+		t    time.Time
+		want bool
+	}{
+		{dark: night, t: at(23), want: true},
+		{dark: night, t: at(3), want: true},
+		{dark: night, t: at(6), want: false},
+		{dark: night, t: at(12), want: false},
+		{dark: evening, t: at(18), want: true},
+		{dark: evening, t: at(23), want: false},
+		{dark: evening, t: at(3), want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.dark(tt.t); got != tt.want {
+			t.Errorf("dark(%s) = %t, want %t", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package schellydule
+
+import (
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// TariffPeriod is a grid tariff per kWh, in a period of the day in some months
+type TariffPeriod struct {
+	// Months are the months the tariff applies in. Empty means all year
+	Months []time.Month
+	// From and To are the times of day the tariff applies between. The period
+	// crosses midnight if To is before From
+	From, To time.Duration
+	Price    float64
+}
+
+// contains returns true if the period includes the hour starting at t
+func (p TariffPeriod) contains(t time.Time) bool {
+	if len(p.Months) > 0 {
+		found := false
+		for _, m := range p.Months {
+			found = found || m == t.Month()
+		}
+		if !found {
+			return false
+		}
+	}
+	c := sinceMidnight(t)
+	if p.From < p.To {
+		return c >= p.From && c < p.To
+	}
+	return c >= p.From || c < p.To
+}
+
+// Tariff is what's paid per kWh on top of the spot price
+type Tariff struct {
+	// Periods are the grid tariffs. The first period including an hour applies
+	Periods []TariffPeriod
+	// Tax is the taxes and fees per kWh
+	Tax float64
+	// VAT is the rate of VAT on the spot price, tariff and tax, like 0.25
+	VAT float64
+}
+
+// PriceParts is a price or cost, broken down
+type PriceParts struct {
+	Spot   float64 `json:"spot"`
+	Tariff float64 `json:"tariff"`
+	Tax    float64 `json:"tax"`
+	VAT    float64 `json:"vat"`
+}
+
+// Total returns the sum of the parts
+func (p PriceParts) Total() float64 {
+	return p.Spot + p.Tariff + p.Tax + p.VAT
+}
+
+// Empty returns true if t adds nothing to the spot price
+func (t Tariff) Empty() bool {
+	return len(t.Periods) == 0 && t.Tax == 0 && t.VAT == 0
+}
+
+// Parts returns the price per kWh of the hour starting at `at`, with the spot
+// price spot
+func (t Tariff) Parts(at time.Time, spot float64) PriceParts {
+	rv := PriceParts{Spot: spot, Tax: t.Tax}
+	for _, p := range t.Periods {
+		if p.contains(at) {
+			rv.Tariff = p.Price
+			break
+		}
+	}
+	rv.VAT = (rv.Spot + rv.Tariff + rv.Tax) * t.VAT
+	return rv
+}
+
+// Apply returns hp with the spot prices replaced by the full prices. The times
+// of day of the tariff periods are in loc.
+func (t Tariff) Apply(hp sch.HourPrices, loc *time.Location) sch.HourPrices {
+	rv := make(sch.HourPrices, len(hp))
+	for i, h := range hp {
+		h.Price = t.Parts(h.Hour.In(loc), h.Price).Total()
+		rv[i] = h
+	}
+	return rv
+}
+
+// Breakdown returns the cost of running a 1 kW load according to s, with the
+// spot prices spot, broken down. Each price covers the shortest time between
+// two prices, up to an hour, as in SlotsFromHourPrices. Time without a spot
+// price isn't counted. The times of day of the tariff periods are in the time
+// zone of the entries of s.
+func (t Tariff) Breakdown(s sch.Schedule, spot sch.HourPrices) PriceParts {
+	var rv PriceParts
+	slots := SlotsFromHourPrices(spot)
+	for _, e := range s {
+		for _, sl := range slots {
+			start, stop := sl.Start, sl.End()
+			if e.Start.After(start) {
+				start = e.Start
+			}
+			if e.Stop.Before(stop) {
+				stop = e.Stop
+			}
+			if !stop.After(start) {
+				continue
+			}
+			hours := stop.Sub(start).Hours()
+			p := t.Parts(sl.Start.In(e.Start.Location()), sl.Price)
+			rv.Spot += p.Spot * hours
+			rv.Tariff += p.Tariff * hours
+			rv.Tax += p.Tax * hours
+			rv.VAT += p.VAT * hours
+		}
+	}
+	return rv
+}
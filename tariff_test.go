@@ -0,0 +1,95 @@
+package schellydule
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	sch "github.com/adamhassel/schedule"
+)
+
+// testTariff has a winter peak tariff between 17 and 21, and a night tariff
+var testTariff = Tariff{
+	Periods: []TariffPeriod{
+		{Months: []time.Month{time.October, time.November, time.December, time.January, time.February, time.March}, From: 17 * time.Hour, To: 21 * time.Hour, Price: 1},
+		{From: 22 * time.Hour, To: 6 * time.Hour, Price: 0.1},
+		{From: 0, To: 24 * time.Hour, Price: 0.3},
+	},
+	Tax: 0.5,
+	VAT: 0.25,
+}
+
+func TestTariff_Parts(t *testing.T) {
+	winter := time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		at   time.Time
+		want PriceParts
+	}{
+		{name: "winter peak", at: winter.Add(18 * time.Hour), want: PriceParts{Spot: 1, Tariff: 1, Tax: 0.5, VAT: 0.625}},
+		{name: "summer evening", at: day.Add(18 * time.Hour), want: PriceParts{Spot: 1, Tariff: 0.3, Tax: 0.5, VAT: 0.45}},
+		{name: "night", at: day.Add(2 * time.Hour), want: PriceParts{Spot: 1, Tariff: 0.1, Tax: 0.5, VAT: 0.4}},
+		{name: "late night", at: winter.Add(23 * time.Hour), want: PriceParts{Spot: 1, Tariff: 0.1, Tax: 0.5, VAT: 0.4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := testTariff.Parts(tt.at, 1)
+			if !closeParts(got, tt.want) {
+				t.Errorf("Parts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTariff_Apply(t *testing.T) {
+	hp := sch.HourPrices{{Hour: day.Add(2 * time.Hour), Price: 1}, {Hour: day.Add(12 * time.Hour), Price: 1}}
+	got := testTariff.Apply(hp, time.UTC)
+	if math.Abs(got[0].Price-2) > 1e-9 || math.Abs(got[1].Price-2.25) > 1e-9 {
+		t.Errorf("Apply() = %v, want prices 2 and 2.25", got)
+	}
+	if hp[0].Price != 1 {
+		t.Error("Apply() changed its argument")
+	}
+	if got := (Tariff{}).Apply(hp, time.UTC); got[0].Price != 1 {
+		t.Errorf("empty tariff Apply() = %v, want the spot price", got)
+	}
+}
+
+func TestTariff_Breakdown(t *testing.T) {
+	spot := sch.HourPrices{
+		{Hour: day.Add(2 * time.Hour), Price: 1},
+		{Hour: day.Add(3 * time.Hour), Price: 2},
+		{Hour: day.Add(12 * time.Hour), Price: 1},
+	}
+	s := sch.Schedule{
+		{Start: day.Add(2*time.Hour + 30*time.Minute), Stop: day.Add(4 * time.Hour)},
+		{Start: day.Add(12 * time.Hour), Stop: day.Add(13 * time.Hour)},
+	}
+	got := testTariff.Breakdown(s, spot)
+	want := PriceParts{Spot: 0.5 + 2 + 1, Tariff: 0.05 + 0.1 + 0.3, Tax: 1.25}
+	want.VAT = (want.Spot + want.Tariff + want.Tax) * 0.25
+	if !closeParts(got, want) {
+		t.Errorf("Breakdown() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTariff_Breakdown_quarterHours(t *testing.T) {
+	var spot sch.HourPrices
+	for i := 0; i < 8; i++ {
+		spot = append(spot, sch.HourPrice{Hour: day.Add(12*time.Hour + time.Duration(i)*15*time.Minute), Price: 1})
+	}
+	s := sch.Schedule{{Start: day.Add(12 * time.Hour), Stop: day.Add(13 * time.Hour)}}
+	got := Tariff{}.Breakdown(s, spot)
+	if want := (PriceParts{Spot: 1}); !closeParts(got, want) {
+		t.Errorf("Breakdown() = %+v, want %+v", got, want)
+	}
+}
+
+func closeParts(a, b PriceParts) bool {
+	for _, d := range []float64{a.Spot - b.Spot, a.Tariff - b.Tariff, a.Tax - b.Tax, a.VAT - b.VAT} {
+		if math.Abs(d) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
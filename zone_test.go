@@ -0,0 +1,114 @@
+package schellydule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	sch "github.com/adamhassel/schedule"
+	"github.com/adamhassel/schellydule/shelly"
+)
+
+var zones = []string{"UTC", "Europe/Copenhagen", "America/New_York", "Asia/Kolkata", "Pacific/Auckland"}
+
+func TestParseScheduleIn(t *testing.T) {
+	job := shelly.JobSpec{Enable: true, Timespec: "0 30 7 * * *"}
+	for _, zone := range zones {
+		t.Run(zone, func(t *testing.T) {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ParseScheduleIn(job, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trigger := got.TriggerTime()
+			if trigger.Location() != loc {
+				t.Errorf("ParseScheduleIn() location = %s, want %s", trigger.Location(), loc)
+			}
+			if trigger.Hour() != 7 || trigger.Minute() != 30 {
+				t.Errorf("ParseScheduleIn() time = %s, want 07:30", trigger.Format("15:04"))
+			}
+			y, m, d := time.Now().In(loc).Date()
+			if ty, tm, td := trigger.Date(); ty != y || tm != m || td != d {
+				t.Errorf("ParseScheduleIn() date = %s, want today in %s", trigger.Format("2006-01-02"), loc)
+			}
+		})
+	}
+}
+
+func TestScheduleIn(t *testing.T) {
+	jobs := shelly.Schedules{
+		{Id: 0, Enable: true, Timespec: "0 0 22 * * *", Calls: []shelly.Call{{Method: "switch.set", Params: map[string]interface{}{"on": true}}}},
+		{Id: 1, Enable: true, Timespec: "0 0 23 * * *", Calls: []shelly.Call{{Method: "switch.set", Params: map[string]interface{}{"on": false}}}},
+	}
+	for _, zone := range zones {
+		t.Run(zone, func(t *testing.T) {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ScheduleIn(jobs, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("ScheduleIn() got %d entries, want 1", len(got))
+			}
+			start, stop := got[0].Start.In(loc), got[0].Stop.In(loc)
+			if start.Hour() != 22 || stop.Hour() != 23 {
+				t.Errorf("ScheduleIn() = %s-%s, want 22:00-23:00", start.Format("15:04"), stop.Format("15:04"))
+			}
+			if stop.Sub(start) != time.Hour {
+				t.Errorf("ScheduleIn() length = %s, want 1h", stop.Sub(start))
+			}
+		})
+	}
+}
+
+func TestSplitDaysIn(t *testing.T) {
+	// 20:00-02:00 UTC crosses midnight in UTC, but not in New York, and at
+	// another time in Copenhagen
+	s := sch.Schedule{entry(20, 26, 6)}
+	tests := []struct {
+		zone string
+		want int
+	}{
+		{zone: "UTC", want: 2},
+		{zone: "America/New_York", want: 1},
+		{zone: "Europe/Copenhagen", want: 2},
+		{zone: "Asia/Tokyo", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.zone, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := SplitDays(In(s, loc))
+			if len(got) != tt.want {
+				t.Fatalf("SplitDays() got %d entries, want %d", len(got), tt.want)
+			}
+			for _, e := range got[1:] {
+				if e.Start.In(loc).Hour() != 0 {
+					t.Errorf("SplitDays() split at %s, want local midnight", e.Start.In(loc).Format("15:04"))
+				}
+			}
+		})
+	}
+}
+
+func TestSlots_In(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := hourSlots(1, 2).In(loc)
+	if got := s[0].Start.Format("15:04"); got != "05:30" {
+		t.Errorf("In() start = %s, want 05:30", got)
+	}
+	if !s[1].Start.Equal(day.Add(time.Hour)) {
+		t.Errorf("In() changed the instant to %s", s[1].Start)
+	}
+}